@@ -0,0 +1,74 @@
+package bigquery
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/cmd/core"
+	"github.com/pingcap-inc/tidb2dw/pkg/bqsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewBigQueryCmd() *cobra.Command {
+	var (
+		replicateConfig       core.ReplicateConfig
+		bigqueryConfigFromCli bqsql.BigQueryConfig
+		// BigQuery loads data from GCS, the AWS credential is not used.
+		credValue credentials.Value
+	)
+
+	run := func() error {
+		return core.Replicate(&replicateConfig, &credValue, func(stageName string, storageURI *url.URL) (coreinterfaces.Connector, error) {
+			client, err := bigqueryConfigFromCli.NewClient()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			connector, err := bqsql.NewBigQueryConnector(
+				client,
+				bigqueryConfigFromCli.DatasetID,
+				stageName,
+				storageURI,
+			)
+			if err != nil {
+				client.Close()
+				return nil, errors.Trace(err)
+			}
+			return connector, nil
+		})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bigquery",
+		Short: "Replicate snapshot and incremental data from TiDB to BigQuery",
+		Run: func(_ *cobra.Command, _ []string) {
+			// init logger
+			err := logutil.InitLogger(&logutil.Config{
+				Level: replicateConfig.LogLevel,
+				File:  replicateConfig.LogFile,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			if err = run(); err != nil {
+				log.Error("Error running bigquery replication", zap.Error(err))
+			}
+		},
+	}
+
+	// The increment files are loaded by BigQuery with double quotes as the quote character
+	replicateConfig.CSVQuote = `"`
+	replicateConfig.AddFlags(cmd)
+	cmd.Flags().StringVar(&bigqueryConfigFromCli.ProjectID, "bigquery.project-id", "", "bigquery project id")
+	cmd.Flags().StringVar(&bigqueryConfigFromCli.DatasetID, "bigquery.dataset", "", "bigquery dataset")
+	cmd.Flags().StringVar(&bigqueryConfigFromCli.Location, "bigquery.location", "", "bigquery location of the dataset, e.g. US")
+	cmd.Flags().StringVar(&bigqueryConfigFromCli.CredentialsFile, "bigquery.credentials-file", "", "bigquery service account key file, use the application default credentials if not specified")
+	cmd.Flags().StringVar(&bigqueryConfigFromCli.Endpoint, "bigquery.endpoint", "", "bigquery API endpoint, e.g. http://localhost:9050 for a local BigQuery emulator")
+
+	return cmd
+}
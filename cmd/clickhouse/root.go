@@ -0,0 +1,84 @@
+package clickhouse
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/cmd/core"
+	"github.com/pingcap-inc/tidb2dw/pkg/clickhousesql"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewClickHouseCmd() *cobra.Command {
+	var (
+		replicateConfig         core.ReplicateConfig
+		clickhouseConfigFromCli clickhousesql.ClickHouseConfig
+		credValue               credentials.Value
+	)
+
+	run := func() error {
+		return core.Replicate(&replicateConfig, &credValue, func(_ string, storageURI *url.URL) (coreinterfaces.Connector, error) {
+			db, err := clickhouseConfigFromCli.OpenDB()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			connector, err := clickhousesql.NewClickHouseConnector(
+				db,
+				storageURI,
+				&credValue,
+			)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return connector, nil
+		})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "clickhouse",
+		Short: "Replicate snapshot and incremental data from TiDB to ClickHouse",
+		Run: func(_ *cobra.Command, _ []string) {
+			// init logger
+			err := logutil.InitLogger(&logutil.Config{
+				Level: replicateConfig.LogLevel,
+				File:  replicateConfig.LogFile,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			uri, err := url.Parse(replicateConfig.StoragePath)
+			if err != nil {
+				panic(err)
+			}
+			if uri.Scheme == "s3" {
+				// resolve aws credential
+				creds := credentials.NewEnvCredentials()
+				credValue, err = creds.Get()
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			if err = run(); err != nil {
+				log.Error("Error running clickhouse replication", zap.Error(err))
+			}
+		},
+	}
+
+	// The CSV format of ClickHouse encloses the fields by double quotes
+	replicateConfig.CSVQuote = `"`
+	replicateConfig.AddFlags(cmd)
+	cmd.Flags().StringVar(&clickhouseConfigFromCli.Host, "clickhouse.host", "127.0.0.1", "clickhouse host")
+	cmd.Flags().IntVar(&clickhouseConfigFromCli.Port, "clickhouse.port", 9000, "clickhouse native protocol port")
+	cmd.Flags().StringVar(&clickhouseConfigFromCli.User, "clickhouse.user", "default", "clickhouse user")
+	cmd.Flags().StringVar(&clickhouseConfigFromCli.Pass, "clickhouse.pass", "", "clickhouse password")
+	cmd.Flags().StringVar(&clickhouseConfigFromCli.Database, "clickhouse.database", "default", "clickhouse database")
+
+	return cmd
+}
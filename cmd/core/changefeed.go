@@ -0,0 +1,226 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	filter "github.com/pingcap/tidb/util/table-filter"
+	cdcv2 "github.com/pingcap/tiflow/cdc/api/v2"
+	putil "github.com/pingcap/tiflow/pkg/util"
+	"go.uber.org/zap"
+)
+
+func genSinkURI(storagePath string, flushInterval time.Duration, fileSize int64) (*url.URL, error) {
+	sinkUri, err := url.Parse(storagePath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	values := sinkUri.Query()
+	values.Add("flush-interval", flushInterval.String())
+	values.Add("file-size", fmt.Sprint(fileSize))
+	values.Add("protocol", "csv")
+	if sinkUri.Scheme == "s3" {
+		creds := credentials.NewEnvCredentials()
+		credValue, err := creds.Get()
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to resolve AWS credential")
+		}
+		values.Add("access-key", credValue.AccessKeyID)
+		values.Add("secret-access-key", credValue.SecretAccessKey)
+		if credValue.SessionToken != "" {
+			values.Add("session-token", credValue.SessionToken)
+		}
+	} else if sinkUri.Scheme == "gcs" {
+		credValue, found := syscall.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if !found {
+			return nil, errors.New("Failed to resolve GCS credential")
+		} else {
+			values.Add("credentials-file", credValue)
+		}
+	} else if storageutil.IsAzureScheme(sinkUri.Scheme) {
+		azureCred, err := storageutil.GetAzureCredentialFromEnv()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		values.Add("account-name", azureCred.AccountName)
+		if azureCred.AccountKey != "" {
+			values.Add("account-key", azureCred.AccountKey)
+		} else {
+			log.Warn("AZURE_STORAGE_KEY is not set, TiCDC should be able to access the storage with its own credential")
+		}
+	} else if sinkUri.Scheme == "file" {
+		// TiCDC writes the files into its own local file system, so the path must be
+		// shared with tidb2dw, e.g. both of them are running on the same host.
+	} else {
+		return nil, errors.Errorf("get sink uri failed, unsupported uri schema: %s", sinkUri.Scheme)
+	}
+	sinkUri.RawQuery = values.Encode()
+	return sinkUri, nil
+}
+
+// createChangefeed creates one changefeed which captures all the tables matched by tableFilterRules,
+// and returns the id of the changefeed. The CSV fields are quoted by csvQuote, or escaped by backslash if it is empty.
+func createChangefeed(cdcServer string, sinkURI *url.URL, tableFilterRules []string, startTSO uint64, csvQuote string) (string, error) {
+	client := &http.Client{}
+	cfCfg := &cdcv2.ChangefeedConfig{
+		SinkURI: sinkURI.String(),
+		ReplicaConfig: &cdcv2.ReplicaConfig{
+			Filter: &cdcv2.FilterConfig{Rules: tableFilterRules},
+			Sink: &cdcv2.SinkConfig{
+				CSVConfig:          &cdcv2.CSVConfig{IncludeCommitTs: true, Quote: csvQuote, Delimiter: ","},
+				CloudStorageConfig: &cdcv2.CloudStorageConfig{OutputColumnID: putil.AddressOf(true)},
+			},
+			EnableOldValue: false,
+		},
+		StartTs: 0,
+	}
+	if startTSO != 0 {
+		cfCfg.StartTs = startTSO
+	}
+	bytesData, _ := json.Marshal(cfCfg)
+	url, err := url.JoinPath(cdcServer, "api/v2/changefeeds")
+	if err != nil {
+		return "", errors.Annotate(err, "join url failed")
+	}
+	httpReq, _ := http.NewRequest("POST", url, bytes.NewReader(bytesData))
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("create changefeed failed, status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	respData := make(map[string]interface{})
+	if err = json.Unmarshal(body, &respData); err != nil {
+		return "", errors.Trace(err)
+	}
+	changefeedID := respData["id"].(string)
+	replicateConfig := respData["config"].(map[string]interface{})
+	log.Info("create changefeed success", zap.String("changefeed-id", changefeedID), zap.Any("replica-config", replicateConfig))
+
+	return changefeedID, nil
+}
+
+// changefeedIDPath is the path of the file which records the id of the changefeed created by tidb2dw.
+// It has no `.csv` or `.json` suffix, so the consumer will not handle it as a dml file.
+const changefeedIDPath = "increment/tidb2dw.changefeed"
+
+// saveChangefeedID records the id of the changefeed in the storage, so that it can be updated after restart.
+func saveChangefeedID(ctx context.Context, extStorage storage.ExternalStorage, changefeedID string) error {
+	return errors.Trace(extStorage.WriteFile(ctx, changefeedIDPath, []byte(changefeedID)))
+}
+
+// loadChangefeedID returns the id of the changefeed created by tidb2dw, or empty if not found.
+func loadChangefeedID(ctx context.Context, extStorage storage.ExternalStorage) (string, error) {
+	exist, err := extStorage.FileExists(ctx, changefeedIDPath)
+	if err != nil || !exist {
+		return "", errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, changefeedIDPath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(data), nil
+}
+
+// requestCDC sends the request to the TiCDC open api, and decodes the response into result if not nil.
+func requestCDC(method, cdcServer, path string, body, result interface{}) error {
+	url, err := url.JoinPath(cdcServer, path)
+	if err != nil {
+		return errors.Annotate(err, "join url failed")
+	}
+	var reader io.Reader
+	if body != nil {
+		bytesData, err := json.Marshal(body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		reader = bytes.NewReader(bytesData)
+	}
+	httpReq, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("%s %s failed, status code: %d, response: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if result != nil {
+		if err = json.Unmarshal(respBody, result); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// captureRenamedTable adds the renamed table into the filter rules of the changefeed if it is not matched yet.
+// The changefeed is paused to update, and resumed from the commit ts of the rename DDL,
+// so that the changes of the renamed table after the DDL are not missed.
+func captureRenamedTable(cdcServer, changefeedID string, table tidbsql.TableFQN, renameTs uint64) error {
+	changefeedPath := fmt.Sprintf("api/v2/changefeeds/%s", url.PathEscape(changefeedID))
+	var info cdcv2.ChangeFeedInfo
+	if err := requestCDC(http.MethodGet, cdcServer, changefeedPath, nil, &info); err != nil {
+		return errors.Annotate(err, "get changefeed failed")
+	}
+	if info.Config == nil || info.Config.Filter == nil {
+		return errors.Errorf("the filter of changefeed %s is not found", changefeedID)
+	}
+	tableFilter, err := filter.Parse(info.Config.Filter.Rules)
+	if err != nil {
+		return errors.Annotate(err, "Failed to parse table filter rules")
+	}
+	if tableFilter.MatchTable(table.Schema, table.Name) {
+		log.Info("The renamed table is already captured by changefeed", zap.String("changefeed-id", changefeedID), zap.Stringer("table", table))
+		return nil
+	}
+
+	info.Config.Filter.Rules = append(info.Config.Filter.Rules, quoteFilterRule(table))
+	if err = requestCDC(http.MethodPost, cdcServer, changefeedPath+"/pause", nil, nil); err != nil {
+		return errors.Annotate(err, "pause changefeed failed")
+	}
+	if err = requestCDC(http.MethodPut, cdcServer, changefeedPath, &cdcv2.ChangefeedConfig{ReplicaConfig: info.Config}, nil); err != nil {
+		return errors.Annotate(err, "update changefeed failed")
+	}
+	if err = requestCDC(http.MethodPost, cdcServer, changefeedPath+"/resume", &cdcv2.ResumeChangefeedConfig{OverwriteCheckpointTs: renameTs}, nil); err != nil {
+		return errors.Annotate(err, "resume changefeed failed")
+	}
+	log.Info("The renamed table is added into the filter of changefeed", zap.String("changefeed-id", changefeedID),
+		zap.Strings("rules", info.Config.Filter.Rules), zap.Uint64("resume-ts", renameTs))
+	return nil
+}
+
+// quoteFilterRule returns the table filter rule which only matches the table.
+func quoteFilterRule(table tidbsql.TableFQN) string {
+	quote := func(name string) string {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return fmt.Sprintf("%s.%s", quote(table.Schema), quote(table.Name))
+}
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	putil "github.com/pingcap/tiflow/pkg/util"
+	"github.com/spf13/cobra"
+	"github.com/thediveo/enumflag"
+	"go.uber.org/zap"
+)
+
+type RunMode enumflag.Flag
+
+const (
+	RunModeFull RunMode = iota
+	RunModeSnapshotOnly
+	RunModeIncrementalOnly
+)
+
+var RunModeIds = map[RunMode][]string{
+	RunModeFull:            {"full"},
+	RunModeSnapshotOnly:    {"snapshot-only"},
+	RunModeIncrementalOnly: {"incremental-only"},
+}
+
+// ReplicateConfig is the configuration shared by all the data warehouse commands.
+type ReplicateConfig struct {
+	TiDBConfig           tidbsql.TiDBConfig
+	TableFilterRules     []string
+	SnapshotConcurrency  int
+	StoragePath          string
+	StorageS3Options     storageutil.S3Options
+	CDCHost              string
+	CDCPort              int
+	CDCFlushInterval     time.Duration
+	CDCFileSize          int64
+	Timezone             string
+	LogFile              string
+	LogLevel             string
+	SinkURIStr           string
+	KeepIncrementFiles   bool
+	IncrementConcurrency int
+	Mode                 RunMode
+	// CSVQuote is the quote character of the CSV files written by the changefeed, which must match
+	// how the connector reads them. Empty means the fields are not quoted and the special characters
+	// are escaped by backslash.
+	CSVQuote string
+}
+
+// AddFlags registers the flags shared by all the data warehouse commands.
+func (cfg *ReplicateConfig) AddFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	cmd.Flags().Var(enumflag.New(&cfg.Mode, "mode", RunModeIds, enumflag.EnumCaseInsensitive), "full", "replication mode: full, snapshot-only, incremental-only")
+	cmd.Flags().StringVarP(&cfg.TiDBConfig.Host, "tidb.host", "h", "127.0.0.1", "TiDB host")
+	cmd.Flags().IntVarP(&cfg.TiDBConfig.Port, "tidb.port", "P", 4000, "TiDB port")
+	cmd.Flags().StringVarP(&cfg.TiDBConfig.User, "tidb.user", "u", "root", "TiDB user")
+	cmd.Flags().StringVarP(&cfg.TiDBConfig.Pass, "tidb.pass", "p", "", "TiDB password")
+	cmd.Flags().StringVar(&cfg.TiDBConfig.SSLCA, "tidb.ssl-ca", "", "TiDB SSL CA")
+	cmd.Flags().StringSliceVarP(&cfg.TableFilterRules, "table", "t", nil, "table filter rules, can be specified multiple times: <database>.<table>, <database>.*, !<database>.<table>")
+	cmd.Flags().IntVar(&cfg.SnapshotConcurrency, "snapshot-concurrency", 8, "the number of concurrent snapshot workers")
+	cmd.Flags().StringVarP(&cfg.StoragePath, "storage", "s", "", "storage path: s3://<bucket>/<path>, gcs://<bucket>/<path>, azure://<container>/<path> or file:///<path>")
+	cmd.Flags().StringVar(&cfg.StorageS3Options.Endpoint, "storage.endpoint", "", "endpoint of the S3 compatible storage, e.g. http://127.0.0.1:9000 for MinIO")
+	cmd.Flags().StringVar(&cfg.StorageS3Options.Region, "storage.region", "", "region of the S3 bucket, resolved from AWS S3 if not specified")
+	cmd.Flags().BoolVar(&cfg.StorageS3Options.ForcePathStyle, "storage.force-path-style", false, "access the S3 compatible storage with path style url, which is required by MinIO")
+	cmd.Flags().StringVar(&cfg.CDCHost, "cdc.host", "127.0.0.1", "TiCDC server host")
+	cmd.Flags().IntVar(&cfg.CDCPort, "cdc.port", 8300, "TiCDC server port")
+	cmd.Flags().DurationVar(&cfg.CDCFlushInterval, "cdc.flush-interval", 60*time.Second, "")
+	cmd.Flags().Int64Var(&cfg.CDCFileSize, "cdc.file-size", 64*1024*1024, "")
+	cmd.Flags().StringVar(&cfg.Timezone, "tz", "System", "specify time zone of storage consumer")
+	cmd.Flags().StringVar(&cfg.LogFile, "log.file", "", "log file path")
+	cmd.Flags().StringVar(&cfg.LogLevel, "log.level", "info", "log level")
+	cmd.Flags().StringVar(&cfg.SinkURIStr, "sink-uri", "", "sink uri, only needed under incremental-only mode")
+	cmd.Flags().IntVar(&cfg.IncrementConcurrency, "increment-concurrency", 8, "the max number of tables whose incremental data are applied concurrently")
+	cmd.Flags().BoolVar(&cfg.KeepIncrementFiles, "keep-increment-files", false, "keep the incremental data files in storage after they are applied, e.g. for audit")
+}
+
+// NewConnectorFunc creates a data warehouse connector with its own connection.
+type NewConnectorFunc func(stageName string, storageURI *url.URL) (coreinterfaces.Connector, error)
+
+// resolveTables returns all the tables matched by the table filter rules.
+func resolveTables(tidbConfig *tidbsql.TiDBConfig, tableFilterRules []string) ([]tidbsql.TableFQN, error) {
+	db, err := tidbConfig.OpenDB()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer db.Close()
+	tables, err := tidbsql.GetTablesByFilter(db, tableFilterRules)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(tables) == 0 {
+		return nil, errors.Errorf("no table matches the table filter rules %v", tableFilterRules)
+	}
+	// All the tables are replicated into one schema of the data warehouse,
+	// so tables with the same name in different databases will conflict.
+	tableNames := make(map[string]tidbsql.TableFQN, len(tables))
+	for _, table := range tables {
+		if prev, ok := tableNames[table.Name]; ok {
+			return nil, errors.Errorf("table %s and %s have the same name, which is not supported", prev, table)
+		}
+		tableNames[table.Name] = table
+	}
+	log.Info("Resolved tables to replicate", zap.Any("tables", tables))
+	return tables, nil
+}
+
+// startTSOPath is the path of the file which records the tso of the snapshot and the start ts of the changefeed.
+// It has no `.csv` or `.json` suffix, so the consumer will not handle it as a dml file.
+const startTSOPath = "increment/tidb2dw.start_tso"
+
+// saveStartTSO records the start tso in the storage, so that it is reused after restart.
+func saveStartTSO(ctx context.Context, extStorage storage.ExternalStorage, startTSO uint64) error {
+	return errors.Trace(extStorage.WriteFile(ctx, startTSOPath, []byte(strconv.FormatUint(startTSO, 10))))
+}
+
+// loadStartTSO returns the start tso recorded in the storage, or 0 if not found.
+func loadStartTSO(ctx context.Context, extStorage storage.ExternalStorage) (uint64, error) {
+	exist, err := extStorage.FileExists(ctx, startTSOPath)
+	if err != nil || !exist {
+		return 0, errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, startTSOPath)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	startTSO, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid start tso in %s", startTSOPath)
+	}
+	return startTSO, nil
+}
+
+// snapshotLoadinfoPath returns the path of the loadinfo file of the table,
+// the file exists means the snapshot of the table has been all loaded into data warehouse.
+func snapshotLoadinfoPath(table tidbsql.TableFQN) string {
+	return fmt.Sprintf("snapshot/%s/loadinfo", table)
+}
+
+// Replicate replicates the snapshot and incremental data of all the tables matched
+// by cfg.TableFilterRules from TiDB to the data warehouse.
+func Replicate(cfg *ReplicateConfig, credValue *credentials.Value, newConnector NewConnectorFunc) error {
+	// 0. check status
+	if err := applyStorageS3Options(cfg); err != nil {
+		return errors.Trace(err)
+	}
+	ctx := context.Background()
+	extStorage, err := putil.GetExternalStorageFromURI(ctx, cfg.StoragePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	metadataExist, err := extStorage.FileExists(ctx, "increment/metadata")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var tables []tidbsql.TableFQN
+	if cfg.Mode != RunModeIncrementalOnly || cfg.SinkURIStr == "" {
+		if len(cfg.TableFilterRules) == 0 {
+			return errors.New("--table must be specified")
+		}
+		tables, err = resolveTables(&cfg.TiDBConfig, cfg.TableFilterRules)
+		if err != nil {
+			return errors.Annotate(err, "Failed to resolve tables")
+		}
+	}
+	loadedTables, err := getSnapshotLoadedTables(ctx, extStorage, tables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	loadinfoExist := len(loadedTables) == len(tables)
+
+	// 1. get current tso, the tso of the last run is reused if the snapshot is not all loaded,
+	// so that all the tables are loaded at the same tso which the changefeed starts from.
+	startTSO := uint64(0)
+	if !loadinfoExist {
+		startTSO, err = loadStartTSO(ctx, extStorage)
+		if err != nil {
+			return errors.Annotate(err, "Failed to load start TSO")
+		}
+		if startTSO == 0 {
+			startTSO, err = tidbsql.GetCurrentTSO(&cfg.TiDBConfig)
+			if err != nil {
+				return errors.Annotate(err, "Failed to get current TSO")
+			}
+			if err = saveStartTSO(ctx, extStorage, startTSO); err != nil {
+				return errors.Annotate(err, "Failed to save start TSO")
+			}
+		} else {
+			log.Info("Snapshot data is not all loaded, reuse the start TSO of the last run", zap.Uint64("tso", startTSO))
+		}
+	} else {
+		log.Info("Snapshot data is all loaded, skip get current TSO")
+	}
+
+	var sinkURI *url.URL
+	cdcServer := fmt.Sprintf("http://%s:%d", cfg.CDCHost, cfg.CDCPort)
+
+	// 2. create changefeed
+	if cfg.Mode == RunModeFull || (cfg.Mode == RunModeIncrementalOnly && cfg.SinkURIStr == "") {
+		increStoragePath, err := url.JoinPath(cfg.StoragePath, "increment")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sinkURI, err = genSinkURI(increStoragePath, cfg.CDCFlushInterval, cfg.CDCFileSize)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		changefeedID, err := loadChangefeedID(ctx, extStorage)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if changefeedID != "" {
+			log.Info("Changefeed has been created, skip create changefeed", zap.String("changefeed-id", changefeedID))
+		} else if !loadinfoExist || !metadataExist {
+			changefeedID, err = createChangefeed(cdcServer, sinkURI, cfg.TableFilterRules, startTSO, cfg.CSVQuote)
+			if err != nil {
+				return errors.Annotate(err, "Failed to create changefeed")
+			}
+			if err = saveChangefeedID(ctx, extStorage, changefeedID); err != nil {
+				return errors.Annotate(err, "Failed to save changefeed id")
+			}
+		} else {
+			log.Info("Snapshot has been loaded, Changefeed has been created, skip create changefeed")
+		}
+	} else if cfg.Mode == RunModeIncrementalOnly && cfg.SinkURIStr != "" {
+		uri, err := url.Parse(cfg.SinkURIStr)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sinkURI = uri
+	}
+
+	// 3. run replicate snapshot
+	if cfg.Mode == RunModeFull || cfg.Mode == RunModeSnapshotOnly {
+		for _, table := range tables {
+			if loadedTables[table] {
+				log.Info("Snapshot has been loaded, skip replicate snapshot", zap.Stringer("table", table))
+				continue
+			}
+			snapStoragePath, err := url.JoinPath(cfg.StoragePath, "snapshot", table.String())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			snapshotURI, err := url.Parse(snapStoragePath)
+			if err != nil {
+				return errors.Annotate(err, "Failed to parse workspace path")
+			}
+			connector, err := newConnector(fmt.Sprintf("snapshot_stage_%s", table.Name), snapshotURI)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if err = replicate.StartReplicateSnapshot(connector, &cfg.TiDBConfig, table.Schema, table.Name, cfg.SnapshotConcurrency, snapshotURI, fmt.Sprint(startTSO), credValue); err != nil {
+				return errors.Annotatef(err, "Failed to replicate snapshot of table %s", table)
+			}
+		}
+	}
+
+	// 4. run replicate increment
+	if cfg.Mode == RunModeFull || cfg.Mode == RunModeIncrementalOnly {
+		// The sample connector is only used to clone a connector for each table in the consumer.
+		connector, err := newConnector("increment_stage", sinkURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		changefeedID, err := loadChangefeedID(ctx, extStorage)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		onTableRenamed := func(ctx context.Context, oldTable, newTable tidbsql.TableFQN, renameTs uint64) error {
+			// The snapshot of the renamed table has been loaded as the old table
+			if err := copySnapshotLoadinfo(ctx, extStorage, oldTable, newTable); err != nil {
+				return errors.Trace(err)
+			}
+			if changefeedID == "" {
+				log.Warn("The changefeed is not created by tidb2dw, please make sure the renamed table is captured by it", zap.Stringer("table", newTable))
+				return nil
+			}
+			return errors.Trace(captureRenamedTable(cdcServer, changefeedID, newTable, renameTs))
+		}
+		onTableCreated := func(ctx context.Context, table tidbsql.TableFQN, createTs uint64) error {
+			return errors.Trace(writeCreatedTableLoadinfo(ctx, extStorage, table, createTs))
+		}
+		if err = replicate.StartReplicateIncrement(connector, &cfg.TiDBConfig, sinkURI, cfg.CDCFlushInterval/5, "", cfg.Timezone, cfg.CSVQuote, credValue, cfg.KeepIncrementFiles, cfg.IncrementConcurrency, onTableRenamed, onTableCreated); err != nil {
+			return errors.Annotate(err, "Failed to replicate incremental")
+		}
+	}
+
+	return nil
+}
+
+// applyStorageS3Options adds the S3 options specified by flags into the query of the storage path,
+// so that they are passed to dumpling, TiCDC and the connectors along with the storage uri.
+func applyStorageS3Options(cfg *ReplicateConfig) error {
+	if cfg.StorageS3Options == (storageutil.S3Options{}) {
+		return nil
+	}
+	uri, err := url.Parse(cfg.StoragePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if uri.Scheme != "s3" {
+		return errors.Errorf("--storage.endpoint, --storage.region and --storage.force-path-style are only supported by s3 storage, got %s", uri.Scheme)
+	}
+	storageutil.SetS3Options(uri, cfg.StorageS3Options)
+	cfg.StoragePath = uri.String()
+	return nil
+}
+
+// getSnapshotLoadedTables returns the tables whose snapshot has been all loaded into data warehouse.
+func getSnapshotLoadedTables(ctx context.Context, extStorage storage.ExternalStorage, tables []tidbsql.TableFQN) (map[tidbsql.TableFQN]bool, error) {
+	loadedTables := make(map[tidbsql.TableFQN]bool, len(tables))
+	for _, table := range tables {
+		exist, err := extStorage.FileExists(ctx, snapshotLoadinfoPath(table))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if exist {
+			loadedTables[table] = true
+		}
+	}
+	return loadedTables, nil
+}
+
+// copySnapshotLoadinfo copies the loadinfo file of the old table to the renamed table if exists,
+// so that the renamed table is not replicated from snapshot again after restart.
+func copySnapshotLoadinfo(ctx context.Context, extStorage storage.ExternalStorage, oldTable, newTable tidbsql.TableFQN) error {
+	exist, err := extStorage.FileExists(ctx, snapshotLoadinfoPath(oldTable))
+	if err != nil || !exist {
+		return errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, snapshotLoadinfoPath(oldTable))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, snapshotLoadinfoPath(newTable), data))
+}
+
+// writeCreatedTableLoadinfo writes the loadinfo file of the table created after the changefeed started,
+// the table is empty when created and all its changes are replicated incrementally, so that it is not
+// replicated from snapshot after restart.
+func writeCreatedTableLoadinfo(ctx context.Context, extStorage storage.ExternalStorage, table tidbsql.TableFQN, createTs uint64) error {
+	loadinfo := fmt.Sprintf("Created by DDL at ts %d, no snapshot is needed", createTs)
+	return errors.Trace(extStorage.WriteFile(ctx, snapshotLoadinfoPath(table), []byte(loadinfo)))
+}
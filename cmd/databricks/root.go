@@ -0,0 +1,86 @@
+package databricks
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/cmd/core"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewDatabricksCmd() *cobra.Command {
+	var (
+		replicateConfig         core.ReplicateConfig
+		databricksConfigFromCli databrickssql.DatabricksConfig
+		credValue               credentials.Value
+	)
+
+	run := func() error {
+		return core.Replicate(&replicateConfig, &credValue, func(stageName string, storageURI *url.URL) (coreinterfaces.Connector, error) {
+			db, err := databricksConfigFromCli.OpenDB()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			connector, err := databrickssql.NewDatabricksConnector(
+				db,
+				stageName,
+				storageURI,
+				&credValue,
+			)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return connector, nil
+		})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "databricks",
+		Short: "Replicate snapshot and incremental data from TiDB to Databricks Delta tables",
+		Run: func(_ *cobra.Command, _ []string) {
+			// init logger
+			err := logutil.InitLogger(&logutil.Config{
+				Level: replicateConfig.LogLevel,
+				File:  replicateConfig.LogFile,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			uri, err := url.Parse(replicateConfig.StoragePath)
+			if err != nil {
+				panic(err)
+			}
+			if uri.Scheme == "s3" {
+				// resolve aws credential
+				creds := credentials.NewEnvCredentials()
+				credValue, err = creds.Get()
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			if err = run(); err != nil {
+				log.Error("Error running databricks replication", zap.Error(err))
+			}
+		},
+	}
+
+	// The increment files are read by COPY INTO with double quotes as the quote character
+	replicateConfig.CSVQuote = `"`
+	replicateConfig.AddFlags(cmd)
+	cmd.Flags().StringVar(&databricksConfigFromCli.Host, "databricks.host", "", "databricks server hostname, e.g. dbc-xxx.cloud.databricks.com")
+	cmd.Flags().IntVar(&databricksConfigFromCli.Port, "databricks.port", 443, "databricks server port")
+	cmd.Flags().StringVar(&databricksConfigFromCli.HTTPPath, "databricks.http-path", "", "databricks SQL warehouse http path, e.g. /sql/1.0/warehouses/xxx")
+	cmd.Flags().StringVar(&databricksConfigFromCli.Token, "databricks.token", "", "databricks personal access token")
+	cmd.Flags().StringVar(&databricksConfigFromCli.Catalog, "databricks.catalog", "", "databricks catalog")
+	cmd.Flags().StringVar(&databricksConfigFromCli.Schema, "databricks.schema", "", "databricks schema")
+
+	return cmd
+}
@@ -0,0 +1,71 @@
+package duckdb
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/cmd/core"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/duckdbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewDuckDBCmd() *cobra.Command {
+	var (
+		replicateConfig     core.ReplicateConfig
+		duckdbConfigFromCli duckdbsql.DuckDBConfig
+		credValue           credentials.Value
+	)
+
+	run := func() error {
+		// A DuckDB database file can only be opened once, so the connection is shared by all the connectors
+		db, err := duckdbConfigFromCli.OpenDB()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer db.Close()
+		return core.Replicate(&replicateConfig, &credValue, func(stageName string, storageURI *url.URL) (coreinterfaces.Connector, error) {
+			connector, err := duckdbsql.NewDuckDBConnector(
+				db,
+				duckdbConfigFromCli.Schema,
+				stageName,
+				storageURI,
+				duckdbConfigFromCli.ParquetDir,
+			)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return connector, nil
+		})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "duckdb",
+		Short: "Replicate snapshot and incremental data from TiDB to a local DuckDB database",
+		Run: func(_ *cobra.Command, _ []string) {
+			// init logger
+			err := logutil.InitLogger(&logutil.Config{
+				Level: replicateConfig.LogLevel,
+				File:  replicateConfig.LogFile,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			if err = run(); err != nil {
+				log.Error("Error running duckdb replication", zap.Error(err))
+			}
+		},
+	}
+
+	replicateConfig.AddFlags(cmd)
+	cmd.Flags().StringVar(&duckdbConfigFromCli.Path, "duckdb.path", "tidb2dw.duckdb", "path of the duckdb database file")
+	cmd.Flags().StringVar(&duckdbConfigFromCli.Schema, "duckdb.schema", "main", "duckdb schema")
+	cmd.Flags().StringVar(&duckdbConfigFromCli.ParquetDir, "duckdb.parquet-dir", "", "directory to export the replicated tables as parquet files, empty means no export")
+
+	return cmd
+}
@@ -0,0 +1,73 @@
+package iceberg
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/cmd/core"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/iceberg"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewIcebergCmd() *cobra.Command {
+	var (
+		replicateConfig core.ReplicateConfig
+		warehousePath   string
+		credValue       credentials.Value
+	)
+
+	run := func() error {
+		if warehousePath == "" {
+			path, err := url.JoinPath(replicateConfig.StoragePath, "iceberg")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			warehousePath = path
+		}
+		warehouseURI, err := url.Parse(warehousePath)
+		if err != nil {
+			return errors.Annotate(err, "Failed to parse warehouse path")
+		}
+		if warehouseURI.Scheme == "s3" {
+			// the warehouse is usually in the same S3 compatible storage
+			storageutil.SetS3Options(warehouseURI, replicateConfig.StorageS3Options)
+		}
+		return core.Replicate(&replicateConfig, &credValue, func(_ string, storageURI *url.URL) (coreinterfaces.Connector, error) {
+			connector, err := iceberg.NewIcebergConnector(warehouseURI, storageURI)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return connector, nil
+		})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "iceberg",
+		Short: "Replicate snapshot and incremental data from TiDB to Apache Iceberg tables on the storage",
+		Run: func(_ *cobra.Command, _ []string) {
+			// init logger
+			err := logutil.InitLogger(&logutil.Config{
+				Level: replicateConfig.LogLevel,
+				File:  replicateConfig.LogFile,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			if err = run(); err != nil {
+				log.Error("Error running iceberg replication", zap.Error(err))
+			}
+		},
+	}
+
+	replicateConfig.AddFlags(cmd)
+	cmd.Flags().StringVar(&warehousePath, "iceberg.warehouse", "", "the location to write the iceberg tables, default to <storage>/iceberg")
+
+	return cmd
+}
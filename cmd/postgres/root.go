@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/cmd/core"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/pgsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewPostgresCmd() *cobra.Command {
+	var (
+		replicateConfig       core.ReplicateConfig
+		postgresConfigFromCli pgsql.PostgresConfig
+		credValue             credentials.Value
+	)
+
+	run := func() error {
+		return core.Replicate(&replicateConfig, &credValue, func(stageName string, storageURI *url.URL) (coreinterfaces.Connector, error) {
+			db, err := postgresConfigFromCli.OpenDB()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			connector, err := pgsql.NewPostgresConnector(
+				db,
+				postgresConfigFromCli.Schema,
+				stageName,
+				storageURI,
+			)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return connector, nil
+		})
+	}
+
+	cmd := &cobra.Command{
+		Use:   "postgres",
+		Short: "Replicate snapshot and incremental data from TiDB to PostgreSQL",
+		Run: func(_ *cobra.Command, _ []string) {
+			// init logger
+			err := logutil.InitLogger(&logutil.Config{
+				Level: replicateConfig.LogLevel,
+				File:  replicateConfig.LogFile,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			if err = run(); err != nil {
+				log.Error("Error running postgres replication", zap.Error(err))
+			}
+		},
+	}
+
+	replicateConfig.AddFlags(cmd)
+	cmd.Flags().StringVar(&postgresConfigFromCli.Host, "postgres.host", "127.0.0.1", "postgres host")
+	cmd.Flags().IntVar(&postgresConfigFromCli.Port, "postgres.port", 5432, "postgres port")
+	cmd.Flags().StringVar(&postgresConfigFromCli.User, "postgres.user", "postgres", "postgres user")
+	cmd.Flags().StringVar(&postgresConfigFromCli.Pass, "postgres.pass", "", "postgres password")
+	cmd.Flags().StringVar(&postgresConfigFromCli.Database, "postgres.database", "postgres", "postgres database")
+	cmd.Flags().StringVar(&postgresConfigFromCli.Schema, "postgres.schema", "public", "postgres schema")
+	cmd.Flags().StringVar(&postgresConfigFromCli.SSLMode, "postgres.ssl-mode", "disable", "postgres ssl mode: disable, require, verify-ca or verify-full")
+
+	return cmd
+}
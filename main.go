@@ -3,6 +3,12 @@ package main
 import (
 	"fmt"
 
+	bqCmd "github.com/pingcap-inc/tidb2dw/cmd/bigquery"
+	chCmd "github.com/pingcap-inc/tidb2dw/cmd/clickhouse"
+	dbxCmd "github.com/pingcap-inc/tidb2dw/cmd/databricks"
+	duckCmd "github.com/pingcap-inc/tidb2dw/cmd/duckdb"
+	icebergCmd "github.com/pingcap-inc/tidb2dw/cmd/iceberg"
+	pgCmd "github.com/pingcap-inc/tidb2dw/cmd/postgres"
 	rsCmd "github.com/pingcap-inc/tidb2dw/cmd/redshift"
 	sfCmd "github.com/pingcap-inc/tidb2dw/cmd/snowflake"
 	"github.com/pingcap-inc/tidb2dw/version"
@@ -38,6 +44,12 @@ func init() {
 	rootCmd.AddCommand(
 		sfCmd.NewSnowflakeCmd(),
 		rsCmd.NewRedshiftCmd(),
+		bqCmd.NewBigQueryCmd(),
+		dbxCmd.NewDatabricksCmd(),
+		chCmd.NewClickHouseCmd(),
+		pgCmd.NewPostgresCmd(),
+		duckCmd.NewDuckDBCmd(),
+		icebergCmd.NewIcebergCmd(),
 	)
 }
 
@@ -0,0 +1,39 @@
+package bqsql
+
+import (
+	"context"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"google.golang.org/api/option"
+)
+
+type BigQueryConfig struct {
+	ProjectID string
+	DatasetID string
+	Location  string
+	// CredentialsFile is the path of the service account key file,
+	// use the application default credentials if empty.
+	CredentialsFile string
+	// Endpoint overrides the BigQuery API endpoint, e.g. http://localhost:9050
+	// for a local BigQuery emulator. No authentication is used if it is set.
+	Endpoint string
+}
+
+// Open a client to BigQuery.
+func (config *BigQueryConfig) NewClient() (*bigquery.Client, error) {
+	opts := make([]option.ClientOption, 0, 2)
+	if config.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(config.Endpoint), option.WithoutAuthentication())
+	} else if config.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(config.CredentialsFile))
+	}
+	client, err := bigquery.NewClient(context.Background(), config.ProjectID, opts...)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to create BigQuery client")
+	}
+	client.Location = config.Location
+	log.Info("BigQuery client created")
+	return client, nil
+}
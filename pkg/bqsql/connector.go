@@ -0,0 +1,169 @@
+package bqsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// A Wrapper of BigQuery client.
+// It implements the coreinterfaces.Connector interface.
+type BigQueryConnector struct {
+	// client is the client of BigQuery.
+	client *bigquery.Client
+
+	datasetID string
+	// stageName is the name of the staging table which the increment files are loaded into before merging.
+	stageName string
+	// bucket is the GCS bucket of the storage.
+	bucket string
+
+	columns []cloudstorage.TableCol
+	// mergeKey is the last merge key used to merge the increment of the table.
+	mergeKey *tidbsql.MergeKey
+}
+
+func NewBigQueryConnector(client *bigquery.Client, datasetID, stageName string, storageURI *url.URL) (*BigQueryConnector, error) {
+	if storageURI.Scheme != "gcs" && storageURI.Scheme != "gs" {
+		return nil, errors.Errorf("BigQuery only supports loading data from GCS, got storage %s", storageURI.String())
+	}
+	if err := CreateDataset(client, datasetID); err != nil {
+		return nil, errors.Annotate(err, "Failed to create dataset")
+	}
+	if err := CreateApplyLogTable(client, datasetID); err != nil {
+		return nil, errors.Annotate(err, "Failed to create apply log table")
+	}
+
+	return &BigQueryConnector{
+		client:    client,
+		datasetID: datasetID,
+		stageName: stageName,
+		bucket:    storageURI.Host,
+		columns:   nil,
+	}, nil
+}
+
+func (bc *BigQueryConnector) InitSchema(_ string, columns []cloudstorage.TableCol) error {
+	if len(bc.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	bc.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (bc *BigQueryConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	if len(bc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	ddls, err := GenDDLViaColumnsDiff(bc.datasetID, bc.columns, tableDef)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(ddls) == 0 {
+		log.Info("No need to execute this DDL in BigQuery", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	// One DDL may be rewritten to multiple DDLs
+	for _, ddl := range ddls {
+		if err := runQuery(bc.client, ddl); err != nil {
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	// update columns
+	bc.columns = tableDef.Columns
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+	return nil
+}
+
+func (bc *BigQueryConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	createTableQuery, err := GenCreateSchema(bc.datasetID, sourceDatabase, sourceTable, sourceTiDBConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in BigQuery", redact.Query("query", createTableQuery))
+	if err = runQuery(bc.client, createTableQuery); err != nil {
+		return errors.Trace(err)
+	}
+
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+func (bc *BigQueryConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	if err := LoadSnapshotFromGCS(bc.client, bc.datasetID, targetTable, bc.bucket, filePrefix, onSnapshotLoadProgress); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
+	return nil
+}
+
+func (bc *BigQueryConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(bc.client, bc.datasetID, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (bc *BigQueryConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(bc.client, bc.datasetID, schema, table, commitTs))
+}
+
+func (bc *BigQueryConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	// load the file into the staging table, whose schema follows the current table definition
+	createStagingQuery, err := GenCreateStagingTable(bc.datasetID, bc.stageName, tableDef.Columns)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = runQuery(bc.client, createStagingQuery); err != nil {
+		return errors.Annotate(err, "Failed to create staging table")
+	}
+	fileURI := gcsURI(uri.Host, fmt.Sprintf("%s/%s", strings.TrimSuffix(uri.Path, "/"), file.Path))
+	if err = LoadIncrementToStagingTable(bc.client, bc.datasetID, bc.stageName, fileURI); err != nil {
+		return errors.Annotate(err, "Failed to load file into staging table")
+	}
+	log.Debug("load file into staging table", zap.String("file", fileURI))
+
+	// BigQuery has no unique constraint, so the increment is merged on the primary key
+	mergeKey, err := tidbsql.GetMergeKey(tableDef.Columns, nil)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to choose the merge key of table %s", tableDef.Table)
+	}
+	tidbsql.LogMergeKey(tableDef.Table, bc.mergeKey, mergeKey)
+	bc.mergeKey = &mergeKey
+
+	// merge staging table into table and record it in the apply log atomically
+	if err = MergeAndRecordApplyLog(bc.client, bc.datasetID, tableDef, mergeKey, bc.stageName, file); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully merge file", zap.String("file", file.Path))
+	return nil
+}
+
+func (bc *BigQueryConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
+	return NewBigQueryConnector(bc.client, bc.datasetID, stageName, storageURI)
+}
+
+func (bc *BigQueryConnector) Close() {
+	// drop staging table
+	if err := DropTable(bc.client, bc.datasetID, bc.stageName); err != nil {
+		log.Error("fail to drop staging table", zap.Error(err))
+	}
+	bc.client.Close()
+}
@@ -0,0 +1,125 @@
+package bqsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// tableName returns the quoted full qualified name of the table, e.g. "`dataset`.`table`"
+func tableName(datasetID, table string) string {
+	return fmt.Sprintf("`%s`.`%s`", datasetID, table)
+}
+
+// GetColumnModifyDDLs returns the DDLs to modify a column, BigQuery can only alter one column attribute in one statement.
+func GetColumnModifyDDLs(datasetID, table string, diff *tidbsql.ColumnDiff) ([]string, error) {
+	ddls := make([]string, 0, 2)
+	if diff.Before.Tp != diff.After.Tp || diff.Before.Precision != diff.After.Precision || diff.Before.Scale != diff.After.Scale {
+		beforeStr, err := GetBigQueryTypeString(*diff.Before)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		afterStr, err := GetBigQueryTypeString(*diff.After)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// e.g. INT -> BIGINT are both INT64 in BigQuery
+		beforeTp := strings.TrimPrefix(beforeStr, fmt.Sprintf("`%s` ", diff.Before.Name))
+		afterTp := strings.TrimPrefix(afterStr, fmt.Sprintf("`%s` ", diff.After.Name))
+		if beforeTp != afterTp {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN `%s` SET DATA TYPE %s;", tableName(datasetID, table), diff.After.Name, afterTp))
+		}
+	}
+	if diff.Before.Nullable != diff.After.Nullable {
+		if diff.After.Nullable == "true" {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN `%s` DROP NOT NULL;", tableName(datasetID, table), diff.After.Name))
+		} else {
+			log.Warn("BigQuery does not support set a column to NOT NULL", zap.String("column", diff.After.Name))
+		}
+	}
+	return ddls, nil
+}
+
+func GenDDLViaColumnsDiff(datasetID string, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+	if curTableDef.Type == timodel.ActionTruncateTable {
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s;", tableName(datasetID, curTableDef.Table))}, nil
+	}
+	if curTableDef.Type == timodel.ActionDropTable {
+		return []string{fmt.Sprintf("DROP TABLE %s;", tableName(datasetID, curTableDef.Table))}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateTable {
+		// The table is empty when created in TiDB, so the table left in BigQuery is replaced
+		createTable, err := GenCreateTable(datasetID, curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{createTable}, nil
+	}
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO `%s`;", tableName(datasetID, oldTable.Name), curTableDef.Table)}, nil
+	}
+	// The tables are created in the BigQuery dataset of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
+	}
+
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ddls := make([]string, 0, len(columnDiff))
+	for _, item := range columnDiff {
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			// BigQuery can not add a REQUIRED column to an existing table
+			colStr, err := GetBigQueryTypeString(*item.After)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", tableName(datasetID, curTableDef.Table), colStr))
+		case tidbsql.DROP_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP COLUMN `%s`;", tableName(datasetID, curTableDef.Table), item.Before.Name))
+		case tidbsql.MODIFY_COLUMN:
+			modifyDDLs, err := GetColumnModifyDDLs(datasetID, curTableDef.Table, &item)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, modifyDDLs...)
+		case tidbsql.RENAME_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN `%s` TO `%s`;", tableName(datasetID, curTableDef.Table), item.Before.Name, item.After.Name))
+		default:
+			// UNCHANGE
+		}
+	}
+
+	return ddls, nil
+}
+
+// GetBigQueryColumnString returns a string describing the column in BigQuery, e.g.
+// "`id` INT64 NOT NULL"
+// The default value is not kept, because all the values are replicated from TiDB and
+// MySQL default expressions (e.g. CURRENT_TIMESTAMP) are not always valid in BigQuery.
+// Refer to:
+// https://dev.mysql.com/doc/refman/8.0/en/data-types.html
+// https://cloud.google.com/bigquery/docs/reference/standard-sql/data-types
+func GetBigQueryColumnString(column cloudstorage.TableCol) (string, error) {
+	typeStr, err := GetBigQueryTypeString(column)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if column.Nullable == "false" {
+		typeStr += " NOT NULL"
+	}
+	return typeStr, nil
+}
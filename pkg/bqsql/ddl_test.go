@@ -0,0 +1,114 @@
+package bqsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/bqsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiff(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{
+			ID:        "1",
+			Name:      "id",
+			Tp:        "int",
+			Precision: "11",
+		},
+		{
+			ID:   "2",
+			Name: "name",
+			Tp:   "varchar",
+		},
+		{
+			ID:   "3",
+			Name: "age",
+			Tp:   "int",
+		},
+		{
+			ID:        "4",
+			Name:      "price",
+			Tp:        "decimal",
+			Precision: "10",
+			Scale:     "2",
+			Nullable:  "false",
+		},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{
+				ID:        "1",
+				Name:      "id",
+				Tp:        "bigint",
+				Precision: "20",
+			},
+			{
+				ID:   "2",
+				Name: "color",
+				Tp:   "varchar",
+			},
+			{
+				ID:        "4",
+				Name:      "price",
+				Tp:        "decimal",
+				Precision: "40",
+				Scale:     "2",
+				Nullable:  "true",
+			},
+			{
+				ID:        "6",
+				Name:      "gender",
+				Tp:        "varchar",
+				Precision: "10",
+				Nullable:  "false",
+			},
+		},
+	}
+
+	expectedDDLs := []string{
+		"ALTER TABLE `test_ds`.`test_table` RENAME COLUMN `name` TO `color`;",
+		"ALTER TABLE `test_ds`.`test_table` DROP COLUMN `age`;",
+		"ALTER TABLE `test_ds`.`test_table` ALTER COLUMN `price` SET DATA TYPE BIGNUMERIC(40, 2);",
+		"ALTER TABLE `test_ds`.`test_table` ALTER COLUMN `price` DROP NOT NULL;",
+		"ALTER TABLE `test_ds`.`test_table` ADD COLUMN `gender` STRING;",
+	}
+
+	ddl, err := bqsql.GenDDLViaColumnsDiff("test_ds", prevColumns, curTableDef)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expectedDDLs, ddl)
+}
+
+func TestGenDDLViaColumnsDiffSchema(t *testing.T) {
+	for _, tableDef := range []cloudstorage.TableDefinition{
+		{Schema: "test_schema", Type: timodel.ActionCreateSchema, Query: "CREATE DATABASE test_schema"},
+		{Schema: "test_schema", Type: timodel.ActionDropSchema, Query: "DROP DATABASE test_schema"},
+	} {
+		ddls, err := bqsql.GenDDLViaColumnsDiff("test_dataset", nil, tableDef)
+		require.NoError(t, err)
+		require.Empty(t, ddls)
+	}
+}
+
+func TestGenDDLViaColumnsDiffCreateTableWithoutPK(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE test_table (id INT NOT NULL UNIQUE, name VARCHAR(10))",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false"},
+			{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "10"},
+		},
+	}
+	_, err := bqsql.GenDDLViaColumnsDiff("test_dataset", nil, tableDef)
+	require.Error(t, err)
+
+	tableDef.Columns[0].IsPK = "true"
+	ddls, err := bqsql.GenDDLViaColumnsDiff("test_dataset", nil, tableDef)
+	require.NoError(t, err)
+	require.Len(t, ddls, 1)
+}
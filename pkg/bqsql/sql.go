@@ -0,0 +1,351 @@
+package bqsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+const (
+	// The metadata columns of the TiCDC CSV files in the staging table
+	flagColumnName     = "tidb2dw_flag"
+	tableColumnName    = "tidb2dw_table"
+	schemaColumnName   = "tidb2dw_schema"
+	commitTsColumnName = "tidb2dw_commit_ts"
+	rowNumberColumn    = "tidb2dw_row_number"
+)
+
+// runQuery runs the query (or script) and waits for it to finish.
+func runQuery(client *bigquery.Client, query string, params ...bigquery.QueryParameter) error {
+	ctx := context.Background()
+	q := client.Query(query)
+	q.Parameters = params
+	job, err := q.Run(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(status.Err())
+}
+
+func CreateDataset(client *bigquery.Client, datasetID string) error {
+	return runQuery(client, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS `%s`;", datasetID))
+}
+
+func DropTable(client *bigquery.Client, datasetID, table string) error {
+	return runQuery(client, fmt.Sprintf("DROP TABLE IF EXISTS %s;", tableName(datasetID, table)))
+}
+
+// gcsURI returns the gs:// uri of the object in the bucket.
+func gcsURI(bucket, path string) string {
+	return fmt.Sprintf("gs://%s/%s", bucket, strings.TrimPrefix(path, "/"))
+}
+
+func LoadSnapshotFromGCS(client *bigquery.Client, datasetID, targetTable, bucket, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	// The dumpling CSV files have no header, and the values are enclosed by '"', NULL is written as \N
+	gcsRef := bigquery.NewGCSReference(gcsURI(bucket, filePrefix) + "*")
+	gcsRef.SourceFormat = bigquery.CSV
+	gcsRef.FieldDelimiter = ","
+	gcsRef.Quote = `"`
+	gcsRef.AllowQuotedNewlines = true
+	gcsRef.NullMarker = `\N`
+
+	loader := client.Dataset(datasetID).Table(targetTable).LoaderFrom(gcsRef)
+	loader.WriteDisposition = bigquery.WriteAppend
+	loader.CreateDisposition = bigquery.CreateNever
+
+	ctx := context.Background()
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	loadFinished := make(chan struct{})
+
+	go func() {
+		// This is a goroutine to monitor the load job progress.
+		defer wg.Done()
+
+		if onSnapshotLoadProgress == nil {
+			return
+		}
+
+		checkInterval := 10 * time.Second
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-loadFinished:
+				return
+			case <-ticker.C:
+				status, err := job.Status(ctx)
+				if err != nil {
+					log.Warn("Failed to get progress", zap.Error(err))
+					continue
+				}
+				if status.Statistics == nil {
+					continue
+				}
+				if stats, ok := status.Statistics.Details.(*bigquery.LoadStatistics); ok {
+					onSnapshotLoadProgress(stats.OutputRows)
+				}
+			}
+		}
+	}()
+
+	status, err := job.Wait(ctx)
+	loadFinished <- struct{}{}
+
+	wg.Wait()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(status.Err())
+}
+
+func GenCreateSchema(datasetID, sourceDatabase, sourceTable string, sourceTiDBConn *sql.DB) (string, error) {
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	pkColumns, _, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	return GenCreateTable(datasetID, sourceTable, tableColumns, pkColumns)
+}
+
+// GenCreateTable returns the statement to create or replace the table with the columns and the primary key columns.
+func GenCreateTable(datasetID, table string, columns []cloudstorage.TableCol, pkColumns []string) (string, error) {
+	// BigQuery has no unique constraint, so the increment can only be merged on the primary key
+	if len(pkColumns) == 0 {
+		return "", errors.Errorf("table %s has no primary key, which is not supported by BigQuery", table)
+	}
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
+		row, err := GetBigQueryColumnString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+
+	sqlRows := make([]string, 0, len(columnRows)+1)
+	sqlRows = append(sqlRows, columnRows...)
+	quotedPKColumns := make([]string, 0, len(pkColumns))
+	for _, column := range pkColumns {
+		quotedPKColumns = append(quotedPKColumns, fmt.Sprintf("`%s`", column))
+	}
+	// BigQuery does not enforce the primary key, it is only a hint for the query optimizer
+	sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s) NOT ENFORCED", strings.Join(quotedPKColumns, ", ")))
+	// Add idents
+	for i := 0; i < len(sqlRows); i++ {
+		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
+	}
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s (`, tableName(datasetID, table)))
+	sql = append(sql, strings.Join(sqlRows, ",\n"))
+	sql = append(sql, ")")
+
+	return strings.Join(sql, "\n"), nil
+}
+
+// GenCreateStagingTable generates the DDL of the staging table, which has the same layout as the TiCDC CSV files:
+// the metadata columns followed by the columns of the table. All the columns are nullable.
+func GenCreateStagingTable(datasetID, stagingTable string, columns []cloudstorage.TableCol) (string, error) {
+	sqlRows := make([]string, 0, len(columns)+4)
+	sqlRows = append(sqlRows,
+		fmt.Sprintf("`%s` STRING", flagColumnName),
+		fmt.Sprintf("`%s` STRING", tableColumnName),
+		fmt.Sprintf("`%s` STRING", schemaColumnName),
+		fmt.Sprintf("`%s` INT64", commitTsColumnName))
+	for _, column := range columns {
+		row, err := GetBigQueryTypeString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		sqlRows = append(sqlRows, row)
+	}
+	for i := 0; i < len(sqlRows); i++ {
+		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
+	}
+	return fmt.Sprintf("CREATE OR REPLACE TABLE %s (\n%s\n)", tableName(datasetID, stagingTable), strings.Join(sqlRows, ",\n")), nil
+}
+
+// LoadIncrementToStagingTable replaces the content of the staging table with the TiCDC CSV file.
+func LoadIncrementToStagingTable(client *bigquery.Client, datasetID, stagingTable, fileURI string) error {
+	// The fields of the TiCDC CSV files are quoted by double quotes, NULL is written as \N
+	gcsRef := bigquery.NewGCSReference(fileURI)
+	gcsRef.SourceFormat = bigquery.CSV
+	gcsRef.FieldDelimiter = ","
+	gcsRef.Quote = `"`
+	gcsRef.AllowQuotedNewlines = true
+	gcsRef.NullMarker = `\N`
+
+	loader := client.Dataset(datasetID).Table(stagingTable).LoaderFrom(gcsRef)
+	loader.WriteDisposition = bigquery.WriteTruncate
+	loader.CreateDisposition = bigquery.CreateNever
+
+	ctx := context.Background()
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(status.Err())
+}
+
+// GenMergeInto returns the MERGE statement which merges the latest change of each key in the staging table into the table.
+func GenMergeInto(datasetID string, tableDef cloudstorage.TableDefinition, mergeKey tidbsql.MergeKey, stagingTable string) string {
+	keyColumns := make([]string, 0, len(mergeKey.Columns))
+	onStat := make([]string, 0, len(mergeKey.Columns))
+	for _, name := range mergeKey.Columns {
+		keyColumns = append(keyColumns, fmt.Sprintf("`%s`", name))
+		onStat = append(onStat, fmt.Sprintf("T.`%s` = S.`%s`", name, name))
+	}
+
+	updateStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		updateStat = append(updateStat, fmt.Sprintf("`%s` = S.`%s`", col.Name, col.Name))
+	}
+
+	insertStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		insertStat = append(insertStat, fmt.Sprintf("`%s`", col.Name))
+	}
+
+	valuesStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		valuesStat = append(valuesStat, fmt.Sprintf("S.`%s`", col.Name))
+	}
+
+	// TODO: Remove row_number() after cdc support merge dml
+	mergeQuery := fmt.Sprintf(
+		`MERGE %s AS T USING
+		(
+			SELECT * EXCEPT (%s)
+			FROM (
+				SELECT *, row_number() over (partition by %s order by %s desc) AS %s
+				FROM %s
+			)
+			WHERE %s = 1
+		) AS S
+		ON
+		(
+			%s
+		)
+		WHEN MATCHED AND S.%s != 'D' THEN UPDATE SET %s
+		WHEN MATCHED AND S.%s = 'D' THEN DELETE
+		WHEN NOT MATCHED AND S.%s != 'D' THEN INSERT (%s) VALUES (%s);`,
+		tableName(datasetID, tableDef.Table),
+		rowNumberColumn,
+		strings.Join(keyColumns, ", "),
+		commitTsColumnName,
+		rowNumberColumn,
+		tableName(datasetID, stagingTable),
+		rowNumberColumn,
+		strings.Join(onStat, " AND "),
+		flagColumnName,
+		strings.Join(updateStat, ", "),
+		flagColumnName,
+		flagColumnName,
+		strings.Join(insertStat, ", "),
+		strings.Join(valuesStat, ", "))
+
+	return mergeQuery
+}
+
+// ApplyLogTableName is the table which records the increment files loaded into BigQuery.
+const ApplyLogTableName = "tidb2dw_apply_log"
+
+func CreateApplyLogTable(client *bigquery.Client, datasetID string) error {
+	return runQuery(client, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    schema_name STRING NOT NULL,
+    table_name STRING NOT NULL,
+    file_path STRING NOT NULL,
+    checksum STRING NOT NULL,
+    min_commit_ts INT64,
+    max_commit_ts INT64,
+    applied_at TIMESTAMP
+);`, tableName(datasetID, ApplyLogTableName)))
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(client *bigquery.Client, datasetID, tableSchema, table string, file coreinterfaces.IncrementFile) (bool, error) {
+	q := client.Query(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE schema_name = @schema_name AND table_name = @table_name AND file_path = @file_path AND checksum = @checksum",
+		tableName(datasetID, ApplyLogTableName)))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "schema_name", Value: tableSchema},
+		{Name: "table_name", Value: table},
+		{Name: "file_path", Value: file.Path},
+		{Name: "checksum", Value: file.Checksum},
+	}
+	it, err := q.Read(context.Background())
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	var row []bigquery.Value
+	if err = it.Next(&row); err != nil {
+		return false, errors.Trace(err)
+	}
+	count, ok := row[0].(int64)
+	if !ok {
+		return false, errors.Errorf("unexpected count value %v", row[0])
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+func TrimApplyLog(client *bigquery.Client, datasetID, tableSchema, table string, commitTs uint64) error {
+	return runQuery(client, fmt.Sprintf("DELETE FROM %s WHERE schema_name = @schema_name AND table_name = @table_name AND max_commit_ts < @commit_ts",
+		tableName(datasetID, ApplyLogTableName)),
+		bigquery.QueryParameter{Name: "schema_name", Value: tableSchema},
+		bigquery.QueryParameter{Name: "table_name", Value: table},
+		bigquery.QueryParameter{Name: "commit_ts", Value: int64(commitTs)})
+}
+
+// MergeAndRecordApplyLog merges the staging table into the target table and records the increment file
+// in the apply log in one multi-statement transaction.
+func MergeAndRecordApplyLog(client *bigquery.Client, datasetID string, tableDef cloudstorage.TableDefinition, mergeKey tidbsql.MergeKey, stagingTable string, file coreinterfaces.IncrementFile) error {
+	script := fmt.Sprintf(`BEGIN TRANSACTION;
+%s
+INSERT INTO %s (schema_name, table_name, file_path, checksum, min_commit_ts, max_commit_ts, applied_at)
+VALUES (@schema_name, @table_name, @file_path, @checksum, @min_commit_ts, @max_commit_ts, CURRENT_TIMESTAMP());
+COMMIT TRANSACTION;`,
+		GenMergeInto(datasetID, tableDef, mergeKey, stagingTable),
+		tableName(datasetID, ApplyLogTableName))
+	return runQuery(client, script,
+		bigquery.QueryParameter{Name: "schema_name", Value: tableDef.Schema},
+		bigquery.QueryParameter{Name: "table_name", Value: tableDef.Table},
+		bigquery.QueryParameter{Name: "file_path", Value: file.Path},
+		bigquery.QueryParameter{Name: "checksum", Value: file.Checksum},
+		bigquery.QueryParameter{Name: "min_commit_ts", Value: int64(file.MinCommitTs)},
+		bigquery.QueryParameter{Name: "max_commit_ts", Value: int64(file.MaxCommitTs)})
+}
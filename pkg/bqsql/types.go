@@ -0,0 +1,75 @@
+package bqsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// TiDB2BigQueryTypeMap is a map from TiDB type to BigQuery type.
+var TiDB2BigQueryTypeMap map[string]string = map[string]string{
+	"text":       "STRING",
+	"tinytext":   "STRING",
+	"mediumtext": "STRING",
+	"longtext":   "STRING",
+	"blob":       "STRING",
+	"tinyblob":   "STRING",
+	"mediumblob": "STRING",
+	"longblob":   "STRING",
+	"varchar":    "STRING",
+	"char":       "STRING",
+	"binary":     "BYTES",
+	"varbinary":  "BYTES",
+	"int":        "INT64",
+	"mediumint":  "INT64",
+	"tinyint":    "INT64",
+	"smallint":   "INT64",
+	"bigint":     "INT64",
+	"float":      "FLOAT64",
+	"double":     "FLOAT64",
+	"decimal":    "NUMERIC",
+	"numeric":    "NUMERIC",
+	"bool":       "BOOL",
+	"boolean":    "BOOL",
+	"date":       "DATE",
+	"datetime":   "DATETIME",
+	"timestamp":  "TIMESTAMP",
+	"time":       "TIME",
+}
+
+// getDecimalTypeString returns NUMERIC(P, S) if the precision and scale fit in it, otherwise BIGNUMERIC.
+// Refer to: https://cloud.google.com/bigquery/docs/reference/standard-sql/data-types#parameterized_decimal_type
+func getDecimalTypeString(precision, scale string) string {
+	p, err1 := strconv.Atoi(precision)
+	s, err2 := strconv.Atoi(scale)
+	if err1 != nil || err2 != nil {
+		return "BIGNUMERIC"
+	}
+	if s <= 9 && p-s <= 29 {
+		return fmt.Sprintf("NUMERIC(%d, %d)", p, s)
+	}
+	if s <= 38 && p-s <= 38 {
+		return fmt.Sprintf("BIGNUMERIC(%d, %d)", p, s)
+	}
+	return "BIGNUMERIC"
+}
+
+// GetBigQueryTypeString returns a string describing the column type in BigQuery, e.g. "`id` INT64"
+func GetBigQueryTypeString(column cloudstorage.TableCol) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	switch tp {
+	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob", "varchar", "char", "binary", "varbinary":
+		return fmt.Sprintf("`%s` %s", column.Name, TiDB2BigQueryTypeMap[tp]), nil
+	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
+		return fmt.Sprintf("`%s` %s", column.Name, TiDB2BigQueryTypeMap[tp]), nil
+	case "decimal", "numeric":
+		return fmt.Sprintf("`%s` %s", column.Name, getDecimalTypeString(column.Precision, column.Scale)), nil
+	case "datetime", "timestamp", "time":
+		return fmt.Sprintf("`%s` %s", column.Name, TiDB2BigQueryTypeMap[tp]), nil
+	default:
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
@@ -0,0 +1,36 @@
+package clickhousesql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+)
+
+type ClickHouseConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Pass     string
+	Database string
+}
+
+// Open a connection to ClickHouse.
+func (config *ClickHouseConfig) OpenDB() (*sql.DB, error) {
+	db := clickhouse.OpenDB(&clickhouse.Options{
+		Addr: []string{fmt.Sprintf("%s:%d", config.Host, config.Port)},
+		Auth: clickhouse.Auth{
+			Database: config.Database,
+			Username: config.User,
+			Password: config.Pass,
+		},
+	})
+	// make sure the connection is available
+	if err := db.Ping(); err != nil {
+		return nil, errors.Annotate(err, "Failed to ping ClickHouse")
+	}
+	log.Info("ClickHouse connection established")
+	return db, nil
+}
@@ -0,0 +1,162 @@
+package clickhousesql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// A Wrapper of ClickHouse connection.
+// It implements the coreinterfaces.Connector interface.
+type ClickHouseConnector struct {
+	// db is the connection to ClickHouse.
+	db *sql.DB
+
+	// storageURI is the location of the files to load.
+	storageURI *url.URL
+	// credentials is used by the s3 table function to read the files.
+	credentials *credentials.Value
+
+	columns []cloudstorage.TableCol
+}
+
+func NewClickHouseConnector(db *sql.DB, storageURI *url.URL, credentials *credentials.Value) (*ClickHouseConnector, error) {
+	if _, err := genObjectURL(storageURI, ""); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := CreateApplyLogTable(db); err != nil {
+		return nil, errors.Annotate(err, "Failed to create apply log table")
+	}
+
+	return &ClickHouseConnector{
+		db:          db,
+		storageURI:  storageURI,
+		credentials: credentials,
+		columns:     nil,
+	}, nil
+}
+
+func (cc *ClickHouseConnector) InitSchema(_ string, columns []cloudstorage.TableCol) error {
+	if len(cc.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	cc.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (cc *ClickHouseConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	if len(cc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	ddls, err := GenDDLViaColumnsDiff(cc.columns, tableDef)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(ddls) == 0 {
+		log.Info("No need to execute this DDL in ClickHouse", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	// One DDL may be rewritten to multiple DDLs
+	for _, ddl := range ddls {
+		_, err := cc.db.Exec(ddl)
+		if err != nil {
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	// update columns
+	cc.columns = tableDef.Columns
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+	return nil
+}
+
+func (cc *ClickHouseConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	createTableQuery, columns, err := GenCreateSchema(sourceDatabase, sourceTable, sourceTiDBConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in ClickHouse", redact.Query("query", createTableQuery))
+	_, err = cc.db.Exec(createTableQuery)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The columns are needed to describe the structure of the CSV files when loading snapshot
+	cc.columns = columns
+
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+func (cc *ClickHouseConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	if len(cc.columns) == 0 {
+		return errors.New("Columns not initialized, table schema should be copied before loading snapshot")
+	}
+	objectURL, err := genObjectURL(cc.storageURI, filePrefix+"*")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	loadedRows, err := LoadSnapshotFromStorage(cc.db, targetTable, cc.columns, objectURL, cc.credentials)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if onSnapshotLoadProgress != nil {
+		onSnapshotLoadProgress(loadedRows)
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
+	return nil
+}
+
+func (cc *ClickHouseConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(cc.db, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (cc *ClickHouseConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(cc.db, schema, table, commitTs))
+}
+
+func (cc *ClickHouseConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	objectURL, err := genObjectURL(uri, fmt.Sprintf("%s/%s", strings.TrimSuffix(uri.Path, "/"), file.Path))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	appendQuery, err := GenAppendIncrement(tableDef, objectURL, cc.credentials)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = cc.db.Exec(appendQuery); err != nil {
+		return errors.Trace(err)
+	}
+	log.Debug("append file into table", zap.String("file", objectURL))
+	if err = InsertApplyLog(cc.db, tableDef.Schema, tableDef.Table, file); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully append file", zap.String("file", file.Path))
+	return nil
+}
+
+func (cc *ClickHouseConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
+	// ClickHouse reads the files from storage directly, no stage is needed
+	return NewClickHouseConnector(cc.db, storageURI, credentials)
+}
+
+func (cc *ClickHouseConnector) Close() {
+	cc.db.Close()
+}
@@ -0,0 +1,114 @@
+package clickhousesql
+
+import (
+	"fmt"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
+	if diff.Before.Default != diff.After.Default {
+		log.Warn("Default value is not kept in ClickHouse, skip update column default value", zap.String("column", diff.After.Name), zap.Any("before", diff.Before.Default), zap.Any("after", diff.After.Default))
+	}
+	beforeTp, err := GetClickHouseNullableTypeString(*diff.Before)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	afterTp, err := GetClickHouseNullableTypeString(*diff.After)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	// The nullability is a part of the type in ClickHouse
+	if beforeTp == afterTp {
+		return "", nil
+	}
+	return fmt.Sprintf("MODIFY COLUMN `%s` %s", diff.After.Name, afterTp), nil
+}
+
+func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+	if curTableDef.Type == timodel.ActionTruncateTable {
+		return []string{fmt.Sprintf("TRUNCATE TABLE `%s`", curTableDef.Table)}, nil
+	}
+	if curTableDef.Type == timodel.ActionDropTable {
+		return []string{fmt.Sprintf("DROP TABLE `%s`", curTableDef.Table)}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateTable {
+		// The table is empty when created in TiDB, so the table left in ClickHouse is replaced
+		createTable, err := GenCreateTable(curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{createTable}, nil
+	}
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("RENAME TABLE `%s` TO `%s`", oldTable.Name, curTableDef.Table)}, nil
+	}
+	// The tables are created in the ClickHouse database of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
+	}
+
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The sorting key of ReplacingMergeTree can not be changed, the rows are deduplicated by the primary key of the created table
+	if tidbsql.IsPKChanged(columnDiff) {
+		return nil, errors.Errorf("the primary key of table %s is changed, which is not supported by ClickHouse", curTableDef.Table)
+	}
+	ddls := make([]string, 0, len(columnDiff))
+	for _, item := range columnDiff {
+		ddl := ""
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			tp, err := GetClickHouseNullableTypeString(*item.After)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddl = fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", curTableDef.Table, item.After.Name, tp)
+		case tidbsql.DROP_COLUMN:
+			ddl = fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`", curTableDef.Table, item.Before.Name)
+		case tidbsql.MODIFY_COLUMN:
+			modifyStr, err := GetColumnModifyString(&item)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if modifyStr != "" {
+				ddl = fmt.Sprintf("ALTER TABLE `%s` %s", curTableDef.Table, modifyStr)
+			}
+		case tidbsql.RENAME_COLUMN:
+			ddl = fmt.Sprintf("ALTER TABLE `%s` RENAME COLUMN `%s` TO `%s`", curTableDef.Table, item.Before.Name, item.After.Name)
+		default:
+			// UNCHANGE
+		}
+		if ddl != "" {
+			ddls = append(ddls, ddl)
+		}
+	}
+
+	return ddls, nil
+}
+
+// GetClickHouseColumnString returns a string describing the column in ClickHouse, e.g.
+// "`id` Int32"
+// The default value is not kept, because all the values are replicated from TiDB.
+// Refer to:
+// https://dev.mysql.com/doc/refman/8.0/en/data-types.html
+// https://clickhouse.com/docs/en/sql-reference/data-types
+func GetClickHouseColumnString(column cloudstorage.TableCol) (string, error) {
+	tp, err := GetClickHouseNullableTypeString(column)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return fmt.Sprintf("`%s` %s", column.Name, tp), nil
+}
@@ -0,0 +1,45 @@
+package clickhousesql
+
+import (
+	"testing"
+
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiffPKChanged(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "code", Tp: "INT", Nullable: "false"},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionDropPrimaryKey,
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false"},
+			{ID: "2", Name: "code", Tp: "INT", Nullable: "false"},
+		},
+	}
+	_, err := GenDDLViaColumnsDiff(prevColumns, curTableDef)
+	require.ErrorContains(t, err, "the primary key of table test_table is changed")
+
+	curTableDef.Type = timodel.ActionAddColumn
+	curTableDef.Columns = append([]cloudstorage.TableCol{}, prevColumns...)
+	curTableDef.Columns = append(curTableDef.Columns, cloudstorage.TableCol{ID: "3", Name: "name", Tp: "VARCHAR", Precision: "10"})
+	ddls, err := GenDDLViaColumnsDiff(prevColumns, curTableDef)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `test_table` ADD COLUMN `name` Nullable(String)"}, ddls)
+}
+
+func TestGenDDLViaColumnsDiffSchema(t *testing.T) {
+	for _, tableDef := range []cloudstorage.TableDefinition{
+		{Schema: "test_schema", Type: timodel.ActionCreateSchema, Query: "CREATE DATABASE test_schema"},
+		{Schema: "test_schema", Type: timodel.ActionDropSchema, Query: "DROP DATABASE test_schema"},
+	} {
+		ddls, err := GenDDLViaColumnsDiff(nil, tableDef)
+		require.NoError(t, err)
+		require.Empty(t, ddls)
+	}
+}
@@ -0,0 +1,9 @@
+package clickhousesql
+
+import "strings"
+
+// EscapeString escapes the string to be used in a single-quoted string literal.
+// See https://clickhouse.com/docs/en/sql-reference/syntax#string
+func EscapeString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
@@ -0,0 +1,243 @@
+package clickhousesql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+const (
+	// VersionColumnName is the version column of ReplacingMergeTree, which is the commit ts of the row.
+	// The rows loaded from snapshot have version 0.
+	VersionColumnName = "_tidb2dw_version"
+	// IsDeletedColumnName is 1 if the row is deleted in TiDB.
+	IsDeletedColumnName = "_tidb2dw_is_deleted"
+
+	// The metadata columns of the TiCDC CSV files
+	flagColumnName     = "tidb2dw_flag"
+	tableColumnName    = "tidb2dw_table"
+	schemaColumnName   = "tidb2dw_schema"
+	commitTsColumnName = "tidb2dw_commit_ts"
+)
+
+// genObjectURL returns the http url of the object in the storage, which can be read by the s3 table function.
+func genObjectURL(storageURI *url.URL, p string) (string, error) {
+	p = strings.TrimPrefix(p, "/")
+	switch storageURI.Scheme {
+	case "s3":
+		s3Options := storageutil.GetS3Options(storageURI)
+		if s3Options.Endpoint != "" {
+			endpointURL, err := url.Parse(s3Options.EndpointURL())
+			if err != nil {
+				return "", errors.Annotate(err, "Failed to parse storage endpoint")
+			}
+			if s3Options.ForcePathStyle {
+				return fmt.Sprintf("%s://%s/%s/%s", endpointURL.Scheme, endpointURL.Host, storageURI.Host, p), nil
+			}
+			return fmt.Sprintf("%s://%s.%s/%s", endpointURL.Scheme, storageURI.Host, endpointURL.Host, p), nil
+		}
+		if s3Options.Region != "" {
+			return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", storageURI.Host, s3Options.Region, p), nil
+		}
+		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", storageURI.Host, p), nil
+	case "gcs", "gs":
+		// GCS is accessed through the S3 compatible XML API with HMAC keys
+		return fmt.Sprintf("https://storage.googleapis.com/%s/%s", storageURI.Host, p), nil
+	default:
+		return "", errors.Errorf("ClickHouse does not support loading data from storage %s", storageURI.String())
+	}
+}
+
+// genTableFunction returns the s3 table function to read the CSV files, e.g.
+// s3('https://bucket.s3.amazonaws.com/path/*.csv', 'key', 'secret', 'CSV', '`id` Int32')
+func genTableFunction(objectURL string, cred *credentials.Value, structure string) string {
+	args := []string{fmt.Sprintf("'%s'", EscapeString(objectURL))}
+	if cred != nil && cred.AccessKeyID != "" {
+		args = append(args, fmt.Sprintf("'%s'", EscapeString(cred.AccessKeyID)), fmt.Sprintf("'%s'", EscapeString(cred.SecretAccessKey)))
+		if cred.SessionToken != "" {
+			args = append(args, fmt.Sprintf("'%s'", EscapeString(cred.SessionToken)))
+		}
+	}
+	args = append(args, "'CSV'", fmt.Sprintf("'%s'", EscapeString(structure)))
+	return fmt.Sprintf("s3(%s)", strings.Join(args, ", "))
+}
+
+func LoadSnapshotFromStorage(db *sql.DB, targetTable string, columns []cloudstorage.TableCol, objectURL string, cred *credentials.Value) (int64, error) {
+	structure := make([]string, 0, len(columns))
+	selectStat := make([]string, 0, len(columns)+2)
+	insertStat := make([]string, 0, len(columns)+2)
+	for _, col := range columns {
+		colStr, err := GetClickHouseColumnString(col)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		structure = append(structure, colStr)
+		selectStat = append(selectStat, fmt.Sprintf("`%s`", col.Name))
+		insertStat = append(insertStat, fmt.Sprintf("`%s`", col.Name))
+	}
+	selectStat = append(selectStat, "0", "0")
+	insertStat = append(insertStat, fmt.Sprintf("`%s`", VersionColumnName), fmt.Sprintf("`%s`", IsDeletedColumnName))
+
+	sql := fmt.Sprintf("INSERT INTO `%s` (%s)\nSELECT %s\nFROM %s",
+		targetTable,
+		strings.Join(insertStat, ", "),
+		strings.Join(selectStat, ", "),
+		genTableFunction(objectURL, cred, strings.Join(structure, ", ")))
+	res, err := db.Exec(sql)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	loadedRows, err := res.RowsAffected()
+	if err != nil {
+		// the number of loaded rows is only used to report progress
+		return 0, nil
+	}
+	return loadedRows, nil
+}
+
+// GenAppendIncrement generates the query to append the rows in the TiCDC CSV file into the table.
+// The deleted rows are appended with is_deleted = 1, and ReplacingMergeTree keeps the row with the max version.
+func GenAppendIncrement(tableDef cloudstorage.TableDefinition, objectURL string, cred *credentials.Value) (string, error) {
+	structure := []string{
+		fmt.Sprintf("`%s` String", flagColumnName),
+		fmt.Sprintf("`%s` String", tableColumnName),
+		fmt.Sprintf("`%s` String", schemaColumnName),
+		fmt.Sprintf("`%s` UInt64", commitTsColumnName),
+	}
+	selectStat := make([]string, 0, len(tableDef.Columns)+2)
+	insertStat := make([]string, 0, len(tableDef.Columns)+2)
+	for _, col := range tableDef.Columns {
+		colStr, err := GetClickHouseColumnString(col)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		structure = append(structure, colStr)
+		switch strings.ToLower(col.Tp) {
+		case "binary", "varbinary":
+			// TiCDC encodes the binary values in base64
+			selectStat = append(selectStat, fmt.Sprintf("base64Decode(`%s`)", col.Name))
+		default:
+			selectStat = append(selectStat, fmt.Sprintf("`%s`", col.Name))
+		}
+		insertStat = append(insertStat, fmt.Sprintf("`%s`", col.Name))
+	}
+	selectStat = append(selectStat, fmt.Sprintf("`%s`", commitTsColumnName), fmt.Sprintf("if(`%s` = 'D', 1, 0)", flagColumnName))
+	insertStat = append(insertStat, fmt.Sprintf("`%s`", VersionColumnName), fmt.Sprintf("`%s`", IsDeletedColumnName))
+
+	return fmt.Sprintf("INSERT INTO `%s` (%s)\nSELECT %s\nFROM %s",
+		tableDef.Table,
+		strings.Join(insertStat, ", "),
+		strings.Join(selectStat, ", "),
+		genTableFunction(objectURL, cred, strings.Join(structure, ", "))), nil
+}
+
+func GenCreateSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) (string, []cloudstorage.TableCol, error) {
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	pkColumns, _, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	sql, err := GenCreateTable(sourceTable, tableColumns, pkColumns)
+	if err != nil {
+		return "", nil, errors.Annotatef(err, "Failed to create table %s.%s", sourceDatabase, sourceTable)
+	}
+	return sql, tableColumns, nil
+}
+
+// GenCreateTable returns the statement to create or replace the table with the columns, sorted by the primary key columns.
+func GenCreateTable(table string, columns []cloudstorage.TableCol, pkColumns []string) (string, error) {
+	if len(pkColumns) == 0 {
+		// ReplacingMergeTree deduplicates rows by the sorting key
+		return "", errors.Errorf("table %s has no primary key, which is not supported by ClickHouse", table)
+	}
+	columnRows := make([]string, 0, len(columns)+2)
+	for _, column := range columns {
+		row, err := GetClickHouseColumnString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+	columnRows = append(columnRows, fmt.Sprintf("`%s` UInt64", VersionColumnName), fmt.Sprintf("`%s` UInt8", IsDeletedColumnName))
+	quotedPKColumns := make([]string, 0, len(pkColumns))
+	for _, column := range pkColumns {
+		quotedPKColumns = append(quotedPKColumns, fmt.Sprintf("`%s`", column))
+	}
+
+	// Add idents
+	for i := 0; i < len(columnRows); i++ {
+		columnRows[i] = fmt.Sprintf("    %s", columnRows[i])
+	}
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf("CREATE OR REPLACE TABLE `%s` (", table))
+	sql = append(sql, strings.Join(columnRows, ",\n"))
+	sql = append(sql, ")")
+	sql = append(sql, fmt.Sprintf("ENGINE = ReplacingMergeTree(`%s`, `%s`)", VersionColumnName, IsDeletedColumnName))
+	sql = append(sql, fmt.Sprintf("ORDER BY (%s)", strings.Join(quotedPKColumns, ", ")))
+
+	return strings.Join(sql, "\n"), nil
+}
+
+// ApplyLogTableName is the table which records the increment files loaded into ClickHouse.
+const ApplyLogTableName = "tidb2dw_apply_log"
+
+func CreateApplyLogTable(db *sql.DB) error {
+	sql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    schema_name String,
+    table_name String,
+    file_path String,
+    checksum String,
+    min_commit_ts UInt64,
+    max_commit_ts UInt64,
+    applied_at DateTime DEFAULT now()
+)
+ENGINE = MergeTree
+ORDER BY (schema_name, table_name, file_path)`, ApplyLogTableName)
+	_, err := db.Exec(sql)
+	return err
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(db *sql.DB, schemaName, tableName string, file coreinterfaces.IncrementFile) (bool, error) {
+	var count uint64
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE schema_name = ? AND table_name = ? AND file_path = ? AND checksum = ?`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum).Scan(&count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+// The rows are deleted by a mutation, which is executed asynchronously.
+func TrimApplyLog(db *sql.DB, schemaName, tableName string, commitTs uint64) error {
+	_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s DELETE WHERE schema_name = ? AND table_name = ? AND max_commit_ts < ?`, ApplyLogTableName),
+		schemaName, tableName, commitTs)
+	return errors.Trace(err)
+}
+
+// InsertApplyLog records the increment file in the apply log.
+// ClickHouse does not support transactions, so it is executed after the rows are appended.
+// If tidb2dw crashes in between, the same rows will be appended again with the same primary key and version.
+// They are collapsed by ReplacingMergeTree when the parts are merged, and by FINAL in the queries before that.
+func InsertApplyLog(db *sql.DB, schemaName, tableName string, file coreinterfaces.IncrementFile) error {
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO %s (schema_name, table_name, file_path, checksum, min_commit_ts, max_commit_ts) VALUES (?, ?, ?, ?, ?, ?)`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum, file.MinCommitTs, file.MaxCommitTs)
+	return err
+}
@@ -0,0 +1,55 @@
+package clickhousesql
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenAppendIncrement(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "INT", IsPK: "true", Nullable: "false"},
+			{Name: "data", Tp: "VARBINARY", Precision: "10"},
+			{Name: "created_at", Tp: "DATETIME", Precision: "3"},
+		},
+	}
+	cred := &credentials.Value{AccessKeyID: "key", SecretAccessKey: "secret"}
+	query, err := GenAppendIncrement(tableDef, "https://bucket.s3.amazonaws.com/increment/CDC000001.csv", cred)
+	require.NoError(t, err)
+	require.Equal(t, "INSERT INTO `test_table` (`id`, `data`, `created_at`, `_tidb2dw_version`, `_tidb2dw_is_deleted`)\n"+
+		"SELECT `id`, base64Decode(`data`), `created_at`, `tidb2dw_commit_ts`, if(`tidb2dw_flag` = 'D', 1, 0)\n"+
+		"FROM s3('https://bucket.s3.amazonaws.com/increment/CDC000001.csv', 'key', 'secret', 'CSV', "+
+		"'`tidb2dw_flag` String, `tidb2dw_table` String, `tidb2dw_schema` String, `tidb2dw_commit_ts` UInt64, "+
+		"`id` Int32, `data` Nullable(String), `created_at` Nullable(DateTime64(3))')", query)
+}
+
+func TestGenObjectURL(t *testing.T) {
+	cases := []struct {
+		uri      string
+		expected string
+	}{
+		{"s3://bucket/prefix", "https://bucket.s3.amazonaws.com/prefix/a.csv"},
+		{"s3://bucket/prefix?region=us-west-2", "https://bucket.s3.us-west-2.amazonaws.com/prefix/a.csv"},
+		{"s3://bucket/prefix?endpoint=http://127.0.0.1:9000&force-path-style=true", "http://127.0.0.1:9000/bucket/prefix/a.csv"},
+		{"s3://bucket/prefix?endpoint=minio.example.com", "https://bucket.minio.example.com/prefix/a.csv"},
+		{"gcs://bucket/prefix", "https://storage.googleapis.com/bucket/prefix/a.csv"},
+	}
+	for _, c := range cases {
+		uri, err := url.Parse(c.uri)
+		require.NoError(t, err)
+		objectURL, err := genObjectURL(uri, uri.Path+"/a.csv")
+		require.NoError(t, err)
+		require.Equal(t, c.expected, objectURL)
+	}
+
+	uri, err := url.Parse("file:///tmp/tidb2dw")
+	require.NoError(t, err)
+	_, err = genObjectURL(uri, "a.csv")
+	require.Error(t, err)
+}
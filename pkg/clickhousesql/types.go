@@ -0,0 +1,77 @@
+package clickhousesql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// TiDB2ClickHouseTypeMap is a map from TiDB type to ClickHouse type.
+var TiDB2ClickHouseTypeMap map[string]string = map[string]string{
+	"text":       "String",
+	"tinytext":   "String",
+	"mediumtext": "String",
+	"longtext":   "String",
+	"blob":       "String",
+	"tinyblob":   "String",
+	"mediumblob": "String",
+	"longblob":   "String",
+	"varchar":    "String",
+	"char":       "String",
+	"binary":     "String",
+	"varbinary":  "String",
+	"int":        "Int32",
+	"mediumint":  "Int32",
+	"tinyint":    "Int8",
+	"smallint":   "Int16",
+	"bigint":     "Int64",
+	"float":      "Float32",
+	"double":     "Float64",
+	"decimal":    "Decimal",
+	"numeric":    "Decimal",
+	"bool":       "Bool",
+	"boolean":    "Bool",
+	"date":       "Date32",
+	"datetime":   "DateTime64",
+	"timestamp":  "DateTime64",
+	"time":       "String",
+}
+
+// getFsp returns the fractional seconds precision of the datetime column, which is 0 if not specified.
+func getFsp(column cloudstorage.TableCol) string {
+	if column.Precision == "" {
+		return "0"
+	}
+	return column.Precision
+}
+
+// GetClickHouseTypeString returns the type of the column in ClickHouse without nullable, e.g. "Decimal(10, 2)"
+func GetClickHouseTypeString(column cloudstorage.TableCol) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	switch tp {
+	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob", "varchar", "char", "binary", "varbinary":
+		return TiDB2ClickHouseTypeMap[tp], nil
+	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date", "time":
+		return TiDB2ClickHouseTypeMap[tp], nil
+	case "decimal", "numeric":
+		return fmt.Sprintf("Decimal(%s, %s)", column.Precision, column.Scale), nil
+	case "datetime", "timestamp":
+		return fmt.Sprintf("DateTime64(%s)", getFsp(column)), nil
+	default:
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
+
+// GetClickHouseNullableTypeString returns the type of the column in ClickHouse, e.g. "Nullable(String)"
+func GetClickHouseNullableTypeString(column cloudstorage.TableCol) (string, error) {
+	tp, err := GetClickHouseTypeString(column)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if column.Nullable == "false" {
+		return tp, nil
+	}
+	return fmt.Sprintf("Nullable(%s)", tp), nil
+}
@@ -14,18 +14,36 @@ import (
 /// All Data Warehouse related operations should be done through this.
 
 type Connector interface {
-	// InitSchema initializes the schema of the table
-	InitSchema(columns []cloudstorage.TableCol) error
+	// InitSchema initializes the schema of the table with its columns
+	InitSchema(table string, columns []cloudstorage.TableCol) error
 	// CopyTableSchema copies the table schema from the source database to the Data Warehouse
 	CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error
 	// LoadSnapshot loads the snapshot into the Data Warehouse
 	LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error
 	// ExecDDL executes the DDL statements in Data Warehouse
 	ExecDDL(tableDef cloudstorage.TableDefinition) error
-	// LoadIncrement loads the increment data into the Data Warehouse
-	LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error
+	// IsIncrementLoaded returns whether the increment file has been recorded in the apply log of the Data Warehouse
+	IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file IncrementFile) (bool, error)
+	// LoadIncrement loads the increment data into the Data Warehouse,
+	// and records the file in the apply log in the same transaction
+	LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file IncrementFile) error
+	// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs
+	TrimApplyLog(schema, table string, commitTs uint64) error
 	// Clone return a new Connector wihch reuses the same connection to the Data Warehouse
 	Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (Connector, error)
 	// Close closes the connection to the Data Warehouse
 	Close()
 }
+
+// IncrementFile is an increment data file produced by TiCDC.
+// It is recorded in the apply log of the Data Warehouse once loaded,
+// so that replaying the same file is idempotent.
+type IncrementFile struct {
+	// Path is the path of the file relative to the sink uri
+	Path string
+	// Checksum is the hex encoded sha256 of the file content
+	Checksum string
+	// MinCommitTs and MaxCommitTs are the commit ts range of the rows in the file
+	MinCommitTs uint64
+	MaxCommitTs uint64
+}
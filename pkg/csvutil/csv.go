@@ -0,0 +1,97 @@
+package csvutil
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// nullString is how NULL is written in the CSV files of both dumpling and TiCDC.
+const nullString = `\N`
+
+// ParseRecord parses one line of the CSV files written by dumpling and TiCDC.
+// Both of them escape the special characters (e.g. '\n', '\\' and the delimiter) by backslash,
+// so one record is always one line. A nil field means NULL.
+func ParseRecord(line string) ([]*string, error) {
+	fields := make([]*string, 0)
+	i := 0
+	for {
+		var sb strings.Builder
+		start := i
+		quoted := i < len(line) && line[i] == '"'
+		if quoted {
+			i++
+		}
+		closed := false
+		for i < len(line) {
+			c := line[i]
+			if c == '\\' && i+1 < len(line) {
+				switch line[i+1] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 'r':
+					sb.WriteByte('\r')
+				case '0':
+					sb.WriteByte(0)
+				default:
+					sb.WriteByte(line[i+1])
+				}
+				i += 2
+				continue
+			}
+			if quoted && c == '"' {
+				closed = true
+				i++
+				break
+			}
+			if !quoted && c == ',' {
+				break
+			}
+			sb.WriteByte(c)
+			i++
+		}
+		if quoted && !closed {
+			return nil, errors.Errorf("unterminated quoted field in line: %s", line)
+		}
+		if !quoted && line[start:i] == nullString {
+			fields = append(fields, nil)
+		} else {
+			value := sb.String()
+			fields = append(fields, &value)
+		}
+		if i >= len(line) {
+			break
+		}
+		if line[i] != ',' {
+			return nil, errors.Errorf("unexpected character %q after quoted field in line: %s", line[i], line)
+		}
+		i++
+	}
+	return fields, nil
+}
+
+// ReadRecords reads the CSV records one by one and calls fn for each of them.
+func ReadRecords(reader io.Reader, fn func(fields []*string) error) error {
+	br := bufio.NewReader(reader)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return errors.Trace(err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			fields, parseErr := ParseRecord(line)
+			if parseErr != nil {
+				return errors.Trace(parseErr)
+			}
+			if fnErr := fn(fields); fnErr != nil {
+				return errors.Trace(fnErr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package csvutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestParseRecord(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []*string
+	}{
+		// dumpling: quoted strings, unquoted NULL
+		{`1,"a\"b","line1\nline2",\N`, []*string{strPtr("1"), strPtr(`a"b`), strPtr("line1\nline2"), nil}},
+		// dumpling: quoted "\N" is a string instead of NULL
+		{`"\\N",""`, []*string{strPtr(`\N`), strPtr("")}},
+		// TiCDC: no quote, delimiter and backslash are escaped
+		{`I,t,db,443459412185677825,a\,b,c\\d,\N`, []*string{strPtr("I"), strPtr("t"), strPtr("db"), strPtr("443459412185677825"), strPtr("a,b"), strPtr(`c\d`), nil}},
+		{`D,t,db,1,,`, []*string{strPtr("D"), strPtr("t"), strPtr("db"), strPtr("1"), strPtr(""), strPtr("")}},
+	}
+	for _, c := range cases {
+		fields, err := ParseRecord(c.line)
+		require.NoError(t, err)
+		require.Equal(t, c.expected, fields, c.line)
+	}
+
+	_, err := ParseRecord(`1,"abc`)
+	require.Error(t, err)
+}
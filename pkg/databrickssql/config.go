@@ -0,0 +1,39 @@
+package databrickssql
+
+import (
+	"database/sql"
+
+	dbsql "github.com/databricks/databricks-sql-go"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+)
+
+type DatabricksConfig struct {
+	Host     string
+	Port     int
+	HTTPPath string
+	Token    string
+	Catalog  string
+	Schema   string
+}
+
+// Open a connection to Databricks SQL warehouse.
+func (config *DatabricksConfig) OpenDB() (*sql.DB, error) {
+	connector, err := dbsql.NewConnector(
+		dbsql.WithServerHostname(config.Host),
+		dbsql.WithPort(config.Port),
+		dbsql.WithHTTPPath(config.HTTPPath),
+		dbsql.WithAccessToken(config.Token),
+		dbsql.WithInitialNamespace(config.Catalog, config.Schema),
+	)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to create Databricks connector")
+	}
+	db := sql.OpenDB(connector)
+	// make sure the connection is available
+	if err = db.Ping(); err != nil {
+		return nil, errors.Annotate(err, "Failed to ping Databricks")
+	}
+	log.Info("Databricks connection established")
+	return db, nil
+}
@@ -0,0 +1,199 @@
+package databrickssql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// A Wrapper of Databricks connection.
+// It implements the coreinterfaces.Connector interface.
+type DatabricksConnector struct {
+	// db is the connection to Databricks SQL warehouse.
+	db *sql.DB
+
+	// stageName is the name of the staging table which the increment files are loaded into before merging.
+	stageName string
+	// storageURI is the location of the files to load.
+	storageURI *url.URL
+	// credentials is the temporary credential used by COPY INTO to access the storage,
+	// the storage should be accessible through an external location if it is empty.
+	credentials *credentials.Value
+
+	columns []cloudstorage.TableCol
+	// mergeKey is the last merge key used to merge the increment of the table.
+	mergeKey *tidbsql.MergeKey
+}
+
+func NewDatabricksConnector(db *sql.DB, stageName string, storageURI *url.URL, credentials *credentials.Value) (*DatabricksConnector, error) {
+	switch storageURI.Scheme {
+	case "s3":
+		if endpoint := storageutil.GetS3Options(storageURI).Endpoint; endpoint != "" {
+			return nil, errors.Errorf("Databricks does not support loading data from S3 compatible storage %s", endpoint)
+		}
+	case "gcs", "gs":
+	default:
+		// the files are read by the SQL warehouse with COPY INTO
+		return nil, errors.Errorf("Databricks only supports loading data from S3 or GCS, got storage %s", storageURI.String())
+	}
+	if err := CreateApplyLogTable(db); err != nil {
+		return nil, errors.Annotate(err, "Failed to create apply log table")
+	}
+
+	return &DatabricksConnector{
+		db:          db,
+		stageName:   stageName,
+		storageURI:  storageURI,
+		credentials: credentials,
+		columns:     nil,
+	}, nil
+}
+
+// storageLocation returns the location of the path in the storage, e.g. s3://bucket/path
+func (dc *DatabricksConnector) storageLocation(p string) string {
+	scheme := dc.storageURI.Scheme
+	if scheme == "gcs" {
+		scheme = "gs"
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, dc.storageURI.Host, strings.TrimPrefix(p, "/"))
+}
+
+func (dc *DatabricksConnector) InitSchema(_ string, columns []cloudstorage.TableCol) error {
+	if len(dc.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	dc.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (dc *DatabricksConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	if len(dc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	ddls, err := GenDDLViaColumnsDiff(dc.columns, tableDef)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(ddls) == 0 {
+		log.Info("No need to execute this DDL in Databricks", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	// One DDL may be rewritten to multiple DDLs
+	for _, ddl := range ddls {
+		_, err := dc.db.Exec(ddl)
+		if err != nil {
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	// update columns
+	dc.columns = tableDef.Columns
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+	return nil
+}
+
+func (dc *DatabricksConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	createTableQuery, columns, err := GenCreateSchema(sourceDatabase, sourceTable, sourceTiDBConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in Databricks", redact.Query("query", createTableQuery))
+	_, err = dc.db.Exec(createTableQuery)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The columns are needed to convert the CSV fields when loading snapshot
+	dc.columns = columns
+
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+func (dc *DatabricksConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	if len(dc.columns) == 0 {
+		return errors.New("Columns not initialized, table schema should be copied before loading snapshot")
+	}
+	loadedRows, err := LoadSnapshotFromStorage(dc.db, targetTable, dc.columns, dc.storageLocation(""), filePrefix, dc.credentials)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if onSnapshotLoadProgress != nil {
+		onSnapshotLoadProgress(loadedRows)
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
+	return nil
+}
+
+func (dc *DatabricksConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(dc.db, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (dc *DatabricksConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(dc.db, schema, table, commitTs))
+}
+
+func (dc *DatabricksConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	// load the file into the staging table, whose schema follows the current table definition
+	createStagingQuery, err := GenCreateStagingTable(dc.stageName, tableDef.Columns)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = dc.db.Exec(createStagingQuery); err != nil {
+		return errors.Annotate(err, "Failed to create staging table")
+	}
+	location := dc.storageLocation(path.Join(uri.Path, path.Dir(file.Path)))
+	if err = LoadIncrementToStagingTable(dc.db, dc.stageName, tableDef.Columns, location, path.Base(file.Path), dc.credentials); err != nil {
+		return errors.Annotate(err, "Failed to load file into staging table")
+	}
+	log.Debug("load file into staging table", zap.String("location", location), zap.String("file", file.Path))
+
+	// the constraints of Databricks have no unique key, so the increment is merged on the primary key
+	mergeKey, err := tidbsql.GetMergeKey(tableDef.Columns, nil)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to choose the merge key of table %s", tableDef.Table)
+	}
+	tidbsql.LogMergeKey(tableDef.Table, dc.mergeKey, mergeKey)
+	dc.mergeKey = &mergeKey
+	mergeQuery := GenMergeInto(tableDef, mergeKey, dc.stageName)
+	if _, err = dc.db.Exec(mergeQuery); err != nil {
+		return errors.Trace(err)
+	}
+	log.Debug("merge staging table into table", redact.Query("query", mergeQuery))
+	if err = InsertApplyLog(dc.db, tableDef.Schema, tableDef.Table, file); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully merge file", zap.String("file", file.Path))
+	return nil
+}
+
+func (dc *DatabricksConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
+	return NewDatabricksConnector(dc.db, stageName, storageURI, credentials)
+}
+
+func (dc *DatabricksConnector) Close() {
+	// drop staging table
+	if err := DropTable(dc.db, dc.stageName); err != nil {
+		log.Error("fail to drop staging table", zap.Error(err))
+	}
+	dc.db.Close()
+}
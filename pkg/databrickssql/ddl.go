@@ -0,0 +1,122 @@
+package databrickssql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// GetColumnModifyDDLs returns the DDLs to modify a column, Databricks can only alter one column attribute in one statement.
+func GetColumnModifyDDLs(table string, diff *tidbsql.ColumnDiff) ([]string, error) {
+	ddls := make([]string, 0, 2)
+	if diff.Before.Tp != diff.After.Tp || diff.Before.Precision != diff.After.Precision || diff.Before.Scale != diff.After.Scale {
+		beforeTp, err := GetDatabricksTypeString(*diff.Before)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		afterTp, err := GetDatabricksTypeString(*diff.After)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// e.g. VARCHAR(10) -> VARCHAR(20) are both STRING in Databricks
+		if beforeTp != afterTp {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE `%s` ALTER COLUMN `%s` TYPE %s;", table, diff.After.Name, afterTp))
+		}
+	}
+	if diff.Before.Default != diff.After.Default {
+		log.Warn("Default value is not kept in Databricks, skip update column default value", zap.String("column", diff.After.Name), zap.Any("before", diff.Before.Default), zap.Any("after", diff.After.Default))
+	}
+	if diff.Before.Nullable != diff.After.Nullable {
+		if diff.After.Nullable == "true" {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE `%s` ALTER COLUMN `%s` DROP NOT NULL;", table, diff.After.Name))
+		} else {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE `%s` ALTER COLUMN `%s` SET NOT NULL;", table, diff.After.Name))
+		}
+	}
+	return ddls, nil
+}
+
+func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+	if curTableDef.Type == timodel.ActionTruncateTable {
+		return []string{fmt.Sprintf("TRUNCATE TABLE `%s`;", curTableDef.Table)}, nil
+	}
+	if curTableDef.Type == timodel.ActionDropTable {
+		return []string{fmt.Sprintf("DROP TABLE `%s`;", curTableDef.Table)}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateTable {
+		// The table is empty when created in TiDB, so the table left in Databricks is replaced
+		createTable, err := GenCreateTable(curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{createTable}, nil
+	}
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE `%s` RENAME TO `%s`;", oldTable.Name, curTableDef.Table)}, nil
+	}
+	// The tables are created in the Databricks schema of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
+	}
+
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ddls := make([]string, 0, len(columnDiff))
+	for _, item := range columnDiff {
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			// Delta Lake can not add a NOT NULL column to an existing table
+			tp, err := GetDatabricksTypeString(*item.After)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s;", curTableDef.Table, item.After.Name, tp))
+		case tidbsql.DROP_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN `%s`;", curTableDef.Table, item.Before.Name))
+		case tidbsql.MODIFY_COLUMN:
+			modifyDDLs, err := GetColumnModifyDDLs(curTableDef.Table, &item)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, modifyDDLs...)
+		case tidbsql.RENAME_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE `%s` RENAME COLUMN `%s` TO `%s`;", curTableDef.Table, item.Before.Name, item.After.Name))
+		default:
+			// UNCHANGE
+		}
+	}
+
+	return ddls, nil
+}
+
+// GetDatabricksColumnString returns a string describing the column in Databricks, e.g.
+// "`id` INT NOT NULL"
+// The default value is not kept, because column defaults require an extra Delta table feature.
+// Refer to:
+// https://dev.mysql.com/doc/refman/8.0/en/data-types.html
+// https://docs.databricks.com/en/sql/language-manual/sql-ref-datatypes.html
+func GetDatabricksColumnString(column cloudstorage.TableCol) (string, error) {
+	var sb strings.Builder
+	tp, err := GetDatabricksTypeString(column)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sb.WriteString(fmt.Sprintf("`%s` %s", column.Name, tp))
+	if column.Nullable == "false" {
+		sb.WriteString(" NOT NULL")
+	}
+	return sb.String(), nil
+}
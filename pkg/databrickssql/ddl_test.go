@@ -0,0 +1,98 @@
+package databrickssql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiff(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{
+			ID:        "1",
+			Name:      "id",
+			Tp:        "int",
+			Precision: "11",
+		},
+		{
+			ID:        "2",
+			Name:      "name",
+			Tp:        "varchar",
+			Precision: "10",
+		},
+		{
+			ID:   "3",
+			Name: "age",
+			Tp:   "int",
+		},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{
+				ID:        "5",
+				Name:      "id",
+				Tp:        "bigint",
+				Precision: "20",
+			},
+			{
+				ID:        "2",
+				Name:      "name",
+				Tp:        "varchar",
+				Precision: "20",
+				Nullable:  "false",
+			},
+			{
+				ID:   "6",
+				Name: "birth",
+				Tp:   "datetime",
+			},
+		},
+	}
+
+	expectedDDLs := []string{
+		"ALTER TABLE `test_table` ALTER COLUMN `id` TYPE BIGINT;",
+		"ALTER TABLE `test_table` ALTER COLUMN `name` SET NOT NULL;",
+		"ALTER TABLE `test_table` DROP COLUMN `age`;",
+		"ALTER TABLE `test_table` ADD COLUMN `birth` TIMESTAMP_NTZ;",
+	}
+
+	ddl, err := databrickssql.GenDDLViaColumnsDiff(prevColumns, curTableDef)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expectedDDLs, ddl)
+}
+
+func TestGenDDLViaColumnsDiffSchema(t *testing.T) {
+	for _, tableDef := range []cloudstorage.TableDefinition{
+		{Schema: "test_schema", Type: timodel.ActionCreateSchema, Query: "CREATE DATABASE test_schema"},
+		{Schema: "test_schema", Type: timodel.ActionDropSchema, Query: "DROP DATABASE test_schema"},
+	} {
+		ddls, err := databrickssql.GenDDLViaColumnsDiff(nil, tableDef)
+		require.NoError(t, err)
+		require.Empty(t, ddls)
+	}
+}
+
+func TestGenDDLViaColumnsDiffCreateTableWithoutPK(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE test_table (id INT NOT NULL UNIQUE, name VARCHAR(10))",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false"},
+			{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "10"},
+		},
+	}
+	_, err := databrickssql.GenDDLViaColumnsDiff(nil, tableDef)
+	require.Error(t, err)
+
+	tableDef.Columns[0].IsPK = "true"
+	ddls, err := databrickssql.GenDDLViaColumnsDiff(nil, tableDef)
+	require.NoError(t, err)
+	require.Len(t, ddls, 1)
+}
@@ -0,0 +1,9 @@
+package databrickssql
+
+import "strings"
+
+// EscapeString escapes the string to be used in a single-quoted string literal.
+// See https://docs.databricks.com/en/sql/language-manual/data-types/string-type.html
+func EscapeString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
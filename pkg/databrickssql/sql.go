@@ -0,0 +1,310 @@
+package databrickssql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"gitlab.com/tymonx/go-formatter/formatter"
+)
+
+const (
+	// The metadata columns of the TiCDC CSV files in the staging table,
+	// which are the same as the ones used by snowsql.GenMergeInto ($1 and $4).
+	flagColumnName     = "tidb2dw_flag"
+	tableColumnName    = "tidb2dw_table"
+	schemaColumnName   = "tidb2dw_schema"
+	commitTsColumnName = "tidb2dw_commit_ts"
+
+	// Column mapping is required to rename or drop columns of a Delta table.
+	deltaTableProperties = `TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5')`
+)
+
+// genSourceClause returns the source clause of COPY INTO, with the temporary credential if provided.
+func genSourceClause(location string, cred *credentials.Value) string {
+	if cred == nil || cred.AccessKeyID == "" {
+		return fmt.Sprintf("'%s'", EscapeString(location))
+	}
+	return fmt.Sprintf("'%s' WITH (CREDENTIAL (AWS_ACCESS_KEY = '%s', AWS_SECRET_KEY = '%s', AWS_SESSION_TOKEN = '%s'))",
+		EscapeString(location), EscapeString(cred.AccessKeyID), EscapeString(cred.SecretAccessKey), EscapeString(cred.SessionToken))
+}
+
+// genSelectColumns returns the expressions to convert the CSV fields `_c<offset>`, `_c<offset+1>`, ... to the columns.
+// TiCDC encodes the binary values in base64, while dumpling writes them as is.
+func genSelectColumns(columns []cloudstorage.TableCol, offset int, base64Binary bool) ([]string, error) {
+	selectStat := make([]string, 0, len(columns))
+	for i, col := range columns {
+		tp, err := GetDatabricksTypeString(col)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if tp == "BINARY" && base64Binary {
+			selectStat = append(selectStat, fmt.Sprintf("unbase64(_c%d) AS `%s`", i+offset, col.Name))
+		} else {
+			selectStat = append(selectStat, fmt.Sprintf("CAST(_c%d AS %s) AS `%s`", i+offset, tp, col.Name))
+		}
+	}
+	return selectStat, nil
+}
+
+func LoadSnapshotFromStorage(db *sql.DB, targetTable string, columns []cloudstorage.TableCol, storageURL, filePrefix string, cred *credentials.Value) (int64, error) {
+	selectStat, err := genSelectColumns(columns, 0, false)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	sql, err := formatter.Format(`
+COPY INTO `+"`{targetTable}`"+`
+FROM (
+    SELECT {columns}
+    FROM {source}
+)
+FILEFORMAT = CSV
+PATTERN = '{pattern}'
+FORMAT_OPTIONS ('header' = 'false', 'nullValue' = '\\N', 'quote' = '"', 'escape' = '\\', 'multiLine' = 'true')
+COPY_OPTIONS ('force' = 'true');
+`, formatter.Named{
+		"targetTable": targetTable,
+		"columns":     strings.Join(selectStat, ", "),
+		"source":      genSourceClause(storageURL, cred),
+		"pattern":     EscapeString(filePrefix) + "*",
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	res, err := db.Exec(sql)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	loadedRows, err := res.RowsAffected()
+	if err != nil {
+		// the number of loaded rows is only used to report progress
+		return 0, nil
+	}
+	return loadedRows, nil
+}
+
+func GenCreateSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) (string, []cloudstorage.TableCol, error) {
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	pkColumns, _, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	sql, err := GenCreateTable(sourceTable, tableColumns, pkColumns)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	return sql, tableColumns, nil
+}
+
+// GenCreateTable returns the statement to create or replace the Delta table with the columns and the primary key columns.
+func GenCreateTable(table string, columns []cloudstorage.TableCol, pkColumns []string) (string, error) {
+	// The constraints of Databricks have no unique key, so the increment can only be merged on the primary key
+	if len(pkColumns) == 0 {
+		return "", errors.Errorf("table %s has no primary key, which is not supported by Databricks", table)
+	}
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
+		row, err := GetDatabricksColumnString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+
+	sqlRows := make([]string, 0, len(columnRows)+1)
+	sqlRows = append(sqlRows, columnRows...)
+	quotedPKColumns := make([]string, 0, len(pkColumns))
+	for _, column := range pkColumns {
+		quotedPKColumns = append(quotedPKColumns, fmt.Sprintf("`%s`", column))
+	}
+	// The primary key is informational only in Databricks
+	sqlRows = append(sqlRows, fmt.Sprintf("CONSTRAINT `pk_%s` PRIMARY KEY (%s)", table, strings.Join(quotedPKColumns, ", ")))
+	// Add idents
+	for i := 0; i < len(sqlRows); i++ {
+		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
+	}
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf("CREATE OR REPLACE TABLE `%s` (", table))
+	sql = append(sql, strings.Join(sqlRows, ",\n"))
+	sql = append(sql, ") USING DELTA")
+	sql = append(sql, deltaTableProperties)
+
+	return strings.Join(sql, "\n"), nil
+}
+
+// GenCreateStagingTable generates the DDL of the staging table, which has the same layout as the TiCDC CSV files:
+// the metadata columns followed by the columns of the table. All the columns are nullable.
+func GenCreateStagingTable(stagingTable string, columns []cloudstorage.TableCol) (string, error) {
+	sqlRows := make([]string, 0, len(columns)+4)
+	sqlRows = append(sqlRows,
+		fmt.Sprintf("`%s` STRING", flagColumnName),
+		fmt.Sprintf("`%s` STRING", tableColumnName),
+		fmt.Sprintf("`%s` STRING", schemaColumnName),
+		fmt.Sprintf("`%s` BIGINT", commitTsColumnName))
+	for _, column := range columns {
+		tp, err := GetDatabricksTypeString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		sqlRows = append(sqlRows, fmt.Sprintf("`%s` %s", column.Name, tp))
+	}
+	for i := 0; i < len(sqlRows); i++ {
+		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
+	}
+	return fmt.Sprintf("CREATE OR REPLACE TABLE `%s` (\n%s\n) USING DELTA\n%s", stagingTable, strings.Join(sqlRows, ",\n"), deltaTableProperties), nil
+}
+
+// LoadIncrementToStagingTable loads the TiCDC CSV file `fileName` in the location into the staging table.
+func LoadIncrementToStagingTable(db *sql.DB, stagingTable string, columns []cloudstorage.TableCol, location, fileName string, cred *credentials.Value) error {
+	selectStat := []string{
+		fmt.Sprintf("_c0 AS `%s`", flagColumnName),
+		fmt.Sprintf("_c1 AS `%s`", tableColumnName),
+		fmt.Sprintf("_c2 AS `%s`", schemaColumnName),
+		fmt.Sprintf("CAST(_c3 AS BIGINT) AS `%s`", commitTsColumnName),
+	}
+	columnStat, err := genSelectColumns(columns, 4, true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	selectStat = append(selectStat, columnStat...)
+	// The fields of the TiCDC CSV files are quoted by double quotes, which are escaped by doubling them
+	sql, err := formatter.Format(`
+COPY INTO `+"`{stagingTable}`"+`
+FROM (
+    SELECT {columns}
+    FROM {source}
+)
+FILEFORMAT = CSV
+FILES = ('{file}')
+FORMAT_OPTIONS ('header' = 'false', 'nullValue' = '\\N', 'quote' = '"', 'escape' = '"', 'multiLine' = 'true')
+COPY_OPTIONS ('force' = 'true');
+`, formatter.Named{
+		"stagingTable": stagingTable,
+		"columns":      strings.Join(selectStat, ", "),
+		"source":       genSourceClause(location, cred),
+		"file":         EscapeString(fileName),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = db.Exec(sql)
+	return errors.Trace(err)
+}
+
+// GenMergeInto returns the MERGE statement which merges the latest change of each key in the staging table into the table.
+// Every row of the staging table is matched with the table on the key, so merging the same file again is idempotent:
+// a replayed 'I' row updates the row merged before instead of being appended.
+func GenMergeInto(tableDef cloudstorage.TableDefinition, mergeKey tidbsql.MergeKey, stagingTable string) string {
+	keyColumns := make([]string, 0, len(mergeKey.Columns))
+	onStat := make([]string, 0, len(mergeKey.Columns))
+	for _, name := range mergeKey.Columns {
+		keyColumns = append(keyColumns, fmt.Sprintf("`%s`", name))
+		onStat = append(onStat, fmt.Sprintf("T.`%s` = S.`%s`", name, name))
+	}
+
+	updateStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		updateStat = append(updateStat, fmt.Sprintf("`%s` = S.`%s`", col.Name, col.Name))
+	}
+
+	insertStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		insertStat = append(insertStat, fmt.Sprintf("`%s`", col.Name))
+	}
+
+	valuesStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		valuesStat = append(valuesStat, fmt.Sprintf("S.`%s`", col.Name))
+	}
+
+	// TODO: Remove QUALIFY row_number() after cdc support merge dml
+	mergeQuery := fmt.Sprintf(
+		"MERGE INTO `%s` AS T USING\n"+
+			"(\n"+
+			"	SELECT * FROM `%s`\n"+
+			"	QUALIFY row_number() over (partition by %s order by `%s` desc) = 1\n"+
+			") AS S\n"+
+			"ON\n"+
+			"(\n"+
+			"	%s\n"+
+			")\n"+
+			"WHEN MATCHED AND S.`%s` != 'D' THEN UPDATE SET %s\n"+
+			"WHEN MATCHED AND S.`%s` = 'D' THEN DELETE\n"+
+			"WHEN NOT MATCHED AND S.`%s` != 'D' THEN INSERT (%s) VALUES (%s);",
+		tableDef.Table,
+		stagingTable,
+		strings.Join(keyColumns, ", "),
+		commitTsColumnName,
+		strings.Join(onStat, " AND "),
+		flagColumnName,
+		strings.Join(updateStat, ", "),
+		flagColumnName,
+		flagColumnName,
+		strings.Join(insertStat, ", "),
+		strings.Join(valuesStat, ", "))
+
+	return mergeQuery
+}
+
+func DropTable(db *sql.DB, table string) error {
+	_, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS `%s`;", table))
+	return err
+}
+
+// ApplyLogTableName is the table which records the increment files loaded into Databricks.
+const ApplyLogTableName = "tidb2dw_apply_log"
+
+func CreateApplyLogTable(db *sql.DB) error {
+	sql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    schema_name STRING NOT NULL,
+    table_name STRING NOT NULL,
+    file_path STRING NOT NULL,
+    checksum STRING NOT NULL,
+    min_commit_ts DECIMAL(20, 0),
+    max_commit_ts DECIMAL(20, 0),
+    applied_at TIMESTAMP
+) USING DELTA;`, ApplyLogTableName)
+	_, err := db.Exec(sql)
+	return err
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(db *sql.DB, schemaName, tableName string, file coreinterfaces.IncrementFile) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE schema_name = ? AND table_name = ? AND file_path = ? AND checksum = ?`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum).Scan(&count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+func TrimApplyLog(db *sql.DB, schemaName, tableName string, commitTs uint64) error {
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE schema_name = ? AND table_name = ? AND max_commit_ts < CAST(? AS DECIMAL(20, 0))`, ApplyLogTableName),
+		schemaName, tableName, fmt.Sprint(commitTs))
+	return errors.Trace(err)
+}
+
+// InsertApplyLog records the increment file in the apply log.
+// Databricks does not support multi-statement transactions, so it is executed after the MERGE.
+// If tidb2dw crashes in between, the same file will be merged again, which is idempotent since
+// it is the last merged file of the table and the MERGE matches every row on the key, see GenMergeInto.
+func InsertApplyLog(db *sql.DB, schemaName, tableName string, file coreinterfaces.IncrementFile) error {
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO %s (schema_name, table_name, file_path, checksum, min_commit_ts, max_commit_ts, applied_at) VALUES (?, ?, ?, ?, CAST(? AS DECIMAL(20, 0)), CAST(? AS DECIMAL(20, 0)), current_timestamp())`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum, fmt.Sprint(file.MinCommitTs), fmt.Sprint(file.MaxCommitTs))
+	return err
+}
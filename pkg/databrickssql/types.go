@@ -0,0 +1,62 @@
+package databrickssql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// TiDB2DatabricksTypeMap is a map from TiDB type to Databricks type.
+var TiDB2DatabricksTypeMap map[string]string = map[string]string{
+	"text":       "STRING",
+	"tinytext":   "STRING",
+	"mediumtext": "STRING",
+	"longtext":   "STRING",
+	"blob":       "STRING",
+	"tinyblob":   "STRING",
+	"mediumblob": "STRING",
+	"longblob":   "STRING",
+	"varchar":    "STRING",
+	"char":       "STRING",
+	"binary":     "BINARY",
+	"varbinary":  "BINARY",
+	"int":        "INT",
+	"mediumint":  "INT",
+	"tinyint":    "TINYINT",
+	"smallint":   "SMALLINT",
+	"bigint":     "BIGINT",
+	"float":      "FLOAT",
+	"double":     "DOUBLE",
+	"decimal":    "DECIMAL",
+	"numeric":    "DECIMAL",
+	"bool":       "BOOLEAN",
+	"boolean":    "BOOLEAN",
+	"date":       "DATE",
+	"datetime":   "TIMESTAMP_NTZ",
+	"timestamp":  "TIMESTAMP",
+	"time":       "STRING",
+}
+
+// GetDatabricksTypeString returns the type of the column in Databricks, e.g. "DECIMAL(10, 2)"
+func GetDatabricksTypeString(column cloudstorage.TableCol) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	switch tp {
+	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob", "varchar", "char", "binary", "varbinary":
+		return TiDB2DatabricksTypeMap[tp], nil
+	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
+		return TiDB2DatabricksTypeMap[tp], nil
+	case "decimal", "numeric":
+		// Databricks supports at most 38 digits, store the larger decimal as string to avoid losing precision
+		if precision, err := strconv.Atoi(column.Precision); err == nil && precision > 38 {
+			return "STRING", nil
+		}
+		return fmt.Sprintf("DECIMAL(%s, %s)", column.Precision, column.Scale), nil
+	case "datetime", "timestamp", "time":
+		return TiDB2DatabricksTypeMap[tp], nil
+	default:
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
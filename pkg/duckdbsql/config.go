@@ -0,0 +1,32 @@
+package duckdbsql
+
+import (
+	"database/sql"
+
+	_ "github.com/marcboeker/go-duckdb"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+type DuckDBConfig struct {
+	// Path is the path of the DuckDB database file
+	Path   string
+	Schema string
+	// ParquetDir is the directory to export the tables as Parquet files, empty means no export
+	ParquetDir string
+}
+
+// Open a connection to the DuckDB database file.
+func (config *DuckDBConfig) OpenDB() (*sql.DB, error) {
+	db, err := sql.Open("duckdb", config.Path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open DuckDB database")
+	}
+	// make sure the database is available
+	if err = db.Ping(); err != nil {
+		return nil, errors.Annotate(err, "Failed to ping DuckDB")
+	}
+	log.Info("DuckDB database opened", zap.String("path", config.Path))
+	return db, nil
+}
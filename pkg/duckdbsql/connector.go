@@ -0,0 +1,262 @@
+package duckdbsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	putil "github.com/pingcap/tiflow/pkg/util"
+	"go.uber.org/zap"
+)
+
+// A Wrapper of DuckDB connection.
+// It implements the coreinterfaces.Connector interface.
+type DuckDBConnector struct {
+	// db is the connection to the DuckDB database file.
+	// A database file can only be opened once, so db is shared by all the connectors and closed by the caller.
+	db         *sql.DB
+	schemaName string
+	// stageName is the name of the temporary staging table of the increment files.
+	stageName string
+	// storageURI is the location of the files to load, usually on the local file system, e.g. file:///tmp/tidb2dw
+	storageURI *url.URL
+	// parquetDir is the directory to export the tables as Parquet files, empty means no export
+	parquetDir string
+
+	columns []cloudstorage.TableCol
+}
+
+func NewDuckDBConnector(db *sql.DB, schemaName, stageName string, storageURI *url.URL, parquetDir string) (*DuckDBConnector, error) {
+	if err := CreateSchema(db, schemaName); err != nil {
+		return nil, errors.Annotate(err, "Failed to create schema")
+	}
+	if err := CreateApplyLogTable(db, schemaName); err != nil {
+		return nil, errors.Annotate(err, "Failed to create apply log table")
+	}
+
+	return &DuckDBConnector{
+		db:         db,
+		schemaName: schemaName,
+		stageName:  stageName,
+		storageURI: storageURI,
+		parquetDir: parquetDir,
+		columns:    nil,
+	}, nil
+}
+
+// openStorage opens the external storage of the given uri.
+func openStorage(ctx context.Context, uri *url.URL) (storage.ExternalStorage, error) {
+	extStorage, err := putil.GetExternalStorageFromURI(ctx, uri.String())
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open storage")
+	}
+	return extStorage, nil
+}
+
+// exportParquet refreshes the Parquet file of the table if the export is enabled.
+func (dc *DuckDBConnector) exportParquet(table string) error {
+	if dc.parquetDir == "" {
+		return nil
+	}
+	if err := ExportParquet(dc.db, dc.schemaName, table, dc.parquetDir); err != nil {
+		return errors.Annotatef(err, "Failed to export table %s as Parquet", table)
+	}
+	return nil
+}
+
+func (dc *DuckDBConnector) InitSchema(_ string, columns []cloudstorage.TableCol) error {
+	if len(dc.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	dc.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (dc *DuckDBConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	if len(dc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	ddls, err := GenDDLViaColumnsDiff(dc.schemaName, dc.columns, tableDef)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(ddls) == 0 {
+		log.Info("No need to execute this DDL in DuckDB", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	// One DDL may be rewritten to multiple DDLs
+	tx, err := dc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, ddl := range ddls {
+		if _, err := tx.Exec(ddl); err != nil {
+			_ = tx.Rollback()
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	// update columns
+	dc.columns = tableDef.Columns
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+
+	if dc.parquetDir != "" && (tableDef.Type == timodel.ActionDropTable || tableDef.Type == timodel.ActionDropSchema) {
+		return errors.Trace(RemoveParquet(tableDef.Table, dc.parquetDir))
+	}
+	return dc.exportParquet(tableDef.Table)
+}
+
+func (dc *DuckDBConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	createTableQuery, columns, err := GenCreateSchema(dc.schemaName, sourceDatabase, sourceTable, sourceTiDBConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in DuckDB", redact.Query("query", createTableQuery))
+	_, err = dc.db.Exec(createTableQuery)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The columns are needed to convert the CSV fields when loading snapshot
+	dc.columns = columns
+
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+func (dc *DuckDBConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	if len(dc.columns) == 0 {
+		return errors.New("Columns not initialized, table schema should be copied before loading snapshot")
+	}
+	// filePrefix is relative to the root of the storage
+	ctx := context.Background()
+	rootURI := *dc.storageURI
+	rootURI.Path = "/"
+	extStorage, err := openStorage(ctx, &rootURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	files := make([]string, 0)
+	err = extStorage.WalkDir(ctx, &storage.WalkOption{SubDir: path.Dir(filePrefix)}, func(filePath string, _ int64) error {
+		if strings.HasPrefix(filePath, filePrefix) && strings.HasSuffix(filePath, ".csv") {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sort.Strings(files)
+
+	// load all the files in one transaction, so that a failed load leaves nothing behind
+	tx, err := dc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var loadedRows int64
+	for _, file := range files {
+		reader, err := extStorage.Open(ctx, file)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Trace(err)
+		}
+		rows, err := InsertSnapshotFile(tx, dc.schemaName, targetTable, dc.columns, reader)
+		reader.Close()
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Annotatef(err, "Failed to insert file %s", file)
+		}
+		loadedRows += rows
+		if onSnapshotLoadProgress != nil {
+			onSnapshotLoadProgress(loadedRows)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix), zap.Int("files", len(files)))
+	return dc.exportParquet(targetTable)
+}
+
+func (dc *DuckDBConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(dc.db, dc.schemaName, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (dc *DuckDBConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(dc.db, dc.schemaName, schema, table, commitTs))
+}
+
+func (dc *DuckDBConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	ctx := context.Background()
+	extStorage, err := openStorage(ctx, uri)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reader, err := extStorage.Open(ctx, file.Path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer reader.Close()
+
+	// insert the file into the staging table, apply it and record it in the apply log atomically
+	tx, err := dc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = InsertIncrementFile(tx, dc.stageName, tableDef.Columns, reader); err != nil {
+		_ = tx.Rollback()
+		return errors.Annotate(err, "Failed to insert file into staging table")
+	}
+	deleteQuery, insertQuery := GenApplyIncrement(dc.schemaName, tableDef, dc.stageName)
+	for _, query := range []string{deleteQuery, insertQuery} {
+		if _, err = tx.Exec(query); err != nil {
+			_ = tx.Rollback()
+			return errors.Trace(err)
+		}
+		log.Debug("apply staging table into table", redact.Query("query", query))
+	}
+	if err = DropStagingTable(tx, dc.stageName); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = InsertApplyLog(tx, dc.schemaName, tableDef.Schema, tableDef.Table, file); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully apply file", zap.String("file", file.Path))
+	return dc.exportParquet(tableDef.Table)
+}
+
+func (dc *DuckDBConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
+	return NewDuckDBConnector(dc.db, dc.schemaName, stageName, storageURI, dc.parquetDir)
+}
+
+func (dc *DuckDBConnector) Close() {
+	// The database is shared by all the connectors, see DuckDBConnector.db
+}
@@ -0,0 +1,148 @@
+package duckdbsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// tableName returns the quoted full qualified name of the table, e.g. `"main"."table"`
+func tableName(schemaName, table string) string {
+	return fmt.Sprintf("%s.%s", QuoteIdentifier(schemaName), QuoteIdentifier(table))
+}
+
+// GetColumnModifyDDLs returns the DDLs to modify the column.
+// DuckDB does not support multiple alterations in one ALTER TABLE statement, so each change is a DDL.
+func GetColumnModifyDDLs(table string, diff *tidbsql.ColumnDiff) ([]string, error) {
+	ddls := make([]string, 0, 3)
+	name := QuoteIdentifier(diff.After.Name)
+	beforeTp, err := GetDuckDBTypeString(*diff.Before)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	afterTp, err := GetDuckDBTypeString(*diff.After)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// e.g. INT -> MEDIUMINT, VARCHAR(10) -> VARCHAR(20) are the same type in DuckDB
+	if beforeTp != afterTp {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s;", table, name, afterTp))
+	}
+	if diff.Before.Default != diff.After.Default {
+		if diff.After.Default == nil {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;", table, name))
+		} else {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", table, name, getDefaultString(diff.After.Default)))
+		}
+	}
+	if diff.Before.Nullable != diff.After.Nullable {
+		if diff.After.Nullable == "true" {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", table, name))
+		} else {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", table, name))
+		}
+	}
+	return ddls, nil
+}
+
+func GenDDLViaColumnsDiff(schemaName string, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+	if curTableDef.Type == timodel.ActionTruncateTable {
+		return []string{fmt.Sprintf("DELETE FROM %s;", tableName(schemaName, curTableDef.Table))}, nil
+	}
+	if curTableDef.Type == timodel.ActionDropTable {
+		return []string{fmt.Sprintf("DROP TABLE %s;", tableName(schemaName, curTableDef.Table))}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateTable {
+		if len(tidbsql.GetPKColumns(curTableDef.Columns)) == 0 {
+			return nil, errors.Errorf("table %s.%s has no primary key, which is not supported by DuckDB", curTableDef.Schema, curTableDef.Table)
+		}
+		// The table is empty when created in TiDB, so the table left in DuckDB is replaced
+		createTable, err := GenCreateTable(schemaName, curTableDef.Table, curTableDef.Columns)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{createTable}, nil
+	}
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", tableName(schemaName, oldTable.Name), QuoteIdentifier(curTableDef.Table))}, nil
+	}
+	// The tables are created in the DuckDB schema of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
+	}
+
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	table := tableName(schemaName, curTableDef.Table)
+	ddls := make([]string, 0, len(columnDiff))
+	for _, item := range columnDiff {
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			colStr, err := GetDuckDBColumnString(*item.After)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, colStr))
+		case tidbsql.DROP_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, QuoteIdentifier(item.Before.Name)))
+		case tidbsql.MODIFY_COLUMN:
+			modifyDDLs, err := GetColumnModifyDDLs(table, &item)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, modifyDDLs...)
+		case tidbsql.RENAME_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", table,
+				QuoteIdentifier(item.Before.Name), QuoteIdentifier(item.After.Name)))
+		default:
+			// UNCHANGE
+		}
+	}
+
+	return ddls, nil
+}
+
+func getDefaultString(val interface{}) string {
+	str := fmt.Sprintf("%v", val)
+	if strings.HasPrefix(strings.ToUpper(str), "CURRENT_TIMESTAMP") {
+		// DuckDB does not support the fsp of CURRENT_TIMESTAMP, e.g. CURRENT_TIMESTAMP(3)
+		return "CURRENT_TIMESTAMP"
+	}
+	if _, err := strconv.ParseFloat(str, 64); err != nil {
+		return QuoteLiteral(str)
+	}
+	return str
+}
+
+// GetDuckDBColumnString returns a string describing the column in DuckDB, e.g.
+// `"id" INTEGER NOT NULL DEFAULT 0`
+// Refer to:
+// https://dev.mysql.com/doc/refman/8.0/en/data-types.html
+// https://duckdb.org/docs/sql/data_types/overview
+func GetDuckDBColumnString(column cloudstorage.TableCol) (string, error) {
+	var sb strings.Builder
+	tp, err := GetDuckDBTypeString(column)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sb.WriteString(fmt.Sprintf("%s %s", QuoteIdentifier(column.Name), tp))
+	if column.Nullable == "false" {
+		sb.WriteString(" NOT NULL")
+	}
+	if column.Default != nil {
+		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column.Default)))
+	}
+	return sb.String(), nil
+}
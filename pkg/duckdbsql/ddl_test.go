@@ -0,0 +1,92 @@
+package duckdbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/duckdbsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiff(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{
+			ID:        "1",
+			Name:      "id",
+			Tp:        "int",
+			Precision: "11",
+		},
+		{
+			ID:        "2",
+			Name:      "name",
+			Tp:        "varchar",
+			Precision: "10",
+		},
+		{
+			ID:   "3",
+			Name: "age",
+			Tp:   "int",
+		},
+		{
+			ID:   "4",
+			Name: "note",
+			Tp:   "text",
+		},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{
+				ID:        "5",
+				Name:      "id",
+				Tp:        "bigint",
+				Precision: "20",
+			},
+			{
+				ID:        "2",
+				Name:      "name",
+				Tp:        "varchar",
+				Precision: "20",
+				Nullable:  "false",
+			},
+			{
+				ID:   "4",
+				Name: "remark",
+				Tp:   "text",
+			},
+			{
+				ID:        "6",
+				Name:      "price",
+				Tp:        "decimal",
+				Default:   "0.00",
+				Precision: "10",
+				Scale:     "2",
+			},
+		},
+	}
+
+	expectedDDLs := []string{
+		`ALTER TABLE "main"."test_table" ALTER COLUMN "id" TYPE BIGINT;`,
+		`ALTER TABLE "main"."test_table" ALTER COLUMN "name" SET NOT NULL;`,
+		`ALTER TABLE "main"."test_table" DROP COLUMN "age";`,
+		`ALTER TABLE "main"."test_table" RENAME COLUMN "note" TO "remark";`,
+		`ALTER TABLE "main"."test_table" ADD COLUMN "price" DECIMAL(10, 2) DEFAULT 0.00;`,
+	}
+
+	ddl, err := duckdbsql.GenDDLViaColumnsDiff("main", prevColumns, curTableDef)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expectedDDLs, ddl)
+}
+
+func TestGenDDLViaColumnsDiffSchema(t *testing.T) {
+	for _, tableDef := range []cloudstorage.TableDefinition{
+		{Schema: "test_schema", Type: timodel.ActionCreateSchema, Query: "CREATE DATABASE test_schema"},
+		{Schema: "test_schema", Type: timodel.ActionDropSchema, Query: "DROP DATABASE test_schema"},
+	} {
+		ddls, err := duckdbsql.GenDDLViaColumnsDiff("main", nil, tableDef)
+		require.NoError(t, err)
+		require.Empty(t, ddls)
+	}
+}
@@ -0,0 +1,13 @@
+package duckdbsql
+
+import "strings"
+
+// QuoteIdentifier quotes the identifier with double quotes, e.g. `my"table` -> `"my""table"`
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteLiteral quotes the string literal with single quotes, the single quotes inside are doubled
+func QuoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,321 @@
+package duckdbsql
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/csvutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+const (
+	// The extra columns of the staging table to pick the latest change of each row
+	flagColumnName     = "tidb2dw_flag"
+	commitTsColumnName = "tidb2dw_commit_ts"
+	seqColumnName      = "tidb2dw_seq"
+)
+
+func CreateSchema(db *sql.DB, schemaName string) error {
+	_, err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdentifier(schemaName)))
+	return err
+}
+
+// GenCreateSchema generates the CREATE TABLE statement of the source table in DuckDB.
+// The primary key is not declared, because DuckDB checks the unique constraints eagerly,
+// which fails to delete and re-insert the same key in one transaction and blocks ALTER TABLE.
+// The primary key columns are still required to apply the incremental data.
+func GenCreateSchema(schemaName, sourceDatabase, sourceTable string, sourceTiDBConn *sql.DB) (string, []cloudstorage.TableCol, error) {
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	pkColumns, _, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	if len(pkColumns) == 0 {
+		return "", nil, errors.Errorf("table %s.%s has no primary key, which is not supported by DuckDB", sourceDatabase, sourceTable)
+	}
+
+	sql, err := GenCreateTable(schemaName, sourceTable, tableColumns)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	return sql, tableColumns, nil
+}
+
+// GenCreateTable returns the statement to create or replace the table with the columns.
+func GenCreateTable(schemaName, table string, columns []cloudstorage.TableCol) (string, error) {
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
+		row, err := GetDuckDBColumnString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+
+	// Add idents
+	for i := 0; i < len(columnRows); i++ {
+		columnRows[i] = fmt.Sprintf("    %s", columnRows[i])
+	}
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf("CREATE OR REPLACE TABLE %s (", tableName(schemaName, table)))
+	sql = append(sql, strings.Join(columnRows, ",\n"))
+	sql = append(sql, ");")
+
+	return strings.Join(sql, "\n"), nil
+}
+
+// toValue converts the CSV field to the value bound to the INSERT statement.
+// The binary values are written as is by dumpling, while encoded in base64 by TiCDC.
+func toValue(field *string, column cloudstorage.TableCol, base64Binary bool) (interface{}, error) {
+	if field == nil {
+		return nil, nil
+	}
+	if !isBinaryType(column) {
+		return *field, nil
+	}
+	if !base64Binary {
+		return []byte(*field), nil
+	}
+	value, err := base64.StdEncoding.DecodeString(*field)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to decode binary value of column %s", column.Name)
+	}
+	return value, nil
+}
+
+// genInsert generates the INSERT statement with one placeholder of each column.
+// The values are bound as text, and casted to the column type by DuckDB.
+func genInsert(table string, columnNames []string, columnTypes []string) string {
+	names := make([]string, 0, len(columnNames))
+	values := make([]string, 0, len(columnNames))
+	for i, name := range columnNames {
+		names = append(names, QuoteIdentifier(name))
+		values = append(values, fmt.Sprintf("CAST(? AS %s)", columnTypes[i]))
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(values, ", "))
+}
+
+func columnNamesAndTypes(columns []cloudstorage.TableCol) ([]string, []string, error) {
+	names := make([]string, 0, len(columns))
+	types := make([]string, 0, len(columns))
+	for _, col := range columns {
+		tp, err := GetDuckDBTypeString(col)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		names = append(names, col.Name)
+		types = append(types, tp)
+	}
+	return names, types, nil
+}
+
+// InsertSnapshotFile inserts the rows in the dumpling CSV file into the table.
+func InsertSnapshotFile(tx *sql.Tx, schemaName, targetTable string, columns []cloudstorage.TableCol, reader io.Reader) (int64, error) {
+	names, types, err := columnNamesAndTypes(columns)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	stmt, err := tx.Prepare(genInsert(tableName(schemaName, targetTable), names, types))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer stmt.Close()
+
+	var rows int64
+	err = csvutil.ReadRecords(reader, func(fields []*string) error {
+		if len(fields) != len(columns) {
+			return errors.Errorf("the number of fields %d does not match the number of columns %d", len(fields), len(columns))
+		}
+		values := make([]interface{}, 0, len(fields))
+		for i, field := range fields {
+			value, err := toValue(field, columns[i], false)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			values = append(values, value)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return errors.Trace(err)
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return rows, nil
+}
+
+// InsertIncrementFile inserts the changes in the TiCDC CSV file into a temporary staging table.
+// The staging table should be dropped by DropStagingTable before the transaction ends.
+func InsertIncrementFile(tx *sql.Tx, stagingTable string, columns []cloudstorage.TableCol, reader io.Reader) error {
+	names, types, err := columnNamesAndTypes(columns)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	names = append([]string{flagColumnName, commitTsColumnName, seqColumnName}, names...)
+	types = append([]string{"VARCHAR", "UBIGINT", "BIGINT"}, types...)
+	sqlRows := make([]string, 0, len(names))
+	for i, name := range names {
+		sqlRows = append(sqlRows, fmt.Sprintf("%s %s", QuoteIdentifier(name), types[i]))
+	}
+	if _, err := tx.Exec(fmt.Sprintf("CREATE OR REPLACE TEMP TABLE %s (%s)", QuoteIdentifier(stagingTable), strings.Join(sqlRows, ", "))); err != nil {
+		return errors.Trace(err)
+	}
+
+	stmt, err := tx.Prepare(genInsert(QuoteIdentifier(stagingTable), names, types))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer stmt.Close()
+
+	var seq int64
+	return csvutil.ReadRecords(reader, func(fields []*string) error {
+		// The TiCDC CSV fields are: flag, table, schema, commit ts, columns...
+		if len(fields) != len(columns)+4 {
+			return errors.Errorf("the number of fields %d does not match the number of columns %d", len(fields), len(columns)+4)
+		}
+		if fields[0] == nil || fields[3] == nil {
+			return errors.New("the flag or commit ts of the change is NULL")
+		}
+		if _, err := strconv.ParseUint(*fields[3], 10, 64); err != nil {
+			return errors.Annotate(err, "failed to parse commit ts")
+		}
+		seq++
+		values := make([]interface{}, 0, len(names))
+		values = append(values, *fields[0], *fields[3], strconv.FormatInt(seq, 10))
+		for i, field := range fields[4:] {
+			value, err := toValue(field, columns[i], true)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			values = append(values, value)
+		}
+		_, err := stmt.Exec(values...)
+		return errors.Trace(err)
+	})
+}
+
+func DropStagingTable(tx *sql.Tx, stagingTable string) error {
+	_, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", QuoteIdentifier(stagingTable)))
+	return err
+}
+
+// GenApplyIncrement generates the DELETE and INSERT statements to apply the latest change
+// of each row in the staging table to the target table.
+// All the rows changed in the staging table are deleted first, then the latest version of
+// the rows which are not deleted are inserted.
+func GenApplyIncrement(schemaName string, tableDef cloudstorage.TableDefinition, stagingTable string) (string, string) {
+	pkColumn := make([]string, 0)
+	onStat := make([]string, 0)
+	for _, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			pkColumn = append(pkColumn, QuoteIdentifier(col.Name))
+			onStat = append(onStat, fmt.Sprintf("S.%s = %s.%s", QuoteIdentifier(col.Name), QuoteIdentifier(tableDef.Table), QuoteIdentifier(col.Name)))
+		}
+	}
+	columns := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		columns = append(columns, QuoteIdentifier(col.Name))
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE EXISTS (SELECT 1 FROM %s AS S WHERE %s)",
+		tableName(schemaName, tableDef.Table),
+		QuoteIdentifier(stagingTable),
+		strings.Join(onStat, " AND "))
+
+	// the latest change of each row, the changes with the same commit ts are ordered by their position in the file
+	insertQuery := fmt.Sprintf(`INSERT INTO %s (%s)
+SELECT %s FROM %s
+QUALIFY row_number() OVER (PARTITION BY %s ORDER BY %s DESC, %s DESC) = 1 AND %s != 'D'`,
+		tableName(schemaName, tableDef.Table),
+		strings.Join(columns, ", "),
+		strings.Join(columns, ", "),
+		QuoteIdentifier(stagingTable),
+		strings.Join(pkColumn, ", "),
+		QuoteIdentifier(commitTsColumnName),
+		QuoteIdentifier(seqColumnName),
+		QuoteIdentifier(flagColumnName))
+
+	return deleteQuery, insertQuery
+}
+
+// ExportParquet exports the table as a Parquet file named `<table>.parquet` in the directory.
+// The file is written to a temporary file first and then renamed, so that the readers never see a partial file.
+func ExportParquet(db *sql.DB, schemaName, table, dir string) error {
+	target := filepath.Join(dir, fmt.Sprintf("%s.parquet", table))
+	tmp := target + ".tmp"
+	_, err := db.Exec(fmt.Sprintf("COPY (SELECT * FROM %s) TO %s (FORMAT PARQUET)", tableName(schemaName, table), QuoteLiteral(tmp)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, target))
+}
+
+// RemoveParquet removes the exported Parquet file of the table if exists.
+func RemoveParquet(table, dir string) error {
+	err := os.Remove(filepath.Join(dir, fmt.Sprintf("%s.parquet", table)))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// ApplyLogTableName is the table which records the increment files loaded into DuckDB.
+const ApplyLogTableName = "tidb2dw_apply_log"
+
+func CreateApplyLogTable(db *sql.DB, schemaName string) error {
+	sql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    schema_name VARCHAR NOT NULL,
+    table_name VARCHAR NOT NULL,
+    file_path VARCHAR NOT NULL,
+    checksum VARCHAR NOT NULL,
+    min_commit_ts UBIGINT,
+    max_commit_ts UBIGINT,
+    applied_at TIMESTAMP DEFAULT current_timestamp
+)`, tableName(schemaName, ApplyLogTableName))
+	_, err := db.Exec(sql)
+	return err
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(db *sql.DB, schemaName, tableSchema, table string, file coreinterfaces.IncrementFile) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE schema_name = ? AND table_name = ? AND file_path = ? AND checksum = ?`, tableName(schemaName, ApplyLogTableName)),
+		tableSchema, table, file.Path, file.Checksum).Scan(&count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+func TrimApplyLog(db *sql.DB, schemaName, tableSchema, table string, commitTs uint64) error {
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE schema_name = ? AND table_name = ? AND max_commit_ts < ?`, tableName(schemaName, ApplyLogTableName)),
+		tableSchema, table, commitTs)
+	return errors.Trace(err)
+}
+
+// InsertApplyLog records the increment file in the apply log, it should be executed
+// in the same transaction of loading the file.
+func InsertApplyLog(tx *sql.Tx, schemaName, tableSchema, table string, file coreinterfaces.IncrementFile) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (schema_name, table_name, file_path, checksum, min_commit_ts, max_commit_ts) VALUES (?, ?, ?, ?, ?, ?)`, tableName(schemaName, ApplyLogTableName)),
+		tableSchema, table, file.Path, file.Checksum, file.MinCommitTs, file.MaxCommitTs)
+	return err
+}
@@ -0,0 +1,73 @@
+package duckdbsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// TiDB2DuckDBTypeMap is a map from TiDB type to DuckDB type.
+var TiDB2DuckDBTypeMap map[string]string = map[string]string{
+	"text":       "VARCHAR",
+	"tinytext":   "VARCHAR",
+	"mediumtext": "VARCHAR",
+	"longtext":   "VARCHAR",
+	"blob":       "BLOB",
+	"tinyblob":   "BLOB",
+	"mediumblob": "BLOB",
+	"longblob":   "BLOB",
+	"varchar":    "VARCHAR",
+	"char":       "VARCHAR",
+	"binary":     "BLOB",
+	"varbinary":  "BLOB",
+	"int":        "INTEGER",
+	"mediumint":  "INTEGER",
+	"tinyint":    "TINYINT",
+	"smallint":   "SMALLINT",
+	"bigint":     "BIGINT",
+	"float":      "FLOAT",
+	"double":     "DOUBLE",
+	"decimal":    "DECIMAL",
+	"numeric":    "DECIMAL",
+	"bool":       "BOOLEAN",
+	"boolean":    "BOOLEAN",
+	"date":       "DATE",
+	"datetime":   "TIMESTAMP",
+	"timestamp":  "TIMESTAMP",
+	// The range of TiDB TIME is -838:59:59 to 838:59:59, which exceeds DuckDB TIME.
+	"time": "VARCHAR",
+}
+
+// GetDuckDBTypeString returns the type of the column in DuckDB, e.g. "DECIMAL(10, 2)"
+func GetDuckDBTypeString(column cloudstorage.TableCol) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	switch tp {
+	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob", "binary", "varbinary":
+		return TiDB2DuckDBTypeMap[tp], nil
+	case "varchar", "char", "time":
+		// The length of VARCHAR is not enforced by DuckDB
+		return TiDB2DuckDBTypeMap[tp], nil
+	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date", "datetime", "timestamp":
+		return TiDB2DuckDBTypeMap[tp], nil
+	case "decimal", "numeric":
+		precision, err := strconv.Atoi(column.Precision)
+		if err != nil {
+			return "", errors.Annotatef(err, "invalid precision of column %s", column.Name)
+		}
+		// The max precision of DuckDB DECIMAL is 38, keep the larger ones as text to avoid precision loss
+		if precision > 38 {
+			return "VARCHAR", nil
+		}
+		return fmt.Sprintf("%s(%s, %s)", TiDB2DuckDBTypeMap[tp], column.Precision, column.Scale), nil
+	default:
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
+
+// isBinaryType returns whether the column is stored as BLOB in DuckDB.
+func isBinaryType(column cloudstorage.TableCol) bool {
+	return TiDB2DuckDBTypeMap[strings.ToLower(column.Tp)] == "BLOB"
+}
@@ -0,0 +1,471 @@
+package iceberg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/google/uuid"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	putil "github.com/pingcap/tiflow/pkg/util"
+	"go.uber.org/zap"
+)
+
+const (
+	// The keys in the snapshot summary which record the increment file committed by the snapshot,
+	// so that the snapshots of the table are the apply log of it.
+	summaryFilePath = "tidb2dw.file-path"
+	summaryChecksum = "tidb2dw.checksum"
+	// summaryCommitTs is the max commit ts of the increment file, the snapshot is expired once the
+	// file is covered by the checkpoint, see TrimApplyLog.
+	summaryCommitTs = "tidb2dw.commit-ts"
+)
+
+// A Wrapper of the Iceberg tables in the warehouse location.
+// It implements the coreinterfaces.Connector interface.
+type IcebergConnector struct {
+	// warehouse is the storage where the tables are written, each table is a directory in it.
+	warehouse    storage.ExternalStorage
+	warehouseURI *url.URL
+	// storageURI is the location of the files to load.
+	storageURI *url.URL
+
+	columns []cloudstorage.TableCol
+}
+
+func NewIcebergConnector(warehouseURI *url.URL, storageURI *url.URL) (*IcebergConnector, error) {
+	warehouse, err := putil.GetExternalStorageFromURI(context.Background(), warehouseURI.String())
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open warehouse storage")
+	}
+	return &IcebergConnector{
+		warehouse:    warehouse,
+		warehouseURI: warehouseURI,
+		storageURI:   storageURI,
+		columns:      nil,
+	}, nil
+}
+
+// tableLocation returns the location of the table written in the metadata, e.g. s3://bucket/warehouse/table
+// The query of the warehouse uri is dropped, since it may contain the credentials.
+func (ic *IcebergConnector) tableLocation(table string) string {
+	location := *ic.warehouseURI
+	location.RawQuery = ""
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(location.String(), "/"), table)
+}
+
+// newSnapshotID returns a positive snapshot id, the snapshots of one table are committed one by one,
+// so the timestamp is unique.
+func newSnapshotID() int64 {
+	return time.Now().UnixNano()
+}
+
+// commitSnapshot commits a snapshot which adds the data files and delete files to the table.
+// If keepExisting is false, the files of the previous snapshot are removed from the table.
+func (ic *IcebergConnector) commitSnapshot(ctx context.Context, table string, metadata *TableMetadata, version int,
+	operation string, keepExisting bool, dataFiles, deleteFiles []DataFile, summary map[string]string,
+) error {
+	snapshot := Snapshot{
+		SnapshotID:     newSnapshotID(),
+		SequenceNumber: metadata.LastSequenceNumber + 1,
+		TimestampMs:    time.Now().UnixMilli(),
+		Summary:        map[string]string{"operation": operation},
+		SchemaID:       metadata.CurrentSchemaID,
+	}
+	for k, v := range summary {
+		snapshot.Summary[k] = v
+	}
+	manifests := make([]ManifestFile, 0)
+	if current := metadata.currentSnapshot(); current != nil {
+		parentID := current.SnapshotID
+		snapshot.ParentSnapshotID = &parentID
+		if keepExisting {
+			relPath := strings.TrimPrefix(current.ManifestList, metadata.Location+"/")
+			existing, err := readManifestList(ctx, ic.warehouse, path.Join(table, relPath))
+			if err != nil {
+				return errors.Annotate(err, "Failed to read manifest list")
+			}
+			manifests = append(manifests, existing...)
+		}
+	}
+	schema := metadata.currentSchema()
+	manifestID := uuid.NewString()
+	for i, files := range [][]DataFile{dataFiles, deleteFiles} {
+		if len(files) == 0 {
+			continue
+		}
+		relPath := fmt.Sprintf("metadata/%s-m%d.avro", manifestID, i)
+		manifest, err := writeManifest(ctx, ic.warehouse, path.Join(table, relPath), fmt.Sprintf("%s/%s", metadata.Location, relPath),
+			schema, snapshot.SnapshotID, snapshot.SequenceNumber, files)
+		if err != nil {
+			return errors.Annotate(err, "Failed to write manifest")
+		}
+		manifests = append(manifests, manifest)
+	}
+	relPath := fmt.Sprintf("metadata/snap-%d-%s.avro", snapshot.SnapshotID, manifestID)
+	if err := writeManifestList(ctx, ic.warehouse, path.Join(table, relPath), &snapshot, manifests); err != nil {
+		return errors.Annotate(err, "Failed to write manifest list")
+	}
+	snapshot.ManifestList = fmt.Sprintf("%s/%s", metadata.Location, relPath)
+	metadata.addSnapshot(snapshot)
+	if _, err := commitTableMetadata(ctx, ic.warehouse, table, metadata, version); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// writeDataFile writes the parquet file into the data directory of the table.
+func (ic *IcebergConnector) writeDataFile(ctx context.Context, table string, content int, data []byte, rows int64, equalityIDs []int) (DataFile, error) {
+	name := fmt.Sprintf("data/%s.parquet", uuid.NewString())
+	if content == contentEqualityDelete {
+		name = fmt.Sprintf("data/%s-deletes.parquet", uuid.NewString())
+	}
+	if err := ic.warehouse.WriteFile(ctx, path.Join(table, name), data); err != nil {
+		return DataFile{}, errors.Trace(err)
+	}
+	return DataFile{
+		Content:     content,
+		Path:        fmt.Sprintf("%s/%s", ic.tableLocation(table), name),
+		RecordCount: rows,
+		SizeInBytes: int64(len(data)),
+		EqualityIDs: equalityIDs,
+	}, nil
+}
+
+func (ic *IcebergConnector) loadTable(ctx context.Context, table string) (*TableMetadata, int, error) {
+	metadata, version, err := loadTableMetadata(ctx, ic.warehouse, table)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if metadata == nil {
+		return nil, 0, errors.Errorf("iceberg table %s does not exist", table)
+	}
+	return metadata, version, nil
+}
+
+func (ic *IcebergConnector) InitSchema(_ string, columns []cloudstorage.TableCol) error {
+	if len(ic.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	ic.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (ic *IcebergConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	if len(ic.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	ctx := context.Background()
+	switch tableDef.Type {
+	case timodel.ActionCreateTable:
+		// The table is empty when created in TiDB, so the table left in the warehouse is replaced
+		if len(tidbsql.GetPKColumns(tableDef.Columns)) == 0 {
+			return errors.Errorf("table %s.%s has no primary key, which is not supported by Iceberg", tableDef.Schema, tableDef.Table)
+		}
+		if err := ic.createTable(ctx, tableDef.Table, tableDef.Columns); err != nil {
+			return errors.Annotatef(err, "Failed to create table %s", tableDef.Table)
+		}
+		ic.columns = tableDef.Columns
+		log.Info("Successfully executed DDL", zap.String("received", tableDef.Query))
+		return nil
+	case timodel.ActionRenameTable, timodel.ActionRenameTables:
+		// The location of the table is recorded in the metadata files, which can not be moved atomically
+		return errors.New("Received rename table ddl, which is not supported by Iceberg. " +
+			"If you want to rename table, please start a new task to capture the new table")
+	case timodel.ActionCreateSchema:
+		log.Info("No need to execute this DDL in Iceberg", zap.String("ddl", tableDef.Query))
+		return nil
+	case timodel.ActionDropSchema:
+		// The tables of all the databases are written into one warehouse location, which may be shared with
+		// other tasks, so the tables of the dropped database are kept and should be dropped manually
+		log.Warn("Tables of the dropped database are kept in Iceberg", zap.String("ddl", tableDef.Query))
+		return nil
+	case timodel.ActionDropTable:
+		if err := dropTable(ctx, ic.warehouse, tableDef.Table); err != nil {
+			return errors.Annotatef(err, "Failed to drop table %s", tableDef.Table)
+		}
+		log.Info("Successfully dropped table", zap.String("received", tableDef.Query), zap.String("table", tableDef.Table))
+		return nil
+	}
+
+	metadata, version, err := ic.loadTable(ctx, tableDef.Table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if tableDef.Type == timodel.ActionTruncateTable {
+		// commit an empty snapshot
+		if err = ic.commitSnapshot(ctx, tableDef.Table, metadata, version, "delete", false, nil, nil, nil); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("Successfully executed DDL", zap.String("received", tableDef.Query))
+		return nil
+	}
+
+	prevSchema := metadata.currentSchema()
+	schema, lastColumnID, err := GenSchemaViaColumnsDiff(*prevSchema, metadata.LastColumnID, ic.columns, tableDef)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// update columns
+	ic.columns = tableDef.Columns
+	if sameFields(*prevSchema, schema) {
+		log.Info("No need to execute this DDL in Iceberg", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	metadata.addSchema(schema, lastColumnID)
+	if _, err = commitTableMetadata(ctx, ic.warehouse, tableDef.Table, metadata, version); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), zap.Any("schema", schema))
+	return nil
+}
+
+func (ic *IcebergConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	columns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pkColumns, _, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	hasPK := false
+	for _, pkColumn := range pkColumns {
+		for i := range columns {
+			if columns[i].Name == pkColumn {
+				columns[i].IsPK = "true"
+				hasPK = true
+			}
+		}
+	}
+	// The primary key is required by the equality deletes
+	if !hasPK {
+		return errors.Errorf("table %s.%s has no primary key, which is not supported by Iceberg", sourceDatabase, sourceTable)
+	}
+	if err = ic.createTable(context.Background(), sourceTable, columns); err != nil {
+		return errors.Trace(err)
+	}
+	// The columns are needed to convert the CSV fields when loading snapshot
+	ic.columns = columns
+
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+// createTable replaces the existing table with an empty table of the columns.
+func (ic *IcebergConnector) createTable(ctx context.Context, table string, columns []cloudstorage.TableCol) error {
+	schema, lastColumnID, err := NewSchema(columns)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = dropTable(ctx, ic.warehouse, table); err != nil {
+		return errors.Trace(err)
+	}
+	metadata := newTableMetadata(ic.tableLocation(table), schema, lastColumnID)
+	if _, err = commitTableMetadata(ctx, ic.warehouse, table, metadata, 0); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Created Iceberg table", zap.String("table", table), zap.Any("schema", schema))
+	return nil
+}
+
+func (ic *IcebergConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	if len(ic.columns) == 0 {
+		return errors.New("Columns not initialized, table schema should be copied before loading snapshot")
+	}
+	ctx := context.Background()
+	metadata, version, err := ic.loadTable(ctx, targetTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fields, err := fieldsOfColumns(metadata.currentSchema(), ic.columns)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// filePrefix is relative to the root of the storage
+	rootURI := *ic.storageURI
+	rootURI.Path = "/"
+	extStorage, err := putil.GetExternalStorageFromURI(ctx, rootURI.String())
+	if err != nil {
+		return errors.Annotate(err, "Failed to open storage")
+	}
+	files := make([]string, 0)
+	err = extStorage.WalkDir(ctx, &storage.WalkOption{SubDir: path.Dir(filePrefix)}, func(filePath string, _ int64) error {
+		if strings.HasPrefix(filePath, filePrefix) && strings.HasSuffix(filePath, ".csv") {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sort.Strings(files)
+
+	// all the files are committed in one snapshot, so that a failed load leaves nothing visible
+	dataFiles := make([]DataFile, 0, len(files))
+	var loadedRows int64
+	for _, file := range files {
+		reader, err := extStorage.Open(ctx, file)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		data, rows, err := WriteSnapshotFile(fields, reader)
+		reader.Close()
+		if err != nil {
+			return errors.Annotatef(err, "Failed to convert file %s", file)
+		}
+		dataFile, err := ic.writeDataFile(ctx, targetTable, contentData, data, rows, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dataFiles = append(dataFiles, dataFile)
+		loadedRows += rows
+		if onSnapshotLoadProgress != nil {
+			onSnapshotLoadProgress(loadedRows)
+		}
+	}
+	if len(dataFiles) > 0 {
+		if err = ic.commitSnapshot(ctx, targetTable, metadata, version, "append", true, dataFiles, nil, nil); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix), zap.Int("files", len(files)))
+	return nil
+}
+
+func (ic *IcebergConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	metadata, _, err := loadTableMetadata(context.Background(), ic.warehouse, tableDef.Table)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if metadata == nil {
+		return false, nil
+	}
+	for _, snapshot := range metadata.Snapshots {
+		if snapshot.Summary[summaryFilePath] == file.Path && snapshot.Summary[summaryChecksum] == file.Checksum {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TrimApplyLog expires the snapshots of the increment files covered by the checkpoint, since the applied
+// files are recorded in the snapshots, and all the snapshots are kept in the metadata rewritten by each commit.
+// The manifest lists of the expired snapshots are deleted, the manifests and data files are still used by
+// the current snapshot.
+func (ic *IcebergConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	ctx := context.Background()
+	metadata, version, err := loadTableMetadata(ctx, ic.warehouse, table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if metadata == nil {
+		return nil
+	}
+	expired := metadata.expireSnapshots(commitTs)
+	if len(expired) == 0 {
+		return nil
+	}
+	if _, err = commitTableMetadata(ctx, ic.warehouse, table, metadata, version); err != nil {
+		return errors.Trace(err)
+	}
+	for _, snapshot := range expired {
+		if err = deleteTableFile(ctx, ic.warehouse, table, metadata.Location, snapshot.ManifestList); err != nil {
+			return errors.Annotate(err, "Failed to delete manifest list of expired snapshot")
+		}
+	}
+	log.Info("Expired snapshots of table", zap.String("schema", schema), zap.String("table", table), zap.Int("snapshots", len(expired)), zap.Uint64("commitTs", commitTs))
+	return nil
+}
+
+func (ic *IcebergConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	ctx := context.Background()
+	metadata, version, err := ic.loadTable(ctx, tableDef.Table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	schema := metadata.currentSchema()
+	fields, err := fieldsOfColumns(schema, tableDef.Columns)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pkFields := make([]int, 0)
+	equalityIDs := make([]int, 0)
+	for i, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			pkFields = append(pkFields, i)
+			equalityIDs = append(equalityIDs, fields[i].ID)
+		}
+	}
+	if len(pkFields) == 0 {
+		return errors.Errorf("table %s has no primary key, which is not supported by Iceberg", tableDef.Table)
+	}
+
+	extStorage, err := putil.GetExternalStorageFromURI(ctx, uri.String())
+	if err != nil {
+		return errors.Annotate(err, "Failed to open storage")
+	}
+	reader, err := extStorage.Open(ctx, file.Path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, dataRows, deletes, deleteRows, err := WriteIncrementFile(fields, pkFields, reader)
+	reader.Close()
+	if err != nil {
+		return errors.Annotatef(err, "Failed to convert file %s", file.Path)
+	}
+
+	dataFiles := make([]DataFile, 0, 1)
+	if dataRows > 0 {
+		dataFile, err := ic.writeDataFile(ctx, tableDef.Table, contentData, data, dataRows, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dataFiles = append(dataFiles, dataFile)
+	}
+	deleteFiles := make([]DataFile, 0, 1)
+	if deleteRows > 0 {
+		deleteFile, err := ic.writeDataFile(ctx, tableDef.Table, contentEqualityDelete, deletes, deleteRows, equalityIDs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		deleteFiles = append(deleteFiles, deleteFile)
+	}
+	// the file is recorded in the snapshot summary, which is committed atomically with the data
+	summary := map[string]string{
+		summaryFilePath: file.Path,
+		summaryChecksum: file.Checksum,
+		summaryCommitTs: strconv.FormatUint(file.MaxCommitTs, 10),
+	}
+	if err = ic.commitSnapshot(ctx, tableDef.Table, metadata, version, "overwrite", true, dataFiles, deleteFiles, summary); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully apply file", zap.String("file", file.Path), zap.Int64("rows", dataRows), zap.Int64("deletes", deleteRows))
+	return nil
+}
+
+func (ic *IcebergConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
+	return NewIcebergConnector(ic.warehouseURI, storageURI)
+}
+
+func (ic *IcebergConnector) Close() {
+	// nothing to close, the tables are written through the storage
+}
@@ -0,0 +1,200 @@
+package iceberg
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/csvutil"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetWriter writes the rows into an in-memory parquet file.
+type parquetWriter struct {
+	buf  bytes.Buffer
+	pw   *writer.CSVWriter
+	rows int64
+}
+
+func newParquetWriter(fields []NestedField) (*parquetWriter, error) {
+	md := make([]string, 0, len(fields))
+	for _, field := range fields {
+		tag, err := parquetColumnTag(field)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		md = append(md, tag)
+	}
+	w := &parquetWriter{}
+	pw, err := writer.NewCSVWriterFromWriter(md, &w.buf, 4)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	w.pw = pw
+	return w, nil
+}
+
+func (w *parquetWriter) write(row []interface{}) error {
+	w.rows++
+	return errors.Trace(w.pw.Write(row))
+}
+
+// finish writes the footer and returns the content of the parquet file.
+func (w *parquetWriter) finish() ([]byte, error) {
+	if err := w.pw.WriteStop(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return w.buf.Bytes(), nil
+}
+
+// fieldsOfColumns returns the fields of the columns in the current schema.
+func fieldsOfColumns(schema *Schema, columns []cloudstorage.TableCol) ([]NestedField, error) {
+	fields := make([]NestedField, 0, len(columns))
+	for _, column := range columns {
+		idx := schema.findField(column.Name)
+		if idx < 0 {
+			return nil, errors.Errorf("column %s not found in the iceberg schema", column.Name)
+		}
+		fields = append(fields, schema.Fields[idx])
+	}
+	return fields, nil
+}
+
+// convertRow converts the CSV fields to the values written into the parquet file.
+func convertRow(fields []NestedField, values []*string, base64Binary bool) ([]interface{}, error) {
+	row := make([]interface{}, 0, len(values))
+	for i, value := range values {
+		v, err := toParquetValue(value, fields[i].Type, base64Binary)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to convert value of column %s", fields[i].Name)
+		}
+		row = append(row, v)
+	}
+	return row, nil
+}
+
+// WriteSnapshotFile converts the rows in the dumpling CSV file into a parquet data file.
+func WriteSnapshotFile(fields []NestedField, reader io.Reader) ([]byte, int64, error) {
+	w, err := newParquetWriter(fields)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	err = csvutil.ReadRecords(reader, func(values []*string) error {
+		if len(values) != len(fields) {
+			return errors.Errorf("the number of fields %d does not match the number of columns %d", len(values), len(fields))
+		}
+		row, err := convertRow(fields, values, false)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return w.write(row)
+	})
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	data, err := w.finish()
+	return data, w.rows, errors.Trace(err)
+}
+
+// change is the latest change of a row in the TiCDC CSV file.
+type change struct {
+	flag     string
+	commitTs uint64
+	values   []*string
+}
+
+// WriteIncrementFile converts the changes in the TiCDC CSV file into a parquet data file of the
+// latest inserted or updated rows, and a parquet equality delete file of all the changed primary keys.
+// The delete file is committed with the same sequence number as the data file, so it only deletes
+// the rows of the previous snapshots.
+// Refer to: https://iceberg.apache.org/spec/#equality-delete-files
+func WriteIncrementFile(fields []NestedField, pkFields []int, reader io.Reader) (data []byte, dataRows int64, deletes []byte, deleteRows int64, err error) {
+	latest := make(map[string]*change)
+	keys := make([]string, 0)
+	err = csvutil.ReadRecords(reader, func(values []*string) error {
+		// The TiCDC CSV fields are: flag, table, schema, commit ts, columns...
+		if len(values) != len(fields)+4 {
+			return errors.Errorf("the number of fields %d does not match the number of columns %d", len(values), len(fields)+4)
+		}
+		if values[0] == nil || values[3] == nil {
+			return errors.New("the flag or commit ts of the change is NULL")
+		}
+		commitTs, err := strconv.ParseUint(*values[3], 10, 64)
+		if err != nil {
+			return errors.Annotate(err, "failed to parse commit ts")
+		}
+		c := &change{flag: *values[0], commitTs: commitTs, values: values[4:]}
+		key := pkKey(c.values, pkFields)
+		prev, ok := latest[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		// the changes with the same commit ts are ordered by their position in the file
+		if !ok || c.commitTs >= prev.commitTs {
+			latest[key] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, nil, 0, errors.Trace(err)
+	}
+
+	dataWriter, err := newParquetWriter(fields)
+	if err != nil {
+		return nil, 0, nil, 0, errors.Trace(err)
+	}
+	deleteFields := make([]NestedField, 0, len(pkFields))
+	for _, idx := range pkFields {
+		deleteFields = append(deleteFields, fields[idx])
+	}
+	deleteWriter, err := newParquetWriter(deleteFields)
+	if err != nil {
+		return nil, 0, nil, 0, errors.Trace(err)
+	}
+	for _, key := range keys {
+		c := latest[key]
+		pkValues := make([]*string, 0, len(pkFields))
+		for _, idx := range pkFields {
+			pkValues = append(pkValues, c.values[idx])
+		}
+		row, err := convertRow(deleteFields, pkValues, true)
+		if err != nil {
+			return nil, 0, nil, 0, errors.Trace(err)
+		}
+		if err = deleteWriter.write(row); err != nil {
+			return nil, 0, nil, 0, errors.Trace(err)
+		}
+		if c.flag == "D" {
+			continue
+		}
+		row, err = convertRow(fields, c.values, true)
+		if err != nil {
+			return nil, 0, nil, 0, errors.Trace(err)
+		}
+		if err = dataWriter.write(row); err != nil {
+			return nil, 0, nil, 0, errors.Trace(err)
+		}
+	}
+	if data, err = dataWriter.finish(); err != nil {
+		return nil, 0, nil, 0, errors.Trace(err)
+	}
+	if deletes, err = deleteWriter.finish(); err != nil {
+		return nil, 0, nil, 0, errors.Trace(err)
+	}
+	return data, dataWriter.rows, deletes, deleteWriter.rows, nil
+}
+
+// pkKey returns the key of the row identified by the primary key values.
+func pkKey(values []*string, pkFields []int) string {
+	var sb strings.Builder
+	for _, idx := range pkFields {
+		if values[idx] != nil {
+			sb.WriteString(strconv.Quote(*values[idx]))
+		}
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
@@ -0,0 +1,262 @@
+package iceberg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// The manifests and manifest lists are Avro files, refer to:
+// https://iceberg.apache.org/spec/#manifests
+
+const manifestEntrySchema = `{
+  "type": "record",
+  "name": "manifest_entry",
+  "fields": [
+    {"name": "status", "type": "int", "field-id": 0},
+    {"name": "snapshot_id", "type": ["null", "long"], "default": null, "field-id": 1},
+    {"name": "sequence_number", "type": ["null", "long"], "default": null, "field-id": 3},
+    {"name": "file_sequence_number", "type": ["null", "long"], "default": null, "field-id": 4},
+    {"name": "data_file", "field-id": 2, "type": {
+      "type": "record",
+      "name": "r2",
+      "fields": [
+        {"name": "content", "type": "int", "field-id": 134},
+        {"name": "file_path", "type": "string", "field-id": 100},
+        {"name": "file_format", "type": "string", "field-id": 101},
+        {"name": "partition", "type": {"type": "record", "name": "r102", "fields": []}, "field-id": 102},
+        {"name": "record_count", "type": "long", "field-id": 103},
+        {"name": "file_size_in_bytes", "type": "long", "field-id": 104},
+        {"name": "equality_ids", "type": ["null", {"type": "array", "items": "int", "element-id": 136}], "default": null, "field-id": 135}
+      ]
+    }}
+  ]
+}`
+
+const manifestFileSchema = `{
+  "type": "record",
+  "name": "manifest_file",
+  "fields": [
+    {"name": "manifest_path", "type": "string", "field-id": 500},
+    {"name": "manifest_length", "type": "long", "field-id": 501},
+    {"name": "partition_spec_id", "type": "int", "field-id": 502},
+    {"name": "content", "type": "int", "field-id": 517},
+    {"name": "sequence_number", "type": "long", "field-id": 515},
+    {"name": "min_sequence_number", "type": "long", "field-id": 516},
+    {"name": "added_snapshot_id", "type": "long", "field-id": 503},
+    {"name": "added_files_count", "type": "int", "field-id": 504},
+    {"name": "existing_files_count", "type": "int", "field-id": 505},
+    {"name": "deleted_files_count", "type": "int", "field-id": 506},
+    {"name": "added_rows_count", "type": "long", "field-id": 512},
+    {"name": "existing_rows_count", "type": "long", "field-id": 513},
+    {"name": "deleted_rows_count", "type": "long", "field-id": 514}
+  ]
+}`
+
+const (
+	// the content of data files
+	contentData           = 0
+	contentEqualityDelete = 2
+	// the content of manifests
+	manifestContentData    = 0
+	manifestContentDeletes = 1
+	// the status of manifest entries
+	entryStatusAdded = 1
+)
+
+// DataFile is a data file or a delete file written by tidb2dw.
+type DataFile struct {
+	Content     int
+	Path        string
+	RecordCount int64
+	SizeInBytes int64
+	// EqualityIDs are the field ids used by the equality delete file
+	EqualityIDs []int
+}
+
+// ManifestFile is an entry of the manifest list.
+type ManifestFile struct {
+	Path               string
+	Length             int64
+	Content            int
+	SequenceNumber     int64
+	MinSequenceNumber  int64
+	AddedSnapshotID    int64
+	AddedFilesCount    int
+	ExistingFilesCount int
+	DeletedFilesCount  int
+	AddedRowsCount     int64
+	ExistingRowsCount  int64
+	DeletedRowsCount   int64
+}
+
+func writeAvro(schema string, metadata map[string]string, records []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	meta := make(map[string][]byte, len(metadata))
+	for k, v := range metadata {
+		meta[k] = []byte(v)
+	}
+	writer, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               &buf,
+		Schema:          schema,
+		CompressionName: goavro.CompressionDeflateLabel,
+		MetaData:        meta,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = writer.Append(records); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeManifest writes the files added by the snapshot into a manifest, all the files should have the same content.
+func writeManifest(ctx context.Context, extStorage storage.ExternalStorage, relPath string, location string,
+	schema *Schema, snapshotID, sequenceNumber int64, files []DataFile,
+) (ManifestFile, error) {
+	manifest := ManifestFile{
+		Path:              location,
+		Content:           manifestContentData,
+		SequenceNumber:    sequenceNumber,
+		MinSequenceNumber: sequenceNumber,
+		AddedSnapshotID:   snapshotID,
+		AddedFilesCount:   len(files),
+	}
+	content := "data"
+	records := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		if file.Content != contentData {
+			manifest.Content = manifestContentDeletes
+			content = "deletes"
+		}
+		var equalityIDs interface{}
+		if len(file.EqualityIDs) > 0 {
+			ids := make([]interface{}, 0, len(file.EqualityIDs))
+			for _, id := range file.EqualityIDs {
+				ids = append(ids, int32(id))
+			}
+			equalityIDs = goavro.Union("array", ids)
+		}
+		records = append(records, map[string]interface{}{
+			"status":               int32(entryStatusAdded),
+			"snapshot_id":          goavro.Union("long", snapshotID),
+			"sequence_number":      goavro.Union("long", sequenceNumber),
+			"file_sequence_number": goavro.Union("long", sequenceNumber),
+			"data_file": map[string]interface{}{
+				"content":            int32(file.Content),
+				"file_path":          file.Path,
+				"file_format":        "PARQUET",
+				"partition":          map[string]interface{}{},
+				"record_count":       file.RecordCount,
+				"file_size_in_bytes": file.SizeInBytes,
+				"equality_ids":       equalityIDs,
+			},
+		})
+		manifest.AddedRowsCount += file.RecordCount
+	}
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return manifest, errors.Trace(err)
+	}
+	data, err := writeAvro(manifestEntrySchema, map[string]string{
+		"schema":            string(schemaJSON),
+		"schema-id":         fmt.Sprint(schema.SchemaID),
+		"partition-spec":    "[]",
+		"partition-spec-id": "0",
+		"format-version":    "2",
+		"content":           content,
+	}, records)
+	if err != nil {
+		return manifest, errors.Trace(err)
+	}
+	if err = extStorage.WriteFile(ctx, relPath, data); err != nil {
+		return manifest, errors.Trace(err)
+	}
+	manifest.Length = int64(len(data))
+	return manifest, nil
+}
+
+// writeManifestList writes the manifests of the snapshot into a manifest list.
+func writeManifestList(ctx context.Context, extStorage storage.ExternalStorage, relPath string,
+	snapshot *Snapshot, manifests []ManifestFile,
+) error {
+	records := make([]interface{}, 0, len(manifests))
+	for _, m := range manifests {
+		records = append(records, map[string]interface{}{
+			"manifest_path":        m.Path,
+			"manifest_length":      m.Length,
+			"partition_spec_id":    int32(0),
+			"content":              int32(m.Content),
+			"sequence_number":      m.SequenceNumber,
+			"min_sequence_number":  m.MinSequenceNumber,
+			"added_snapshot_id":    m.AddedSnapshotID,
+			"added_files_count":    int32(m.AddedFilesCount),
+			"existing_files_count": int32(m.ExistingFilesCount),
+			"deleted_files_count":  int32(m.DeletedFilesCount),
+			"added_rows_count":     m.AddedRowsCount,
+			"existing_rows_count":  m.ExistingRowsCount,
+			"deleted_rows_count":   m.DeletedRowsCount,
+		})
+	}
+	parentSnapshotID := "null"
+	if snapshot.ParentSnapshotID != nil {
+		parentSnapshotID = fmt.Sprint(*snapshot.ParentSnapshotID)
+	}
+	data, err := writeAvro(manifestFileSchema, map[string]string{
+		"snapshot-id":        fmt.Sprint(snapshot.SnapshotID),
+		"parent-snapshot-id": parentSnapshotID,
+		"sequence-number":    fmt.Sprint(snapshot.SequenceNumber),
+		"format-version":     "2",
+	}, records)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, relPath, data))
+}
+
+// readManifestList reads the manifests in the manifest list.
+func readManifestList(ctx context.Context, extStorage storage.ExternalStorage, relPath string) ([]ManifestFile, error) {
+	data, err := extStorage.ReadFile(ctx, relPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reader, err := goavro.NewOCFReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	manifests := make([]ManifestFile, 0)
+	for reader.Scan() {
+		datum, err := reader.Read()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		record, ok := datum.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("unexpected manifest list record %v", datum)
+		}
+		manifests = append(manifests, ManifestFile{
+			Path:               record["manifest_path"].(string),
+			Length:             record["manifest_length"].(int64),
+			Content:            int(record["content"].(int32)),
+			SequenceNumber:     record["sequence_number"].(int64),
+			MinSequenceNumber:  record["min_sequence_number"].(int64),
+			AddedSnapshotID:    record["added_snapshot_id"].(int64),
+			AddedFilesCount:    int(record["added_files_count"].(int32)),
+			ExistingFilesCount: int(record["existing_files_count"].(int32)),
+			DeletedFilesCount:  int(record["deleted_files_count"].(int32)),
+			AddedRowsCount:     record["added_rows_count"].(int64),
+			ExistingRowsCount:  record["existing_rows_count"].(int64),
+			DeletedRowsCount:   record["deleted_rows_count"].(int64),
+		})
+	}
+	if err = reader.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return manifests, nil
+}
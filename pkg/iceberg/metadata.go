@@ -0,0 +1,331 @@
+package iceberg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// The table metadata of Iceberg format version 2.
+// Refer to: https://iceberg.apache.org/spec/#table-metadata-fields
+
+type NestedField struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Type     string `json:"type"`
+}
+
+type Schema struct {
+	Type     string `json:"type"`
+	SchemaID int    `json:"schema-id"`
+	// IdentifierFieldIDs are the field ids of the primary key
+	IdentifierFieldIDs []int         `json:"identifier-field-ids,omitempty"`
+	Fields             []NestedField `json:"fields"`
+}
+
+// findField returns the index of the field with the name, -1 if not found.
+func (s *Schema) findField(name string) int {
+	for i, field := range s.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type PartitionSpec struct {
+	SpecID int           `json:"spec-id"`
+	Fields []interface{} `json:"fields"`
+}
+
+type SortOrder struct {
+	OrderID int           `json:"order-id"`
+	Fields  []interface{} `json:"fields"`
+}
+
+type Snapshot struct {
+	SnapshotID       int64             `json:"snapshot-id"`
+	ParentSnapshotID *int64            `json:"parent-snapshot-id,omitempty"`
+	SequenceNumber   int64             `json:"sequence-number"`
+	TimestampMs      int64             `json:"timestamp-ms"`
+	ManifestList     string            `json:"manifest-list"`
+	Summary          map[string]string `json:"summary"`
+	SchemaID         int               `json:"schema-id"`
+}
+
+type SnapshotLogEntry struct {
+	TimestampMs int64 `json:"timestamp-ms"`
+	SnapshotID  int64 `json:"snapshot-id"`
+}
+
+type MetadataLogEntry struct {
+	TimestampMs  int64  `json:"timestamp-ms"`
+	MetadataFile string `json:"metadata-file"`
+}
+
+type SnapshotRef struct {
+	SnapshotID int64  `json:"snapshot-id"`
+	Type       string `json:"type"`
+}
+
+type TableMetadata struct {
+	FormatVersion      int                    `json:"format-version"`
+	TableUUID          string                 `json:"table-uuid"`
+	Location           string                 `json:"location"`
+	LastSequenceNumber int64                  `json:"last-sequence-number"`
+	LastUpdatedMs      int64                  `json:"last-updated-ms"`
+	LastColumnID       int                    `json:"last-column-id"`
+	CurrentSchemaID    int                    `json:"current-schema-id"`
+	Schemas            []Schema               `json:"schemas"`
+	DefaultSpecID      int                    `json:"default-spec-id"`
+	PartitionSpecs     []PartitionSpec        `json:"partition-specs"`
+	LastPartitionID    int                    `json:"last-partition-id"`
+	DefaultSortOrderID int                    `json:"default-sort-order-id"`
+	SortOrders         []SortOrder            `json:"sort-orders"`
+	Properties         map[string]string      `json:"properties"`
+	CurrentSnapshotID  int64                  `json:"current-snapshot-id"`
+	Refs               map[string]SnapshotRef `json:"refs"`
+	Snapshots          []Snapshot             `json:"snapshots"`
+	SnapshotLog        []SnapshotLogEntry     `json:"snapshot-log"`
+	MetadataLog        []MetadataLogEntry     `json:"metadata-log"`
+}
+
+// noSnapshotID is the current snapshot id of a table without any snapshot
+const noSnapshotID = -1
+
+// maxMetadataLogEntries is the number of previous metadata files kept in the metadata log,
+// the older metadata files are deleted.
+const maxMetadataLogEntries = 100
+
+func newTableMetadata(location string, schema Schema, lastColumnID int) *TableMetadata {
+	return &TableMetadata{
+		FormatVersion:      2,
+		TableUUID:          uuid.NewString(),
+		Location:           location,
+		LastSequenceNumber: 0,
+		LastUpdatedMs:      time.Now().UnixMilli(),
+		LastColumnID:       lastColumnID,
+		CurrentSchemaID:    schema.SchemaID,
+		Schemas:            []Schema{schema},
+		DefaultSpecID:      0,
+		PartitionSpecs:     []PartitionSpec{{SpecID: 0, Fields: []interface{}{}}},
+		LastPartitionID:    999,
+		DefaultSortOrderID: 0,
+		SortOrders:         []SortOrder{{OrderID: 0, Fields: []interface{}{}}},
+		Properties:         map[string]string{"write.format.default": "parquet", "write.delete.mode": "merge-on-read"},
+		CurrentSnapshotID:  noSnapshotID,
+		Refs:               map[string]SnapshotRef{},
+		Snapshots:          []Snapshot{},
+		SnapshotLog:        []SnapshotLogEntry{},
+		MetadataLog:        []MetadataLogEntry{},
+	}
+}
+
+func (m *TableMetadata) currentSchema() *Schema {
+	for i := range m.Schemas {
+		if m.Schemas[i].SchemaID == m.CurrentSchemaID {
+			return &m.Schemas[i]
+		}
+	}
+	return nil
+}
+
+func (m *TableMetadata) currentSnapshot() *Snapshot {
+	for i := range m.Snapshots {
+		if m.Snapshots[i].SnapshotID == m.CurrentSnapshotID {
+			return &m.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// addSchema adds the schema and makes it the current schema.
+func (m *TableMetadata) addSchema(schema Schema, lastColumnID int) {
+	maxSchemaID := 0
+	for _, s := range m.Schemas {
+		if s.SchemaID > maxSchemaID {
+			maxSchemaID = s.SchemaID
+		}
+	}
+	schema.SchemaID = maxSchemaID + 1
+	m.Schemas = append(m.Schemas, schema)
+	m.CurrentSchemaID = schema.SchemaID
+	if lastColumnID > m.LastColumnID {
+		m.LastColumnID = lastColumnID
+	}
+	m.LastUpdatedMs = time.Now().UnixMilli()
+}
+
+// addSnapshot adds the snapshot and makes it the current snapshot of the main branch.
+func (m *TableMetadata) addSnapshot(snapshot Snapshot) {
+	m.Snapshots = append(m.Snapshots, snapshot)
+	m.SnapshotLog = append(m.SnapshotLog, SnapshotLogEntry{TimestampMs: snapshot.TimestampMs, SnapshotID: snapshot.SnapshotID})
+	m.CurrentSnapshotID = snapshot.SnapshotID
+	m.Refs["main"] = SnapshotRef{SnapshotID: snapshot.SnapshotID, Type: "branch"}
+	m.LastSequenceNumber = snapshot.SequenceNumber
+	m.LastUpdatedMs = snapshot.TimestampMs
+}
+
+// expireSnapshots removes the snapshots of the increment files whose max commit ts is less than commitTs,
+// and the snapshots without commit ts committed before them, e.g. the snapshots of loading the snapshot
+// data. The current snapshot is always kept. It returns the expired snapshots.
+func (m *TableMetadata) expireSnapshots(commitTs uint64) []Snapshot {
+	checkCommitTs := func(snapshot Snapshot) (covered bool, hasCommitTs bool) {
+		ts, err := strconv.ParseUint(snapshot.Summary[summaryCommitTs], 10, 64)
+		if err != nil {
+			return false, false
+		}
+		return ts < commitTs, true
+	}
+	maxExpiredSeq := int64(-1)
+	for _, snapshot := range m.Snapshots {
+		if covered, _ := checkCommitTs(snapshot); covered && snapshot.SnapshotID != m.CurrentSnapshotID && snapshot.SequenceNumber > maxExpiredSeq {
+			maxExpiredSeq = snapshot.SequenceNumber
+		}
+	}
+	kept := make([]Snapshot, 0, len(m.Snapshots))
+	expired := make([]Snapshot, 0)
+	keptIDs := make(map[int64]bool, len(m.Snapshots))
+	for _, snapshot := range m.Snapshots {
+		covered, hasCommitTs := checkCommitTs(snapshot)
+		if snapshot.SnapshotID != m.CurrentSnapshotID && (covered || !hasCommitTs && snapshot.SequenceNumber < maxExpiredSeq) {
+			expired = append(expired, snapshot)
+			continue
+		}
+		kept = append(kept, snapshot)
+		keptIDs[snapshot.SnapshotID] = true
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	m.Snapshots = kept
+	snapshotLog := make([]SnapshotLogEntry, 0, len(m.SnapshotLog))
+	for _, entry := range m.SnapshotLog {
+		if keptIDs[entry.SnapshotID] {
+			snapshotLog = append(snapshotLog, entry)
+		}
+	}
+	m.SnapshotLog = snapshotLog
+	m.LastUpdatedMs = time.Now().UnixMilli()
+	return expired
+}
+
+// The tables are maintained in the layout of the Hadoop catalog, so that they can be
+// read by the query engines without any catalog service:
+//
+//	<table>/metadata/version-hint.text     the version of the current metadata file
+//	<table>/metadata/v<version>.metadata.json
+//	<table>/metadata/snap-*.avro           manifest lists
+//	<table>/metadata/*-m0.avro             manifests
+//	<table>/data/*.parquet                 data files and equality delete files
+const versionHintFile = "version-hint.text"
+
+func metadataDir(table string) string {
+	return fmt.Sprintf("%s/metadata", table)
+}
+
+func metadataFile(table string, version int) string {
+	return fmt.Sprintf("%s/v%d.metadata.json", metadataDir(table), version)
+}
+
+// loadTableMetadata loads the current metadata of the table, returns nil if the table does not exist.
+func loadTableMetadata(ctx context.Context, extStorage storage.ExternalStorage, table string) (*TableMetadata, int, error) {
+	hintPath := fmt.Sprintf("%s/%s", metadataDir(table), versionHintFile)
+	exist, err := extStorage.FileExists(ctx, hintPath)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if !exist {
+		return nil, 0, nil
+	}
+	hint, err := extStorage.ReadFile(ctx, hintPath)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(hint)))
+	if err != nil {
+		return nil, 0, errors.Annotatef(err, "invalid version hint of table %s", table)
+	}
+	content, err := extStorage.ReadFile(ctx, metadataFile(table, version))
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	var metadata TableMetadata
+	if err = json.Unmarshal(content, &metadata); err != nil {
+		return nil, 0, errors.Annotatef(err, "failed to decode metadata of table %s", table)
+	}
+	return &metadata, version, nil
+}
+
+// commitTableMetadata writes the metadata as the next version and points the version hint to it.
+// There is only one writer of each table, so the commit is not protected by a lock.
+func commitTableMetadata(ctx context.Context, extStorage storage.ExternalStorage, table string, metadata *TableMetadata, prevVersion int) (int, error) {
+	version := prevVersion + 1
+	if prevVersion > 0 {
+		metadata.MetadataLog = append(metadata.MetadataLog, MetadataLogEntry{
+			TimestampMs:  time.Now().UnixMilli(),
+			MetadataFile: fmt.Sprintf("%s/metadata/v%d.metadata.json", metadata.Location, prevVersion),
+		})
+	}
+	var droppedLog []MetadataLogEntry
+	if len(metadata.MetadataLog) > maxMetadataLogEntries {
+		droppedLog = metadata.MetadataLog[:len(metadata.MetadataLog)-maxMetadataLogEntries]
+		metadata.MetadataLog = metadata.MetadataLog[len(droppedLog):]
+	}
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if err = extStorage.WriteFile(ctx, metadataFile(table, version), content); err != nil {
+		return 0, errors.Annotate(err, "failed to write metadata file")
+	}
+	if err = extStorage.WriteFile(ctx, fmt.Sprintf("%s/%s", metadataDir(table), versionHintFile), []byte(strconv.Itoa(version))); err != nil {
+		return 0, errors.Annotate(err, "failed to write version hint")
+	}
+	// the metadata files dropped from the metadata log are deleted after the new version is committed
+	for _, entry := range droppedLog {
+		if err = deleteTableFile(ctx, extStorage, table, metadata.Location, entry.MetadataFile); err != nil {
+			return 0, errors.Annotate(err, "failed to delete old metadata file")
+		}
+	}
+	return version, nil
+}
+
+// deleteTableFile deletes the file of the table by its location, which is written in the metadata.
+func deleteTableFile(ctx context.Context, extStorage storage.ExternalStorage, table, tableLocation, fileLocation string) error {
+	if !strings.HasPrefix(fileLocation, tableLocation+"/") {
+		return errors.Errorf("file %s is not in the location %s of table %s", fileLocation, tableLocation, table)
+	}
+	return errors.Trace(extStorage.DeleteFile(ctx, path.Join(table, strings.TrimPrefix(fileLocation, tableLocation+"/"))))
+}
+
+// dropTable deletes all the files of the table.
+func dropTable(ctx context.Context, extStorage storage.ExternalStorage, table string) error {
+	// an empty table name would walk the whole warehouse
+	if len(table) == 0 {
+		return errors.New("table name is empty")
+	}
+	files := make([]string, 0)
+	err := extStorage.WalkDir(ctx, &storage.WalkOption{SubDir: table}, func(path string, _ int64) error {
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, file := range files {
+		if err = extStorage.DeleteFile(ctx, file); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
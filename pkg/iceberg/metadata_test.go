@@ -0,0 +1,120 @@
+package iceberg
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropTable(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	extStorage, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	files := []string{"t1/metadata/v1.metadata.json", "t1/data/a.parquet", "t10/data/b.parquet", "t2/data/c.parquet"}
+	for _, file := range files {
+		require.NoError(t, extStorage.WriteFile(ctx, file, []byte("x")))
+	}
+	exists := func() []bool {
+		res := make([]bool, 0, len(files))
+		for _, file := range files {
+			exist, err := extStorage.FileExists(ctx, file)
+			require.NoError(t, err)
+			res = append(res, exist)
+		}
+		return res
+	}
+
+	require.Error(t, dropTable(ctx, extStorage, ""))
+	require.Equal(t, []bool{true, true, true, true}, exists())
+
+	// the tables of a dropped database are kept
+	uri, err := url.Parse("file://" + dir)
+	require.NoError(t, err)
+	ic, err := NewIcebergConnector(uri, uri)
+	require.NoError(t, err)
+	require.NoError(t, ic.ExecDDL(cloudstorage.TableDefinition{
+		Schema: "test",
+		Type:   timodel.ActionDropSchema,
+		Query:  "DROP DATABASE test",
+	}))
+	require.Equal(t, []bool{true, true, true, true}, exists())
+
+	require.NoError(t, dropTable(ctx, extStorage, "t1"))
+	require.Equal(t, []bool{false, false, true, true}, exists())
+}
+
+func TestExpireSnapshots(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	extStorage, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	location := "file://" + dir + "/t1"
+
+	metadata := newTableMetadata(location, Schema{Type: "struct"}, 0)
+	version, err := commitTableMetadata(ctx, extStorage, "t1", metadata, 0)
+	require.NoError(t, err)
+	// the snapshot data is appended first, then each increment file is committed as a snapshot
+	summaries := []map[string]string{
+		{"operation": "append"},
+		{"operation": "overwrite", summaryCommitTs: "100"},
+		{"operation": "overwrite", summaryCommitTs: "200"},
+		{"operation": "overwrite", summaryCommitTs: "300"},
+	}
+	for i, summary := range summaries {
+		manifestList := fmt.Sprintf("metadata/snap-%d.avro", i)
+		require.NoError(t, extStorage.WriteFile(ctx, "t1/"+manifestList, []byte("x")))
+		metadata.addSnapshot(Snapshot{
+			SnapshotID:     int64(i + 1),
+			SequenceNumber: int64(i + 1),
+			ManifestList:   location + "/" + manifestList,
+			Summary:        summary,
+		})
+		version, err = commitTableMetadata(ctx, extStorage, "t1", metadata, version)
+		require.NoError(t, err)
+	}
+
+	ic := &IcebergConnector{warehouse: extStorage}
+	require.NoError(t, ic.TrimApplyLog("test", "t1", 100))
+	metadata, version, err = loadTableMetadata(ctx, extStorage, "t1")
+	require.NoError(t, err)
+	require.Len(t, metadata.Snapshots, 4)
+
+	require.NoError(t, ic.TrimApplyLog("test", "t1", 150))
+	metadata, version, err = loadTableMetadata(ctx, extStorage, "t1")
+	require.NoError(t, err)
+	require.Len(t, metadata.Snapshots, 2)
+	require.Equal(t, []int64{3, 4}, []int64{metadata.Snapshots[0].SnapshotID, metadata.Snapshots[1].SnapshotID})
+	require.Equal(t, []SnapshotLogEntry{{SnapshotID: 3}, {SnapshotID: 4}}, metadata.SnapshotLog)
+	for i, expected := range []bool{false, false, true, true} {
+		exist, err := extStorage.FileExists(ctx, fmt.Sprintf("t1/metadata/snap-%d.avro", i))
+		require.NoError(t, err)
+		require.Equal(t, expected, exist)
+	}
+
+	// the current snapshot is kept
+	require.NoError(t, ic.TrimApplyLog("test", "t1", 400))
+	metadata, version, err = loadTableMetadata(ctx, extStorage, "t1")
+	require.NoError(t, err)
+	require.Len(t, metadata.Snapshots, 1)
+	require.Equal(t, int64(4), metadata.CurrentSnapshotID)
+
+	// the metadata files dropped from the metadata log are deleted
+	for i := 0; i < maxMetadataLogEntries; i++ {
+		version, err = commitTableMetadata(ctx, extStorage, "t1", metadata, version)
+		require.NoError(t, err)
+	}
+	require.Len(t, metadata.MetadataLog, maxMetadataLogEntries)
+	require.Equal(t, fmt.Sprintf("%s/metadata/v%d.metadata.json", location, version-maxMetadataLogEntries), metadata.MetadataLog[0].MetadataFile)
+	for v, expected := range map[int]bool{1: false, version - maxMetadataLogEntries - 1: false, version - maxMetadataLogEntries: true, version: true} {
+		exist, err := extStorage.FileExists(ctx, metadataFile("t1", v))
+		require.NoError(t, err)
+		require.Equal(t, expected, exist, "version %d", v)
+	}
+}
@@ -0,0 +1,95 @@
+package iceberg
+
+import (
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"golang.org/x/exp/slices"
+)
+
+// NewSchema returns the Iceberg schema of the columns and the last assigned field id.
+// The field ids are assigned from 1 in the order of the columns, the primary key columns
+// are required and used as the identifier fields.
+func NewSchema(columns []cloudstorage.TableCol) (Schema, int, error) {
+	schema := Schema{Type: "struct", SchemaID: 0, Fields: make([]NestedField, 0, len(columns))}
+	for i, column := range columns {
+		tp, err := GetIcebergType(column)
+		if err != nil {
+			return schema, 0, errors.Trace(err)
+		}
+		field := NestedField{ID: i + 1, Name: column.Name, Required: column.IsPK == "true", Type: tp}
+		if field.Required {
+			schema.IdentifierFieldIDs = append(schema.IdentifierFieldIDs, field.ID)
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	return schema, len(columns), nil
+}
+
+// GenSchemaViaColumnsDiff returns the schema evolved by the column changes and the last assigned field id.
+// Refer to: https://iceberg.apache.org/spec/#schema-evolution
+func GenSchemaViaColumnsDiff(prev Schema, lastColumnID int, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) (Schema, int, error) {
+	schema := Schema{
+		Type:               "struct",
+		SchemaID:           prev.SchemaID,
+		IdentifierFieldIDs: slices.Clone(prev.IdentifierFieldIDs),
+		Fields:             slices.Clone(prev.Fields),
+	}
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return schema, 0, errors.Trace(err)
+	}
+	for _, item := range columnDiff {
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			tp, err := GetIcebergType(*item.After)
+			if err != nil {
+				return schema, 0, errors.Trace(err)
+			}
+			if schema.findField(item.After.Name) >= 0 {
+				return schema, 0, errors.Errorf("column %s already exists", item.After.Name)
+			}
+			// Iceberg does not allow adding a required column, since the old data files do not contain it
+			lastColumnID++
+			schema.Fields = append(schema.Fields, NestedField{ID: lastColumnID, Name: item.After.Name, Required: false, Type: tp})
+		case tidbsql.DROP_COLUMN:
+			idx := schema.findField(item.Before.Name)
+			if idx < 0 {
+				return schema, 0, errors.Errorf("column %s not found", item.Before.Name)
+			}
+			if slices.Contains(schema.IdentifierFieldIDs, schema.Fields[idx].ID) {
+				return schema, 0, errors.Errorf("can not drop primary key column %s", item.Before.Name)
+			}
+			schema.Fields = slices.Delete(schema.Fields, idx, idx+1)
+		case tidbsql.MODIFY_COLUMN:
+			idx := schema.findField(item.Before.Name)
+			if idx < 0 {
+				return schema, 0, errors.Errorf("column %s not found", item.Before.Name)
+			}
+			tp, err := GetIcebergType(*item.After)
+			if err != nil {
+				return schema, 0, errors.Trace(err)
+			}
+			if !canPromote(schema.Fields[idx].Type, tp) {
+				return schema, 0, errors.Errorf("Received modify column ddl, changing column %s from %s to %s is not supported by Iceberg",
+					item.After.Name, schema.Fields[idx].Type, tp)
+			}
+			schema.Fields[idx].Type = tp
+		case tidbsql.RENAME_COLUMN:
+			idx := schema.findField(item.Before.Name)
+			if idx < 0 {
+				return schema, 0, errors.Errorf("column %s not found", item.Before.Name)
+			}
+			schema.Fields[idx].Name = item.After.Name
+		default:
+			// UNCHANGE
+		}
+	}
+
+	return schema, lastColumnID, nil
+}
+
+// sameFields returns whether the two schemas have the same fields.
+func sameFields(lhs, rhs Schema) bool {
+	return slices.Equal(lhs.Fields, rhs.Fields)
+}
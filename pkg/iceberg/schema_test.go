@@ -0,0 +1,64 @@
+package iceberg
+
+import (
+	"testing"
+
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenSchemaViaColumnsDiff(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "int", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "name", Tp: "varchar", Precision: "10"},
+		{ID: "3", Name: "age", Tp: "int"},
+		{ID: "4", Name: "price", Tp: "decimal", Precision: "10", Scale: "2"},
+	}
+	prevSchema, lastColumnID, err := NewSchema(prevColumns)
+	require.NoError(t, err)
+	require.Equal(t, 4, lastColumnID)
+	require.Equal(t, []int{1}, prevSchema.IdentifierFieldIDs)
+
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "full_name", Tp: "varchar", Precision: "10"},
+			{ID: "5", Name: "age", Tp: "bigint"},
+			{ID: "4", Name: "price", Tp: "decimal", Precision: "20", Scale: "2"},
+			{ID: "6", Name: "birth", Tp: "datetime"},
+		},
+	}
+	schema, lastColumnID, err := GenSchemaViaColumnsDiff(prevSchema, lastColumnID, prevColumns, curTableDef)
+	require.NoError(t, err)
+	require.Equal(t, 5, lastColumnID)
+	require.Equal(t, []NestedField{
+		{ID: 1, Name: "id", Required: true, Type: "int"},
+		{ID: 2, Name: "full_name", Required: false, Type: "string"},
+		{ID: 3, Name: "age", Required: false, Type: "long"},
+		{ID: 4, Name: "price", Required: false, Type: "decimal(20,2)"},
+		{ID: 5, Name: "birth", Required: false, Type: "timestamp"},
+	}, schema.Fields)
+
+	// changing the type to string is not a valid type promotion
+	curTableDef.Columns[2] = cloudstorage.TableCol{ID: "7", Name: "age", Tp: "varchar", Precision: "10"}
+	_, _, err = GenSchemaViaColumnsDiff(prevSchema, 4, prevColumns, curTableDef)
+	require.Error(t, err)
+}
+
+func TestDecimalToFixed(t *testing.T) {
+	require.Equal(t, 5, decimalBytes(10))
+	require.Equal(t, 16, decimalBytes(38))
+
+	v, err := decimalToFixed("1.5", 10, 2)
+	require.NoError(t, err)
+	require.Equal(t, string([]byte{0, 0, 0, 0, 150}), v)
+
+	v, err = decimalToFixed("-1.50", 10, 2)
+	require.NoError(t, err)
+	require.Equal(t, string([]byte{0xff, 0xff, 0xff, 0xff, 0x6a}), v)
+
+	_, err = decimalToFixed("1.505", 10, 2)
+	require.Error(t, err)
+}
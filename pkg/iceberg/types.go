@@ -0,0 +1,224 @@
+package iceberg
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// TiDB2IcebergTypeMap is a map from TiDB type to Iceberg primitive type.
+var TiDB2IcebergTypeMap map[string]string = map[string]string{
+	"text":       "string",
+	"tinytext":   "string",
+	"mediumtext": "string",
+	"longtext":   "string",
+	"blob":       "binary",
+	"tinyblob":   "binary",
+	"mediumblob": "binary",
+	"longblob":   "binary",
+	"varchar":    "string",
+	"char":       "string",
+	"binary":     "binary",
+	"varbinary":  "binary",
+	"int":        "int",
+	"mediumint":  "int",
+	"tinyint":    "int",
+	"smallint":   "int",
+	"bigint":     "long",
+	"float":      "float",
+	"double":     "double",
+	"decimal":    "decimal",
+	"numeric":    "decimal",
+	"bool":       "boolean",
+	"boolean":    "boolean",
+	"date":       "date",
+	"datetime":   "timestamp",
+	"timestamp":  "timestamp",
+	// The range of TiDB TIME is -838:59:59 to 838:59:59, which exceeds Iceberg time.
+	"time": "string",
+}
+
+// GetIcebergType returns the type of the column in Iceberg, e.g. "decimal(10,2)"
+// Refer to: https://iceberg.apache.org/spec/#primitive-types
+func GetIcebergType(column cloudstorage.TableCol) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	switch tp {
+	case "decimal", "numeric":
+		precision, err := strconv.Atoi(column.Precision)
+		if err != nil {
+			return "", errors.Annotatef(err, "invalid precision of column %s", column.Name)
+		}
+		// The max precision of Iceberg decimal is 38, keep the larger ones as string to avoid precision loss
+		if precision > 38 {
+			return "string", nil
+		}
+		scale := column.Scale
+		if scale == "" {
+			scale = "0"
+		}
+		return fmt.Sprintf("decimal(%d,%s)", precision, scale), nil
+	default:
+		if icebergType, ok := TiDB2IcebergTypeMap[tp]; ok {
+			return icebergType, nil
+		}
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
+
+// parseDecimalType parses the precision and scale of the decimal type, e.g. "decimal(10,2)"
+func parseDecimalType(icebergType string) (precision int, scale int, ok bool) {
+	if _, err := fmt.Sscanf(icebergType, "decimal(%d,%d)", &precision, &scale); err != nil {
+		return 0, 0, false
+	}
+	return precision, scale, true
+}
+
+// decimalBytes returns the minimum number of bytes to store the unscaled value of the decimal.
+func decimalBytes(precision int) int {
+	maxUnscaled := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	for n := 1; ; n++ {
+		// the max positive value of n bytes two's complement is 2^(8n-1) - 1
+		if new(big.Int).Lsh(big.NewInt(1), uint(8*n-1)).Cmp(maxUnscaled) >= 0 {
+			return n
+		}
+	}
+}
+
+// canPromote returns whether the type can be promoted in place.
+// Refer to: https://iceberg.apache.org/spec/#schema-evolution
+func canPromote(from, to string) bool {
+	if from == to {
+		return true
+	}
+	if from == "int" && to == "long" || from == "float" && to == "double" {
+		return true
+	}
+	fromP, fromS, ok1 := parseDecimalType(from)
+	toP, toS, ok2 := parseDecimalType(to)
+	return ok1 && ok2 && fromS == toS && toP >= fromP
+}
+
+// parquetColumnTag returns the schema of the field in the parquet file, refer to:
+// https://iceberg.apache.org/spec/#parquet
+func parquetColumnTag(field NestedField) (string, error) {
+	repetition := "OPTIONAL"
+	if field.Required {
+		repetition = "REQUIRED"
+	}
+	tag := fmt.Sprintf("name=%s, fieldid=%d, repetitiontype=%s", field.Name, field.ID, repetition)
+	switch field.Type {
+	case "boolean":
+		return tag + ", type=BOOLEAN", nil
+	case "int":
+		return tag + ", type=INT32", nil
+	case "long":
+		return tag + ", type=INT64", nil
+	case "float":
+		return tag + ", type=FLOAT", nil
+	case "double":
+		return tag + ", type=DOUBLE", nil
+	case "date":
+		return tag + ", type=INT32, convertedtype=DATE", nil
+	case "timestamp":
+		return tag + ", type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=false, logicaltype.unit=MICROS", nil
+	case "string":
+		return tag + ", type=BYTE_ARRAY, convertedtype=UTF8", nil
+	case "binary":
+		return tag + ", type=BYTE_ARRAY", nil
+	}
+	if precision, scale, ok := parseDecimalType(field.Type); ok {
+		return fmt.Sprintf("%s, type=FIXED_LEN_BYTE_ARRAY, convertedtype=DECIMAL, precision=%d, scale=%d, length=%d",
+			tag, precision, scale, decimalBytes(precision)), nil
+	}
+	return "", errors.Errorf("Unsupported iceberg type: %s", field.Type)
+}
+
+const (
+	dateLayout     = "2006-01-02"
+	datetimeLayout = "2006-01-02 15:04:05"
+)
+
+// toParquetValue converts the CSV field to the value written into the parquet file.
+// The binary values are written as is by dumpling, while encoded in base64 by TiCDC.
+func toParquetValue(field *string, icebergType string, base64Binary bool) (interface{}, error) {
+	if field == nil {
+		return nil, nil
+	}
+	s := *field
+	switch icebergType {
+	case "boolean":
+		return s == "1" || strings.EqualFold(s, "true"), nil
+	case "int":
+		v, err := strconv.ParseInt(s, 10, 32)
+		return int32(v), errors.Trace(err)
+	case "long":
+		v, err := strconv.ParseInt(s, 10, 64)
+		return v, errors.Trace(err)
+	case "float":
+		v, err := strconv.ParseFloat(s, 32)
+		return float32(v), errors.Trace(err)
+	case "double":
+		v, err := strconv.ParseFloat(s, 64)
+		return v, errors.Trace(err)
+	case "date":
+		t, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// days from the unix epoch
+		return int32(t.Unix() / 86400), nil
+	case "timestamp":
+		// the fractional seconds are accepted even if the layout does not contain them
+		t, err := time.Parse(datetimeLayout, s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// microseconds from the unix epoch
+		return t.UnixMicro(), nil
+	case "string":
+		return s, nil
+	case "binary":
+		if !base64Binary {
+			return s, nil
+		}
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return string(v), nil
+	}
+	if precision, scale, ok := parseDecimalType(icebergType); ok {
+		return decimalToFixed(s, precision, scale)
+	}
+	return nil, errors.Errorf("Unsupported iceberg type: %s", icebergType)
+}
+
+// decimalToFixed converts the decimal string to the big-endian two's complement of the unscaled value.
+func decimalToFixed(s string, precision, scale int) (string, error) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if len(fracPart) > scale {
+		return "", errors.Errorf("the scale of decimal %s exceeds %d", s, scale)
+	}
+	fracPart += strings.Repeat("0", scale-len(fracPart))
+	unscaled, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return "", errors.Errorf("invalid decimal %s", s)
+	}
+	n := decimalBytes(precision)
+	if neg && unscaled.Sign() != 0 {
+		// two's complement: 2^(8n) - |v|
+		unscaled.Sub(new(big.Int).Lsh(big.NewInt(1), uint(8*n)), unscaled)
+	}
+	if unscaled.BitLen() > 8*n {
+		return "", errors.Errorf("decimal %s overflows precision %d", s, precision)
+	}
+	return string(unscaled.FillBytes(make([]byte, n))), nil
+}
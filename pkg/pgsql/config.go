@@ -0,0 +1,37 @@
+package pgsql
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+)
+
+type PostgresConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Pass     string
+	Database string
+	Schema   string
+	SSLMode  string
+}
+
+// Open a connection to PostgreSQL.
+func (config *PostgresConfig) OpenDB() (*sql.DB, error) {
+	var connStr = fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		config.Host, config.Port, config.User, config.Pass, config.Database, config.SSLMode)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open PostgreSQL connection")
+	}
+	// make sure the connection is available
+	if err = db.Ping(); err != nil {
+		return nil, errors.Annotate(err, "Failed to ping PostgreSQL")
+	}
+	log.Info("PostgreSQL connection established")
+	return db, nil
+}
@@ -0,0 +1,239 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	putil "github.com/pingcap/tiflow/pkg/util"
+	"go.uber.org/zap"
+)
+
+// A Wrapper of PostgreSQL connection.
+// It implements the coreinterfaces.Connector interface.
+type PostgresConnector struct {
+	// db is the connection to PostgreSQL.
+	db         *sql.DB
+	schemaName string
+	// stageName is the name of the temporary staging table of the increment files.
+	stageName string
+	// storageURI is the location of the files to load,
+	// PostgreSQL can not read the storage, so the files are read by tidb2dw and sent through COPY FROM STDIN.
+	storageURI *url.URL
+
+	columns []cloudstorage.TableCol
+}
+
+func NewPostgresConnector(db *sql.DB, schemaName, stageName string, storageURI *url.URL) (*PostgresConnector, error) {
+	if err := CreateSchema(db, schemaName); err != nil {
+		return nil, errors.Annotate(err, "Failed to create schema")
+	}
+	if err := CreateApplyLogTable(db, schemaName); err != nil {
+		return nil, errors.Annotate(err, "Failed to create apply log table")
+	}
+
+	return &PostgresConnector{
+		db:         db,
+		schemaName: schemaName,
+		stageName:  stageName,
+		storageURI: storageURI,
+		columns:    nil,
+	}, nil
+}
+
+// openStorage opens the external storage of the given uri.
+func openStorage(ctx context.Context, uri *url.URL) (storage.ExternalStorage, error) {
+	extStorage, err := putil.GetExternalStorageFromURI(ctx, uri.String())
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open storage")
+	}
+	return extStorage, nil
+}
+
+func (pc *PostgresConnector) InitSchema(_ string, columns []cloudstorage.TableCol) error {
+	if len(pc.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	pc.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (pc *PostgresConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	if len(pc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	ddls, err := GenDDLViaColumnsDiff(pc.schemaName, pc.columns, tableDef)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(ddls) == 0 {
+		log.Info("No need to execute this DDL in PostgreSQL", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	// One DDL may be rewritten to multiple DDLs, PostgreSQL supports transactional DDL
+	tx, err := pc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, ddl := range ddls {
+		if _, err := tx.Exec(ddl); err != nil {
+			_ = tx.Rollback()
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	// update columns
+	pc.columns = tableDef.Columns
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+	return nil
+}
+
+func (pc *PostgresConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	createTableQuery, columns, err := GenCreateSchema(pc.schemaName, sourceDatabase, sourceTable, sourceTiDBConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in PostgreSQL", redact.Query("query", createTableQuery))
+	_, err = pc.db.Exec(createTableQuery)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The columns are needed to convert the CSV fields when loading snapshot
+	pc.columns = columns
+
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+func (pc *PostgresConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	if len(pc.columns) == 0 {
+		return errors.New("Columns not initialized, table schema should be copied before loading snapshot")
+	}
+	// filePrefix is relative to the root of the bucket
+	ctx := context.Background()
+	rootURI := *pc.storageURI
+	rootURI.Path = "/"
+	extStorage, err := openStorage(ctx, &rootURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	files := make([]string, 0)
+	err = extStorage.WalkDir(ctx, &storage.WalkOption{SubDir: path.Dir(filePrefix)}, func(filePath string, _ int64) error {
+		if strings.HasPrefix(filePath, filePrefix) && strings.HasSuffix(filePath, ".csv") {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sort.Strings(files)
+
+	// load all the files in one transaction, so that a failed load leaves nothing behind
+	tx, err := pc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var loadedRows int64
+	for _, file := range files {
+		reader, err := extStorage.Open(ctx, file)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Trace(err)
+		}
+		rows, err := CopySnapshotFile(tx, pc.schemaName, targetTable, pc.columns, reader)
+		reader.Close()
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Annotatef(err, "Failed to copy file %s", file)
+		}
+		loadedRows += rows
+		if onSnapshotLoadProgress != nil {
+			onSnapshotLoadProgress(loadedRows)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix), zap.Int("files", len(files)))
+	return nil
+}
+
+func (pc *PostgresConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(pc.db, pc.schemaName, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (pc *PostgresConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(pc.db, pc.schemaName, schema, table, commitTs))
+}
+
+func (pc *PostgresConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	ctx := context.Background()
+	extStorage, err := openStorage(ctx, uri)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reader, err := extStorage.Open(ctx, file.Path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer reader.Close()
+
+	// copy the file into the staging table, apply it and record it in the apply log atomically
+	tx, err := pc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = CopyIncrementFile(tx, pc.stageName, tableDef.Columns, reader); err != nil {
+		_ = tx.Rollback()
+		return errors.Annotate(err, "Failed to copy file into staging table")
+	}
+	deleteQuery, upsertQuery := GenApplyIncrement(pc.schemaName, tableDef, pc.stageName)
+	for _, query := range []string{deleteQuery, upsertQuery} {
+		if _, err = tx.Exec(query); err != nil {
+			_ = tx.Rollback()
+			return errors.Trace(err)
+		}
+		log.Debug("apply staging table into table", redact.Query("query", query))
+	}
+	if err = InsertApplyLog(tx, pc.schemaName, tableDef.Schema, tableDef.Table, file); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully apply file", zap.String("file", file.Path))
+	return nil
+}
+
+func (pc *PostgresConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
+	return NewPostgresConnector(pc.db, pc.schemaName, stageName, storageURI)
+}
+
+func (pc *PostgresConnector) Close() {
+	pc.db.Close()
+}
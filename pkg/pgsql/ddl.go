@@ -0,0 +1,145 @@
+package pgsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// tableName returns the quoted full qualified name of the table, e.g. `"schema"."table"`
+func tableName(schemaName, table string) string {
+	return fmt.Sprintf("%s.%s", pq.QuoteIdentifier(schemaName), pq.QuoteIdentifier(table))
+}
+
+func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
+	strs := make([]string, 0, 3)
+	name := pq.QuoteIdentifier(diff.After.Name)
+	if diff.Before.Tp != diff.After.Tp || diff.Before.Precision != diff.After.Precision || diff.Before.Scale != diff.After.Scale {
+		tp, err := GetPostgresTypeString(*diff.After)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		strs = append(strs, fmt.Sprintf("ALTER COLUMN %s TYPE %s USING %s::%s", name, tp, name, tp))
+	}
+	if diff.Before.Default != diff.After.Default {
+		if diff.After.Default == nil {
+			strs = append(strs, fmt.Sprintf("ALTER COLUMN %s DROP DEFAULT", name))
+		} else {
+			strs = append(strs, fmt.Sprintf("ALTER COLUMN %s SET DEFAULT %s", name, getDefaultString(diff.After.Default)))
+		}
+	}
+	if diff.Before.Nullable != diff.After.Nullable {
+		if diff.After.Nullable == "true" {
+			strs = append(strs, fmt.Sprintf("ALTER COLUMN %s DROP NOT NULL", name))
+		} else {
+			strs = append(strs, fmt.Sprintf("ALTER COLUMN %s SET NOT NULL", name))
+		}
+	}
+	return strings.Join(strs, ", "), nil
+}
+
+func GenDDLViaColumnsDiff(schemaName string, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+	if curTableDef.Type == timodel.ActionTruncateTable {
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s;", tableName(schemaName, curTableDef.Table))}, nil
+	}
+	if curTableDef.Type == timodel.ActionDropTable {
+		return []string{fmt.Sprintf("DROP TABLE %s;", tableName(schemaName, curTableDef.Table))}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateTable {
+		// The table is empty when created in TiDB, so the table left in PostgreSQL is replaced
+		createTable, err := GenCreateTable(schemaName, curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{createTable}, nil
+	}
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", tableName(schemaName, oldTable.Name), pq.QuoteIdentifier(curTableDef.Table))}, nil
+	}
+	// The tables are created in the PostgreSQL schema of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
+	}
+
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ddls := make([]string, 0, len(columnDiff))
+	for _, item := range columnDiff {
+		ddl := ""
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			colStr, err := GetPostgresColumnString(*item.After)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddl = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", tableName(schemaName, curTableDef.Table), colStr)
+		case tidbsql.DROP_COLUMN:
+			ddl = fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", tableName(schemaName, curTableDef.Table), pq.QuoteIdentifier(item.Before.Name))
+		case tidbsql.MODIFY_COLUMN:
+			modifyStr, err := GetColumnModifyString(&item)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if modifyStr != "" {
+				ddl = fmt.Sprintf("ALTER TABLE %s %s", tableName(schemaName, curTableDef.Table), modifyStr)
+			}
+		case tidbsql.RENAME_COLUMN:
+			ddl = fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", tableName(schemaName, curTableDef.Table),
+				pq.QuoteIdentifier(item.Before.Name), pq.QuoteIdentifier(item.After.Name))
+		default:
+			// UNCHANGE
+		}
+		if ddl != "" {
+			ddl += ";"
+			ddls = append(ddls, ddl)
+		}
+	}
+
+	return ddls, nil
+}
+
+func getDefaultString(val interface{}) string {
+	str := fmt.Sprintf("%v", val)
+	if strings.HasPrefix(strings.ToUpper(str), "CURRENT_TIMESTAMP") {
+		// e.g. CURRENT_TIMESTAMP(3), which is also valid in PostgreSQL
+		return str
+	}
+	if _, err := strconv.ParseFloat(str, 64); err != nil {
+		return pq.QuoteLiteral(str)
+	}
+	return str
+}
+
+// GetPostgresColumnString returns a string describing the column in PostgreSQL, e.g.
+// `"id" INTEGER NOT NULL DEFAULT 0`
+// Refer to:
+// https://dev.mysql.com/doc/refman/8.0/en/data-types.html
+// https://www.postgresql.org/docs/current/datatype.html
+func GetPostgresColumnString(column cloudstorage.TableCol) (string, error) {
+	var sb strings.Builder
+	tp, err := GetPostgresTypeString(column)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sb.WriteString(fmt.Sprintf("%s %s", pq.QuoteIdentifier(column.Name), tp))
+	if column.Nullable == "false" {
+		sb.WriteString(" NOT NULL")
+	}
+	if column.Default != nil {
+		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column.Default)))
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,21 @@
+package pgsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/pgsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiffSchema(t *testing.T) {
+	for _, tableDef := range []cloudstorage.TableDefinition{
+		{Schema: "test_schema", Type: timodel.ActionCreateSchema, Query: "CREATE DATABASE test_schema"},
+		{Schema: "test_schema", Type: timodel.ActionDropSchema, Query: "DROP DATABASE test_schema"},
+	} {
+		ddls, err := pgsql.GenDDLViaColumnsDiff("public", nil, tableDef)
+		require.NoError(t, err)
+		require.Empty(t, ddls)
+	}
+}
@@ -0,0 +1,303 @@
+package pgsql
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/csvutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+const (
+	// The extra columns of the staging table to pick the latest change of each row
+	flagColumnName     = "tidb2dw_flag"
+	commitTsColumnName = "tidb2dw_commit_ts"
+	seqColumnName      = "tidb2dw_seq"
+)
+
+func CreateSchema(db *sql.DB, schemaName string) error {
+	_, err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pq.QuoteIdentifier(schemaName)))
+	return err
+}
+
+func GenCreateSchema(schemaName, sourceDatabase, sourceTable string, sourceTiDBConn *sql.DB) (string, []cloudstorage.TableCol, error) {
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	pkColumns, _, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	sql, err := GenCreateTable(schemaName, sourceTable, tableColumns, pkColumns)
+	if err != nil {
+		return "", nil, errors.Annotatef(err, "Failed to create table %s.%s", sourceDatabase, sourceTable)
+	}
+	return sql, tableColumns, nil
+}
+
+// GenCreateTable returns the statements to recreate the table with the columns and the primary key columns.
+func GenCreateTable(schemaName, table string, columns []cloudstorage.TableCol, pkColumns []string) (string, error) {
+	// The primary key is required by INSERT ... ON CONFLICT
+	if len(pkColumns) == 0 {
+		return "", errors.Errorf("table %s has no primary key, which is not supported by PostgreSQL", table)
+	}
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
+		row, err := GetPostgresColumnString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+	quotedPKColumns := make([]string, 0, len(pkColumns))
+	for _, column := range pkColumns {
+		quotedPKColumns = append(quotedPKColumns, pq.QuoteIdentifier(column))
+	}
+
+	sqlRows := make([]string, 0, len(columnRows)+1)
+	sqlRows = append(sqlRows, columnRows...)
+	sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quotedPKColumns, ", ")))
+	// Add idents
+	for i := 0; i < len(sqlRows); i++ {
+		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
+	}
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf("DROP TABLE IF EXISTS %s;", tableName(schemaName, table)))
+	sql = append(sql, fmt.Sprintf("CREATE TABLE %s (", tableName(schemaName, table)))
+	sql = append(sql, strings.Join(sqlRows, ",\n"))
+	sql = append(sql, ");")
+
+	return strings.Join(sql, "\n"), nil
+}
+
+// toCopyValue converts the CSV field to the value used by COPY.
+// The binary values are written as is by dumpling, while encoded in base64 by TiCDC.
+func toCopyValue(field *string, column cloudstorage.TableCol, base64Binary bool) (interface{}, error) {
+	if field == nil {
+		return nil, nil
+	}
+	if !isBinaryType(column) {
+		return *field, nil
+	}
+	if !base64Binary {
+		return []byte(*field), nil
+	}
+	value, err := base64.StdEncoding.DecodeString(*field)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to decode binary value of column %s", column.Name)
+	}
+	return value, nil
+}
+
+func columnNames(columns []cloudstorage.TableCol) []string {
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, col.Name)
+	}
+	return names
+}
+
+// CopySnapshotFile copies the rows in the dumpling CSV file into the table through COPY FROM STDIN.
+func CopySnapshotFile(tx *sql.Tx, schemaName, targetTable string, columns []cloudstorage.TableCol, reader io.Reader) (int64, error) {
+	stmt, err := tx.Prepare(pq.CopyInSchema(schemaName, targetTable, columnNames(columns)...))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer stmt.Close()
+
+	var rows int64
+	err = csvutil.ReadRecords(reader, func(fields []*string) error {
+		if len(fields) != len(columns) {
+			return errors.Errorf("the number of fields %d does not match the number of columns %d", len(fields), len(columns))
+		}
+		values := make([]interface{}, 0, len(fields))
+		for i, field := range fields {
+			value, err := toCopyValue(field, columns[i], false)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			values = append(values, value)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return errors.Trace(err)
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	// flush the buffered rows
+	if _, err = stmt.Exec(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return rows, nil
+}
+
+// CopyIncrementFile copies the changes in the TiCDC CSV file into a temporary staging table through COPY FROM STDIN.
+// The staging table is dropped when the transaction ends.
+func CopyIncrementFile(tx *sql.Tx, stagingTable string, columns []cloudstorage.TableCol, reader io.Reader) error {
+	sqlRows := []string{
+		fmt.Sprintf("%s TEXT", pq.QuoteIdentifier(flagColumnName)),
+		fmt.Sprintf("%s BIGINT", pq.QuoteIdentifier(commitTsColumnName)),
+		fmt.Sprintf("%s BIGINT", pq.QuoteIdentifier(seqColumnName)),
+	}
+	for _, col := range columns {
+		tp, err := GetPostgresTypeString(col)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sqlRows = append(sqlRows, fmt.Sprintf("%s %s", pq.QuoteIdentifier(col.Name), tp))
+	}
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s (%s) ON COMMIT DROP", pq.QuoteIdentifier(stagingTable), strings.Join(sqlRows, ", "))); err != nil {
+		return errors.Trace(err)
+	}
+
+	copyColumns := append([]string{flagColumnName, commitTsColumnName, seqColumnName}, columnNames(columns)...)
+	stmt, err := tx.Prepare(pq.CopyIn(stagingTable, copyColumns...))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer stmt.Close()
+
+	var seq int64
+	err = csvutil.ReadRecords(reader, func(fields []*string) error {
+		// The TiCDC CSV fields are: flag, table, schema, commit ts, columns...
+		if len(fields) != len(columns)+4 {
+			return errors.Errorf("the number of fields %d does not match the number of columns %d", len(fields), len(columns)+4)
+		}
+		if fields[0] == nil || fields[3] == nil {
+			return errors.New("the flag or commit ts of the change is NULL")
+		}
+		commitTs, err := strconv.ParseUint(*fields[3], 10, 64)
+		if err != nil {
+			return errors.Annotate(err, "failed to parse commit ts")
+		}
+		seq++
+		values := make([]interface{}, 0, len(copyColumns))
+		values = append(values, *fields[0], int64(commitTs), seq)
+		for i, field := range fields[4:] {
+			value, err := toCopyValue(field, columns[i], true)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			values = append(values, value)
+		}
+		_, err = stmt.Exec(values...)
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// flush the buffered rows
+	_, err = stmt.Exec()
+	return errors.Trace(err)
+}
+
+// GenApplyIncrement generates the DELETE and INSERT ... ON CONFLICT statements to apply the latest change
+// of each row in the staging table to the target table.
+func GenApplyIncrement(schemaName string, tableDef cloudstorage.TableDefinition, stagingTable string) (string, string) {
+	pkColumn := make([]string, 0)
+	onStat := make([]string, 0)
+	for _, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			pkColumn = append(pkColumn, pq.QuoteIdentifier(col.Name))
+			onStat = append(onStat, fmt.Sprintf("T.%s = S.%s", pq.QuoteIdentifier(col.Name), pq.QuoteIdentifier(col.Name)))
+		}
+	}
+
+	columns := make([]string, 0, len(tableDef.Columns))
+	updateStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		columns = append(columns, pq.QuoteIdentifier(col.Name))
+		if col.IsPK != "true" {
+			updateStat = append(updateStat, fmt.Sprintf("%s = EXCLUDED.%s", pq.QuoteIdentifier(col.Name), pq.QuoteIdentifier(col.Name)))
+		}
+	}
+
+	// the latest change of each row, the changes with the same commit ts are ordered by their position in the file
+	latestStat := fmt.Sprintf("SELECT DISTINCT ON (%s) * FROM %s ORDER BY %s, %s DESC, %s DESC",
+		strings.Join(pkColumn, ", "),
+		pq.QuoteIdentifier(stagingTable),
+		strings.Join(pkColumn, ", "),
+		pq.QuoteIdentifier(commitTsColumnName),
+		pq.QuoteIdentifier(seqColumnName))
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s AS T USING (%s) AS S WHERE %s AND S.%s = 'D'",
+		tableName(schemaName, tableDef.Table),
+		latestStat,
+		strings.Join(onStat, " AND "),
+		pq.QuoteIdentifier(flagColumnName))
+
+	conflictStat := "DO NOTHING"
+	if len(updateStat) > 0 {
+		conflictStat = fmt.Sprintf("DO UPDATE SET %s", strings.Join(updateStat, ", "))
+	}
+	upsertQuery := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM (%s) AS S WHERE S.%s != 'D' ON CONFLICT (%s) %s",
+		tableName(schemaName, tableDef.Table),
+		strings.Join(columns, ", "),
+		strings.Join(columns, ", "),
+		latestStat,
+		pq.QuoteIdentifier(flagColumnName),
+		strings.Join(pkColumn, ", "),
+		conflictStat)
+
+	return deleteQuery, upsertQuery
+}
+
+// ApplyLogTableName is the table which records the increment files loaded into PostgreSQL.
+const ApplyLogTableName = "tidb2dw_apply_log"
+
+func CreateApplyLogTable(db *sql.DB, schemaName string) error {
+	sql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    schema_name TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    min_commit_ts BIGINT,
+    max_commit_ts BIGINT,
+    applied_at TIMESTAMPTZ DEFAULT now()
+)`, tableName(schemaName, ApplyLogTableName))
+	_, err := db.Exec(sql)
+	return err
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(db *sql.DB, schemaName, tableSchema, table string, file coreinterfaces.IncrementFile) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE schema_name = $1 AND table_name = $2 AND file_path = $3 AND checksum = $4`, tableName(schemaName, ApplyLogTableName)),
+		tableSchema, table, file.Path, file.Checksum).Scan(&count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+func TrimApplyLog(db *sql.DB, schemaName, tableSchema, table string, commitTs uint64) error {
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE schema_name = $1 AND table_name = $2 AND max_commit_ts < $3`, tableName(schemaName, ApplyLogTableName)),
+		tableSchema, table, int64(commitTs))
+	return errors.Trace(err)
+}
+
+// InsertApplyLog records the increment file in the apply log, it should be executed
+// in the same transaction of loading the file.
+func InsertApplyLog(tx *sql.Tx, schemaName, tableSchema, table string, file coreinterfaces.IncrementFile) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (schema_name, table_name, file_path, checksum, min_commit_ts, max_commit_ts) VALUES ($1, $2, $3, $4, $5, $6)`, tableName(schemaName, ApplyLogTableName)),
+		tableSchema, table, file.Path, file.Checksum, int64(file.MinCommitTs), int64(file.MaxCommitTs))
+	return err
+}
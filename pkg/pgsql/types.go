@@ -0,0 +1,67 @@
+package pgsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// TiDB2PostgresTypeMap is a map from TiDB type to PostgreSQL type.
+var TiDB2PostgresTypeMap map[string]string = map[string]string{
+	"text":       "TEXT",
+	"tinytext":   "TEXT",
+	"mediumtext": "TEXT",
+	"longtext":   "TEXT",
+	"blob":       "BYTEA",
+	"tinyblob":   "BYTEA",
+	"mediumblob": "BYTEA",
+	"longblob":   "BYTEA",
+	"varchar":    "VARCHAR",
+	"char":       "CHAR",
+	"binary":     "BYTEA",
+	"varbinary":  "BYTEA",
+	"int":        "INTEGER",
+	"mediumint":  "INTEGER",
+	"tinyint":    "SMALLINT",
+	"smallint":   "SMALLINT",
+	"bigint":     "BIGINT",
+	"float":      "REAL",
+	"double":     "DOUBLE PRECISION",
+	"decimal":    "NUMERIC",
+	"numeric":    "NUMERIC",
+	"bool":       "BOOLEAN",
+	"boolean":    "BOOLEAN",
+	"date":       "DATE",
+	"datetime":   "TIMESTAMP",
+	"timestamp":  "TIMESTAMP",
+	"time":       "TIME",
+}
+
+// GetPostgresTypeString returns the type of the column in PostgreSQL, e.g. "VARCHAR(10)"
+func GetPostgresTypeString(column cloudstorage.TableCol) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	switch tp {
+	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob", "binary", "varbinary":
+		return TiDB2PostgresTypeMap[tp], nil
+	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
+		return TiDB2PostgresTypeMap[tp], nil
+	case "varchar", "char":
+		return fmt.Sprintf("%s(%s)", TiDB2PostgresTypeMap[tp], column.Precision), nil
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s(%s, %s)", TiDB2PostgresTypeMap[tp], column.Precision, column.Scale), nil
+	case "datetime", "timestamp", "time":
+		if column.Precision != "" {
+			return fmt.Sprintf("%s(%s)", TiDB2PostgresTypeMap[tp], column.Precision), nil
+		}
+		return TiDB2PostgresTypeMap[tp], nil
+	default:
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
+
+// isBinaryType returns whether the column is stored as BYTEA in PostgreSQL.
+func isBinaryType(column cloudstorage.TableCol) bool {
+	return TiDB2PostgresTypeMap[strings.ToLower(column.Tp)] == "BYTEA"
+}
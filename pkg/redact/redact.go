@@ -0,0 +1,52 @@
+package redact
+
+import (
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+const mask = "***"
+
+// quotedValue matches a single quoted string literal, with the quotes escaped by backslash or doubled.
+const quotedValue = `'(?:[^'\\]|\\.|'')*'`
+
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// The credential options of the stages and COPY statements, e.g. AWS_SECRET_KEY = '...' in Snowflake,
+	// AWS_SESSION_TOKEN = '...' in Databricks and AZURE_SAS_TOKEN = '...'.
+	{
+		re:   regexp.MustCompile(`(?i)\b(AWS_KEY_ID|AWS_ACCESS_KEY|AWS_SECRET_KEY|AWS_TOKEN|AWS_SESSION_TOKEN|AZURE_SAS_TOKEN)(\s*=\s*)` + quotedValue),
+		repl: `${1}${2}'` + mask + `'`,
+	},
+	// The credentials string of Redshift COPY, e.g. CREDENTIALS 'aws_access_key_id=...;aws_secret_access_key=...;token=...'.
+	{
+		re:   regexp.MustCompile(`(?i)\b(aws_access_key_id|aws_secret_access_key|token)=[^;'\s]*`),
+		repl: `${1}=` + mask,
+	},
+	// The password in the connection strings, e.g. password='...'.
+	{
+		re:   regexp.MustCompile(`(?i)\b(password)(\s*=\s*)(` + quotedValue + `|[^\s;']+)`),
+		repl: `${1}${2}` + mask,
+	},
+	// The password in the user statements, e.g. CREATE USER u PASSWORD '...'.
+	{
+		re:   regexp.MustCompile(`(?i)\b(password)(\s+)` + quotedValue),
+		repl: `${1}${2}` + mask,
+	},
+}
+
+// SQL replaces the secrets in the SQL statement with "***", so that it can be logged.
+func SQL(sql string) string {
+	for _, p := range secretPatterns {
+		sql = p.re.ReplaceAllString(sql, p.repl)
+	}
+	return sql
+}
+
+// Query returns a zap field of the SQL statement with the secrets redacted.
+func Query(key, sql string) zap.Field {
+	return zap.String(key, SQL(sql))
+}
@@ -0,0 +1,50 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQL(t *testing.T) {
+	cases := []struct {
+		sql      string
+		expected string
+	}{
+		{
+			`CREDENTIALS = (AWS_KEY_ID = 'AKIA' AWS_SECRET_KEY = 'se\'cret' AWS_TOKEN = '')`,
+			`CREDENTIALS = (AWS_KEY_ID = '***' AWS_SECRET_KEY = '***' AWS_TOKEN = '***')`,
+		},
+		{
+			`WITH (CREDENTIAL (AWS_ACCESS_KEY = 'AKIA', AWS_SECRET_KEY = 'secret', AWS_SESSION_TOKEN = 'token'))`,
+			`WITH (CREDENTIAL (AWS_ACCESS_KEY = '***', AWS_SECRET_KEY = '***', AWS_SESSION_TOKEN = '***'))`,
+		},
+		{
+			`CREDENTIALS = (AZURE_SAS_TOKEN = 'sv=2022&sig=abc')`,
+			`CREDENTIALS = (AZURE_SAS_TOKEN = '***')`,
+		},
+		{
+			`COPY t FROM 's3://bucket/a' CREDENTIALS 'aws_access_key_id=AKIA;aws_secret_access_key=secret;token=abc' FORMAT AS CSV`,
+			`COPY t FROM 's3://bucket/a' CREDENTIALS 'aws_access_key_id=***;aws_secret_access_key=***;token=***' FORMAT AS CSV`,
+		},
+		{
+			`host=127.0.0.1 user='u' password='p w' dbname=d`,
+			`host=127.0.0.1 user='u' password=*** dbname=d`,
+		},
+		{
+			`CREATE USER u PASSWORD 'secret'`,
+			`CREATE USER u PASSWORD ***`,
+		},
+		{
+			`COPY t FROM 's3://bucket/a' IAM_ROLE 'arn:aws:iam::123:role/r' FORMAT AS CSV`,
+			`COPY t FROM 's3://bucket/a' IAM_ROLE 'arn:aws:iam::123:role/r' FORMAT AS CSV`,
+		},
+		{
+			"ALTER TABLE t ADD COLUMN password VARCHAR(10)",
+			"ALTER TABLE t ADD COLUMN password VARCHAR(10)",
+		},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, SQL(c.sql))
+	}
+}
@@ -1,14 +1,27 @@
 package redshiftsql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/redshift"
+	"github.com/aws/aws-sdk-go/service/redshiftserverless"
+	"github.com/lib/pq"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"go.uber.org/zap"
 )
 
+// iamCredentialRefreshWindow is how long before the temporary database credential expires a new one is requested.
+const iamCredentialRefreshWindow = 2 * time.Minute
+
 type RedshiftConfig struct {
 	Host     string
 	Port     int
@@ -17,22 +30,215 @@ type RedshiftConfig struct {
 	Database string
 	Schema   string
 	Role     string
+
+	// SSLMode is the sslmode of the connection: disable, require, verify-ca or verify-full.
+	SSLMode string
+	// SSLRootCert is the CA certificate file to verify the server, used by verify-ca and verify-full.
+	SSLRootCert string
+
+	// IAMAuth connects with the temporary database credential of the IAM identity instead of Pass.
+	IAMAuth bool
+	// ClusterID is the identifier of the provisioned cluster.
+	ClusterID string
+	// Workgroup is the name of the Redshift Serverless workgroup.
+	Workgroup string
+	// Region is the region of the cluster or workgroup, resolved from the AWS config if empty.
+	Region string
 }
 
 // Open a connection to Redshift.
-// can not specify one schema in redshift
+// The schema can not be specified in the connection string of redshift, it is set as the search_path of each connection.
 func (config *RedshiftConfig) OpenDB() (*sql.DB, error) {
-	var connStr = fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		config.Host, config.Port, config.User, config.Pass, config.Database)
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, errors.Annotate(err, "Failed to open Redshift connection")
+	if config.ClusterID != "" && config.Workgroup != "" {
+		return nil, errors.New("Redshift cluster and Redshift Serverless workgroup can not be specified at the same time")
+	}
+	if config.Host == "" {
+		if err := config.resolveEndpoint(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if config.IAMAuth && config.ClusterID == "" && config.Workgroup == "" {
+		return nil, errors.New("IAM authentication requires the Redshift cluster identifier or the Redshift Serverless workgroup")
 	}
+	connector := &redshiftConnector{config: config}
+	// make sure the config and the credential are valid before opening the pool
+	if _, err := connector.connString(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	db := sql.OpenDB(connector)
 	// make sure the connection is available
-	if err = db.Ping(); err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, errors.Annotate(err, "Failed to ping Redshift")
 	}
-	log.Info("Redshift connection established")
+	log.Info("Redshift connection established", zap.String("host", config.Host), zap.Bool("iamAuth", config.IAMAuth))
 	return db, nil
 }
+
+func (config *RedshiftConfig) newAWSSession() (*session.Session, error) {
+	opts := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if config.Region != "" {
+		opts.Config.Region = aws.String(config.Region)
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to establish AWS session")
+	}
+	return sess, nil
+}
+
+// resolveEndpoint resolves the host and port from the cluster identifier or the workgroup.
+func (config *RedshiftConfig) resolveEndpoint() error {
+	if config.ClusterID == "" && config.Workgroup == "" {
+		return errors.New("One of Redshift host, cluster identifier and Redshift Serverless workgroup should be specified")
+	}
+	sess, err := config.newAWSSession()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var address *string
+	var port *int64
+	if config.Workgroup != "" {
+		output, err := redshiftserverless.New(sess).GetWorkgroup(&redshiftserverless.GetWorkgroupInput{
+			WorkgroupName: aws.String(config.Workgroup),
+		})
+		if err != nil {
+			return errors.Annotatef(err, "Failed to get Redshift Serverless workgroup %s", config.Workgroup)
+		}
+		if endpoint := output.Workgroup.Endpoint; endpoint != nil {
+			address, port = endpoint.Address, endpoint.Port
+		}
+	} else {
+		output, err := redshift.New(sess).DescribeClusters(&redshift.DescribeClustersInput{
+			ClusterIdentifier: aws.String(config.ClusterID),
+		})
+		if err != nil {
+			return errors.Annotatef(err, "Failed to describe Redshift cluster %s", config.ClusterID)
+		}
+		if len(output.Clusters) > 0 && output.Clusters[0].Endpoint != nil {
+			address, port = output.Clusters[0].Endpoint.Address, output.Clusters[0].Endpoint.Port
+		}
+	}
+	if address == nil {
+		return errors.New("The endpoint of Redshift is not available yet")
+	}
+	config.Host = *address
+	if port != nil {
+		config.Port = int(*port)
+	}
+	log.Info("Resolved Redshift endpoint", zap.String("host", config.Host), zap.Int("port", config.Port))
+	return nil
+}
+
+// getIAMCredential requests a temporary database user and password for the IAM identity.
+func (config *RedshiftConfig) getIAMCredential() (user, password string, expiration time.Time, err error) {
+	sess, err := config.newAWSSession()
+	if err != nil {
+		return "", "", time.Time{}, errors.Trace(err)
+	}
+	if config.Workgroup != "" {
+		// The database user is mapped from the IAM identity
+		output, err := redshiftserverless.New(sess).GetCredentials(&redshiftserverless.GetCredentialsInput{
+			WorkgroupName: aws.String(config.Workgroup),
+			DbName:        aws.String(config.Database),
+		})
+		if err != nil {
+			return "", "", time.Time{}, errors.Annotate(err, "Failed to get Redshift Serverless credential")
+		}
+		return aws.StringValue(output.DbUser), aws.StringValue(output.DbPassword), aws.TimeValue(output.Expiration), nil
+	}
+	output, err := redshift.New(sess).GetClusterCredentials(&redshift.GetClusterCredentialsInput{
+		ClusterIdentifier: aws.String(config.ClusterID),
+		DbUser:            aws.String(config.User),
+		DbName:            aws.String(config.Database),
+	})
+	if err != nil {
+		return "", "", time.Time{}, errors.Annotate(err, "Failed to get Redshift cluster credential")
+	}
+	return aws.StringValue(output.DbUser), aws.StringValue(output.DbPassword), aws.TimeValue(output.Expiration), nil
+}
+
+// redshiftConnector implements driver.Connector. With IAM authentication, the temporary credential
+// expires in minutes, so it is refreshed when the pool opens a new connection after that.
+type redshiftConnector struct {
+	config *RedshiftConfig
+
+	mu         sync.Mutex
+	user       string
+	password   string
+	expiration time.Time
+}
+
+func (c *redshiftConnector) connString() (string, error) {
+	user, password := c.config.User, c.config.Pass
+	if c.config.IAMAuth {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if time.Until(c.expiration) < iamCredentialRefreshWindow {
+			var err error
+			c.user, c.password, c.expiration, err = c.config.getIAMCredential()
+			if err != nil {
+				return "", errors.Trace(err)
+			}
+			log.Info("Got temporary Redshift credential", zap.String("user", c.user), zap.Time("expiration", c.expiration))
+		}
+		user, password = c.user, c.password
+	}
+	sslMode := c.config.SSLMode
+	if sslMode == "" {
+		sslMode = "require"
+	}
+	params := []string{
+		fmt.Sprintf("host=%s", quoteConnParam(c.config.Host)),
+		fmt.Sprintf("port=%d", c.config.Port),
+		fmt.Sprintf("user=%s", quoteConnParam(user)),
+		fmt.Sprintf("password=%s", quoteConnParam(password)),
+		fmt.Sprintf("dbname=%s", quoteConnParam(c.config.Database)),
+		fmt.Sprintf("sslmode=%s", quoteConnParam(sslMode)),
+	}
+	if c.config.SSLRootCert != "" {
+		params = append(params, fmt.Sprintf("sslrootcert=%s", quoteConnParam(c.config.SSLRootCert)))
+	}
+	return strings.Join(params, " "), nil
+}
+
+func (c *redshiftConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connStr, err := c.connString()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open Redshift connection")
+	}
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Schema == "" {
+		return conn, nil
+	}
+	// search_path is a session setting, it is set on every connection of the pool, so that the
+	// unqualified table names resolve to the schema no matter which connection runs the statement.
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("Redshift connection does not support executing statements")
+	}
+	if _, err = execer.ExecContext(ctx, fmt.Sprintf("SET search_path TO %s", c.config.Schema), nil); err != nil {
+		conn.Close()
+		return nil, errors.Annotate(err, "Failed to set search_path of Redshift connection")
+	}
+	return conn, nil
+}
+
+func (c *redshiftConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// quoteConnParam quotes the value of the connection string, e.g. a password with spaces.
+func quoteConnParam(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return fmt.Sprintf("'%s'", value)
+}
@@ -9,8 +9,12 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 )
@@ -21,13 +25,25 @@ type RedshiftConnector struct {
 	schemaName    string
 	stageName     string
 	storageUrl    string
+	s3Region      string
 	s3Credentials *credentials.Value
 	rsCredentials *credentials.Value
 	iamRole       string
 	columns       []cloudstorage.TableCol
+	// mergeKey is how the increment of the table is merged, it is chosen when the schema of the table
+	// is initialized and changed by DDLs, nil if it is not chosen.
+	mergeKey *tidbsql.MergeKey
 }
 
 func NewRedshiftConnector(db *sql.DB, schemaName, stageName, iamRole string, storageURI *url.URL, s3Credentials, rsCredentials *credentials.Value) (*RedshiftConnector, error) {
+	if storageURI.Scheme != "s3" {
+		// COPY is executed by the cluster, which can only read the files from S3
+		return nil, errors.Errorf("Redshift only supports loading data from S3, got storage %s", storageURI.String())
+	}
+	s3Options := storageutil.GetS3Options(storageURI)
+	if s3Options.Endpoint != "" {
+		return nil, errors.Errorf("Redshift does not support loading data from S3 compatible storage %s", s3Options.Endpoint)
+	}
 	var err error
 	// create schema
 	err = CreateSchema(db, schemaName)
@@ -42,6 +58,9 @@ func NewRedshiftConnector(db *sql.DB, schemaName, stageName, iamRole string, sto
 		if err != nil {
 			return nil, errors.Annotate(err, "Failed to create external table")
 		}
+		if err = CreateApplyLogTable(db); err != nil {
+			return nil, errors.Annotate(err, "Failed to create apply log table")
+		}
 	} else if mode != "snapshot" {
 		return nil, errors.Annotate(err, "Incorrect stage name, only support snapshot_stage_* and increment_stage_")
 	}
@@ -50,6 +69,7 @@ func NewRedshiftConnector(db *sql.DB, schemaName, stageName, iamRole string, sto
 		schemaName:    schemaName,
 		stageName:     stageName,
 		storageUrl:    storageUrl,
+		s3Region:      s3Options.Region,
 		s3Credentials: s3Credentials,
 		rsCredentials: rsCredentials,
 		iamRole:       iamRole,
@@ -57,23 +77,33 @@ func NewRedshiftConnector(db *sql.DB, schemaName, stageName, iamRole string, sto
 	}, nil
 }
 
-func (rc *RedshiftConnector) InitSchema(columns []cloudstorage.TableCol) error {
+func (rc *RedshiftConnector) InitSchema(table string, columns []cloudstorage.TableCol) error {
 	if len(rc.columns) != 0 {
 		return nil
 	}
 	if len(columns) == 0 {
 		return errors.New("Columns in schema is empty")
 	}
+	if err := rc.resetMergeKey(table, columns); err != nil {
+		return errors.Trace(err)
+	}
 	rc.columns = columns
 	log.Info("table columns initialized", zap.Any("Columns", columns))
 	return nil
 }
 
 func (rc *RedshiftConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
-	if len(rc.columns) == 0 {
-		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
+	if len(rc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
+	}
+	var constraints *TableConstraints
+	if tidbsql.RequiresColumns(tableDef.Type) {
+		var err error
+		if constraints, err = GetTableConstraints(rc.db, tableDef.Table); err != nil {
+			return errors.Trace(err)
+		}
 	}
-	ddls, err := GenDDLViaColumnsDiff(rc.columns, tableDef)
+	ddls, err := GenDDLViaColumnsDiff(rc.columns, tableDef, constraints)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -81,60 +111,122 @@ func (rc *RedshiftConnector) ExecDDL(tableDef cloudstorage.TableDefinition) erro
 		log.Info("No need to execute this DDL in Redshift", zap.String("ddl", tableDef.Query))
 		return nil
 	}
-	// One DDL may be rewritten to multiple DDLs
-	for _, ddl := range ddls {
-		_, err := rc.db.Exec(ddl)
-		if err != nil {
-			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
-			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+	// One DDL may be rewritten to multiple DDLs, e.g. rebuilding a column. ALTER COLUMN TYPE can not run
+	// inside a transaction block, so it is executed first, then the others are executed in one transaction.
+	standalone, inTransaction := SplitDDLsByTransaction(ddls)
+	for _, ddl := range standalone {
+		if _, err := rc.db.Exec(ddl); err != nil {
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			rc.reloadColumns(tableDef)
+			return errors.Annotate(err, fmt.Sprint("failed to execute ", ddl))
+		}
+	}
+	if len(inTransaction) > 0 {
+		if err := ExecInTransaction(rc.db, inTransaction); err != nil {
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+			rc.reloadColumns(tableDef)
+			return errors.Trace(err)
 		}
 	}
 	// update columns
 	rc.columns = tableDef.Columns
-	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+	rc.resetMergeKeyAfterDDL(tableDef)
 	return nil
 }
 
+// reloadColumns reloads the columns of the table from Redshift after the DDL failed, since the DDLs
+// executed before the failure are not rolled back, and the DDL will be generated again when it is retried.
+func (rc *RedshiftConnector) reloadColumns(tableDef cloudstorage.TableDefinition) {
+	dwColumns, err := GetRedshiftTableColumn(rc.db, tableDef.Table)
+	if err != nil {
+		log.Warn("Failed to reload columns after the DDL failed", zap.String("table", tableDef.Table), zap.Error(err))
+		return
+	}
+	rc.columns = GetColumnsAfterPartialDDL(rc.columns, tableDef.Columns, dwColumns)
+	log.Info("table columns reloaded", zap.Any("Columns", rc.columns))
+}
+
 func (rc *RedshiftConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
 	err := DropTable(sourceTable, rc.db)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	err = CreateTable(sourceDatabase, sourceTable, sourceTiDBConn, rc.db)
+	mergeKey, err := CreateTable(sourceDatabase, sourceTable, sourceTiDBConn, rc.db)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	tidbsql.LogMergeKey(sourceTable, rc.mergeKey, mergeKey)
+	rc.mergeKey = &mergeKey
 	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
 	return nil
 }
 
 // filePrefix should be
 func (rc *RedshiftConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
-	if err := LoadSnapshotFromStage(rc.db, targetTable, rc.storageUrl, filePrefix, rc.s3Credentials, onSnapshotLoadProgress); err != nil {
+	if err := LoadSnapshotFromStage(rc.db, rc.schemaName, targetTable, rc.storageUrl, filePrefix, rc.s3Region, rc.iamRole, rc.s3Credentials, onSnapshotLoadProgress); err != nil {
 		return errors.Trace(err)
 	}
 	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
 	return nil
 }
 
-func (rc *RedshiftConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+func (rc *RedshiftConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(rc.db, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (rc *RedshiftConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(rc.db, schema, table, commitTs))
+}
+
+func (rc *RedshiftConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	if rc.mergeKey == nil {
+		if err := rc.resetMergeKey(tableDef.Table, tableDef.Columns); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	mergeKey := *rc.mergeKey
+	filePath := file.Path
 	// create external table, need S3 manifest file location
 	externalTableName := fmt.Sprintf("%s", rc.stageName)
 	externalTableSchema := fmt.Sprintf("%s_schema", rc.stageName)
 	fileSuffix := filepath.Ext(filePath)
 	manifestFilePath := fmt.Sprintf("%s://%s%s/%s", uri.Scheme, uri.Host, uri.Path, strings.TrimSuffix(filePath, fileSuffix)+".manifest")
-	err := CreateExternalTable(rc.db, tableDef.Columns, externalTableName, externalTableSchema, manifestFilePath)
-
-	// merge staged file into table
-	err = DeleteQuery(rc.db, tableDef, rc.stageName)
+	// the external table may be left by the last failed run
+	err := DropExternalTableIfExists(rc.db, externalTableSchema, externalTableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = CreateExternalTable(rc.db, tableDef.Columns, externalTableName, externalTableSchema, manifestFilePath)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	err = InsertQuery(rc.db, tableDef, rc.stageName)
+	// merge staged file into table and record it in the apply log atomically,
+	// external table can not be created or dropped inside a transaction block.
+	tx, err := rc.db.Begin()
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = DeleteQuery(tx, tableDef, mergeKey, rc.stageName); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = InsertQuery(tx, tableDef, mergeKey, rc.stageName); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = InsertApplyLog(tx, tableDef.Schema, tableDef.Table, file); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
 
 	err = DeleteTable(rc.db, externalTableSchema, externalTableName)
 	if err != nil {
@@ -144,6 +236,40 @@ func (rc *RedshiftConnector) LoadIncrement(tableDef cloudstorage.TableDefinition
 	return nil
 }
 
+// resetMergeKey chooses how the increment of the table is merged, with the columns of the table and the
+// unique constraints in Redshift.
+func (rc *RedshiftConnector) resetMergeKey(table string, columns []cloudstorage.TableCol) error {
+	var uniqueKeys []tidbsql.UniqueKey
+	if len(tidbsql.GetPKColumns(columns)) == 0 {
+		var err error
+		if uniqueKeys, err = GetUniqueKeys(rc.db, table); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	mergeKey, err := tidbsql.GetMergeKey(columns, uniqueKeys)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to choose the merge key of table %s", table)
+	}
+	tidbsql.LogMergeKey(table, rc.mergeKey, mergeKey)
+	rc.mergeKey = &mergeKey
+	return nil
+}
+
+// resetMergeKeyAfterDDL chooses the merge key again after the DDL is executed, since the DDL may change
+// the primary key, the unique keys or the nullability of their columns. The DDL has been executed, so
+// the merge key is chosen again before merging if it fails.
+func (rc *RedshiftConnector) resetMergeKeyAfterDDL(tableDef cloudstorage.TableDefinition) {
+	switch tableDef.Type {
+	case timodel.ActionDropTable, timodel.ActionDropSchema, timodel.ActionCreateSchema:
+		rc.mergeKey = nil
+		return
+	}
+	if err := rc.resetMergeKey(tableDef.Table, tableDef.Columns); err != nil {
+		log.Warn("Failed to choose the merge key after DDL", zap.String("table", tableDef.Table), zap.Error(err))
+		rc.mergeKey = nil
+	}
+}
+
 func (rc *RedshiftConnector) Clone(stageName string, storageURI *url.URL, s3credentials *credentials.Value) (coreinterfaces.Connector, error) {
 	return NewRedshiftConnector(rc.db, rc.schemaName, stageName, rc.iamRole, storageURI, s3credentials, rc.rsCredentials)
 }
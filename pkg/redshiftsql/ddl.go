@@ -3,6 +3,7 @@ package redshiftsql
 import (
 	"database/sql"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -14,33 +15,149 @@ import (
 	"go.uber.org/zap"
 )
 
-func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
-	strs := make([]string, 0, 3)
-	if diff.Before.Tp != diff.After.Tp || diff.Before.Precision != diff.After.Precision || diff.Before.Scale != diff.After.Scale {
-		colStr, err := GetRedshiftTypeString(*diff.After)
-		if err != nil {
-			return "", errors.Trace(err)
-		}
-		strs = append(strs, fmt.Sprintf("COLUMN %s", colStr))
-	}
-	if diff.Before.Default != diff.After.Default {
-		if diff.After.Default == nil {
-			strs = append(strs, fmt.Sprintf("COLUMN %s DROP DEFAULT", diff.After.Name))
+// shadowColumnSuffix is the suffix of the temporary column used to rebuild a column with a new type.
+const shadowColumnSuffix = "_tidb2dw_shadow"
+
+// alterColumnTypeRegexp matches ALTER COLUMN TYPE, which Redshift can not run inside a transaction block.
+var alterColumnTypeRegexp = regexp.MustCompile(`(?i)^ALTER TABLE \S+ ALTER COLUMN \S+ TYPE `)
+
+// CanRunInTransaction returns whether the DDL can run inside a transaction block.
+func CanRunInTransaction(ddl string) bool {
+	return !alterColumnTypeRegexp.MatchString(ddl)
+}
+
+// SplitDDLsByTransaction splits the DDLs rewritten from one DDL of TiDB into the ones which can not run
+// inside a transaction block and the others, the order of the DDLs in each part is kept.
+func SplitDDLsByTransaction(ddls []string) (standalone []string, inTransaction []string) {
+	for _, ddl := range ddls {
+		if CanRunInTransaction(ddl) {
+			inTransaction = append(inTransaction, ddl)
 		} else {
-			log.Warn("Snowflake does not support update column default value", zap.String("column", diff.After.Name), zap.Any("before", diff.Before.Default), zap.Any("after", diff.After.Default))
+			standalone = append(standalone, ddl)
 		}
 	}
-	if diff.Before.Nullable != diff.After.Nullable {
-		if diff.After.Nullable == "true" {
-			strs = append(strs, fmt.Sprintf("COLUMN %s DROP NOT NULL", diff.After.Name))
-		} else {
-			strs = append(strs, fmt.Sprintf("COLUMN %s SET NOT NULL", diff.After.Name))
+	return standalone, inTransaction
+}
+
+// GetColumnsAfterPartialDDL returns the columns of the table when the DDLs which can not run inside a
+// transaction block are executed, but the transaction of the others is rolled back. Only VARCHAR is
+// widened outside the transaction, so a column is changed if its length in Redshift is widened.
+// dwColumns are the columns of the table in Redshift, see GetRedshiftTableColumn.
+func GetColumnsAfterPartialDDL(prevColumns, curColumns, dwColumns []cloudstorage.TableCol) []cloudstorage.TableCol {
+	columns := make([]cloudstorage.TableCol, 0, len(prevColumns))
+	for _, prev := range prevColumns {
+		column := prev
+		for _, cur := range curColumns {
+			if cur.Name != prev.Name || !isVarcharWidening(prev, cur) {
+				continue
+			}
+			for _, dw := range dwColumns {
+				// Redshift folds the names to lower case
+				if strings.EqualFold(dw.Name, prev.Name) && dw.Precision == cur.Precision {
+					column = cur
+				}
+			}
 		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// isVarcharWidening returns whether the column is changed from VARCHAR(m) to VARCHAR(n) with n >= m,
+// which is the only type change Redshift supports in place.
+func isVarcharWidening(before, after cloudstorage.TableCol) bool {
+	if TiDB2RedshiftTypeMap[strings.ToLower(before.Tp)] != "VARCHAR" || TiDB2RedshiftTypeMap[strings.ToLower(after.Tp)] != "VARCHAR" {
+		return false
+	}
+	beforeLen, err := strconv.Atoi(before.Precision)
+	if err != nil {
+		return false
+	}
+	afterLen, err := strconv.Atoi(after.Precision)
+	if err != nil {
+		return false
+	}
+	return afterLen >= beforeLen
+}
+
+// GetColumnModifyDDLs returns the DDLs to modify a column. Redshift can only widen VARCHAR in place,
+// other type changes and dropping NOT NULL are done by rebuilding the column:
+// add a shadow column with the new type, copy the data with a cast, drop the old column and rename
+// the shadow column. The rebuilt column is moved to the last of the table.
+// keyColumns are the lower case names of the columns in the keys of the table, see TableConstraints.
+func GetColumnModifyDDLs(table string, diff *tidbsql.ColumnDiff, keyColumns map[string]string) ([]string, error) {
+	beforeTp, err := GetRedshiftDataType(*diff.Before)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	afterTp, err := GetRedshiftDataType(*diff.After)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if diff.Before.Default != diff.After.Default {
+		log.Warn("Redshift does not support update column default value", zap.String("column", diff.After.Name), zap.Any("before", diff.Before.Default), zap.Any("after", diff.After.Default))
+	}
+	dropNotNull := diff.Before.Nullable == "false" && diff.After.Nullable != "false"
+	if diff.Before.Nullable != "false" && diff.After.Nullable == "false" {
+		log.Warn("Redshift does not support adding NOT NULL to an existing column", zap.String("column", diff.After.Name))
+	}
+	if beforeTp == afterTp && !dropNotNull {
+		return nil, nil
+	}
+	if !dropNotNull && isVarcharWidening(*diff.Before, *diff.After) {
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s;", table, diff.After.Name, afterTp)}, nil
+	}
+
+	// Redshift refuses to drop a column in a constraint, the DISTKEY or the SORTKEY
+	if key, ok := keyColumns[strings.ToLower(diff.Before.Name)]; ok {
+		return nil, errors.Errorf("Redshift can not rebuild column %s of table %s to change it to %s, since it is in the %s", diff.Before.Name, table, afterTp, key)
 	}
-	return strings.Join(strs, ", "), nil
+	// A NOT NULL column can only be added with a default value, which fills the existing rows
+	if diff.Before.Nullable == "false" && diff.After.Nullable == "false" && diff.After.Default == nil {
+		return nil, errors.Errorf("Redshift can not rebuild NOT NULL column %s of table %s to change it to %s, since a NOT NULL column without default value can not be added", diff.Before.Name, table, afterTp)
+	}
+	shadowColumn := *diff.After
+	shadowColumn.Name = diff.After.Name + shadowColumnSuffix
+	if diff.Before.Nullable != "false" {
+		// NOT NULL is not added to an existing column, see above
+		shadowColumn.Nullable = "true"
+	}
+	shadowColStr, err := GetRedshiftColumnString(shadowColumn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, shadowColStr),
+		fmt.Sprintf("UPDATE %s SET %s = CAST(%s AS %s);", table, shadowColumn.Name, diff.Before.Name, afterTp),
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, diff.Before.Name),
+		fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", table, shadowColumn.Name, diff.After.Name),
+	}, nil
+}
+
+// TableConstraints are the primary key and unique constraints of a table in Redshift.
+type TableConstraints struct {
+	// PrimaryKey is the name of the primary key constraint, empty if the table has no primary key.
+	PrimaryKey string
+	// UniqueKeys are the lower case names of the unique constraints.
+	UniqueKeys map[string]bool
+	// KeyColumns maps the lower case names of the columns in the constraints, the DISTKEY and the SORTKEY
+	// to the key, these columns can not be dropped.
+	KeyColumns map[string]string
+}
+
+// GenAddUniqueKeys returns the DDLs to add the unique keys, which are named after the index in TiDB,
+// so that they can be dropped by DROP INDEX.
+func GenAddUniqueKeys(table string, uniqueKeys []tidbsql.UniqueKey) []string {
+	ddls := make([]string, 0, len(uniqueKeys))
+	for _, key := range uniqueKeys {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s);", table, key.Name, strings.Join(key.Columns, ", ")))
+	}
+	return ddls
 }
 
-func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+// GenDDLViaColumnsDiff returns the DDLs to apply the DDL of TiDB in Redshift. constraints are the
+// constraints of the table in Redshift before the DDL, which are used to drop the keys.
+func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, constraints *TableConstraints) ([]string, error) {
 	if curTableDef.Type == timodel.ActionTruncateTable {
 		return []string{fmt.Sprintf("TRUNCATE TABLE %s", curTableDef.Table)}, nil
 	}
@@ -48,25 +165,58 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		return []string{fmt.Sprintf("DROP TABLE %s", curTableDef.Table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateTable {
-		return nil, errors.New("Received create table ddl, which should not happen") // FIXME: drop table and create table
-	}
-	if curTableDef.Type == timodel.ActionRenameTables {
-		return nil, errors.New("Received rename table ddl, new change data can not be capture by TiCDC any more." +
-			"If you want to rename table, please start a new task to capture the new table") // FIXME: rename table to new table and rename back
+		indexChange, err := tidbsql.GetIndexChange(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// the table which can not be merged is refused before it is created
+		if _, err = tidbsql.GetMergeKey(curTableDef.Columns, indexChange.AddedUniqueKeys); err != nil {
+			return nil, errors.Annotatef(err, "Failed to replicate table %s.%s", curTableDef.Schema, curTableDef.Table)
+		}
+		// The table is empty when created in TiDB, so the table left in Redshift is replaced
+		createTable, err := GenCreateTable(curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ddls := []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", curTableDef.Table), createTable}
+		return append(ddls, GenAddUniqueKeys(curTableDef.Table, indexChange.AddedUniqueKeys)...), nil
 	}
-	// snowflake: Default CASCADE, redshift: Default RESTRICT
-	if curTableDef.Type == timodel.ActionDropSchema {
-		return []string{fmt.Sprintf("DROP SCHEMA %s CASCADE", curTableDef.Schema)}, nil
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", oldTable.Name, curTableDef.Table)}, nil
 	}
-	if curTableDef.Type == timodel.ActionCreateSchema {
-		return nil, errors.New("Received create schema ddl, which should not happen") // FIXME: drop schema and create schema
+	// The tables are created in the Redshift schema of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
 	}
 
 	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	indexChange, err := tidbsql.GetIndexChange(curTableDef)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if constraints == nil {
+		constraints = &TableConstraints{}
+	}
+	pkChanged := tidbsql.IsPKChanged(columnDiff)
+
 	ddls := make([]string, 0, len(columnDiff))
+	// The keys are dropped before the columns are changed, and added after that
+	droppedKeys := make(map[string]bool)
+	for _, index := range indexChange.DroppedIndexes {
+		// The dropped index is not a constraint in Redshift if it is not unique
+		if constraints.UniqueKeys[strings.ToLower(index)] {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", curTableDef.Table, index))
+			droppedKeys[strings.ToLower(index)] = true
+		}
+	}
 	for _, item := range columnDiff {
 		ddl := ""
 		switch item.Action {
@@ -79,9 +229,12 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 			ddl += colStr
 		case tidbsql.DROP_COLUMN:
 			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", curTableDef.Table, item.Before.Name)
-		// redshift does not support direct data type modify
 		case tidbsql.MODIFY_COLUMN:
-			return nil, errors.New("Received modify column ddl, which is not supported by redshift yet")
+			modifyDDLs, err := GetColumnModifyDDLs(curTableDef.Table, &item, constraints.KeyColumns)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, modifyDDLs...)
 		case tidbsql.RENAME_COLUMN:
 			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", curTableDef.Table, item.Before.Name, item.After.Name)
 		default:
@@ -93,8 +246,20 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		}
 	}
 
-	// TODO: handle primary key
-	return ddls, nil
+	if pkChanged && constraints.PrimaryKey != "" {
+		ddls = append([]string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", curTableDef.Table, constraints.PrimaryKey)}, ddls...)
+	}
+	if pkColumns := tidbsql.GetPKColumns(curTableDef.Columns); pkChanged && len(pkColumns) > 0 {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", curTableDef.Table, strings.Join(pkColumns, ", ")))
+	}
+	addedKeys := make([]tidbsql.UniqueKey, 0, len(indexChange.AddedUniqueKeys))
+	for _, key := range indexChange.AddedUniqueKeys {
+		// The constraint has been added if the DDL is retried
+		if !constraints.UniqueKeys[strings.ToLower(key.Name)] || droppedKeys[strings.ToLower(key.Name)] {
+			addedKeys = append(addedKeys, key)
+		}
+	}
+	return append(ddls, GenAddUniqueKeys(curTableDef.Table, addedKeys)...), nil
 }
 
 func getDefaultString(val interface{}) string {
@@ -0,0 +1,190 @@
+package redshiftsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestGenDDLViaColumnsDiff(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{
+			ID:        "1",
+			Name:      "id",
+			Tp:        "int",
+			Precision: "11",
+			Nullable:  "false",
+			IsPK:      "true",
+		},
+		{
+			ID:        "2",
+			Name:      "name",
+			Tp:        "varchar",
+			Precision: "10",
+		},
+		{
+			ID:        "3",
+			Name:      "price",
+			Tp:        "decimal",
+			Precision: "10",
+			Scale:     "2",
+			Nullable:  "false",
+		},
+		{
+			ID:   "4",
+			Name: "age",
+			Tp:   "int",
+		},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{
+				ID:        "1",
+				Name:      "id",
+				Tp:        "int",
+				Precision: "11",
+				Nullable:  "false",
+				IsPK:      "true",
+			},
+			{
+				ID:        "2",
+				Name:      "name",
+				Tp:        "varchar",
+				Precision: "20",
+			},
+			{
+				ID:        "3",
+				Name:      "price",
+				Tp:        "decimal",
+				Precision: "10",
+				Scale:     "2",
+				Nullable:  "true",
+			},
+			{
+				ID:   "5",
+				Name: "birth",
+				Tp:   "datetime",
+			},
+		},
+	}
+
+	expectedDDLs := []string{
+		"ALTER TABLE test_table DROP COLUMN age;",
+		"ALTER TABLE test_table ALTER COLUMN name TYPE VARCHAR(20);",
+		"ALTER TABLE test_table ADD COLUMN price_tidb2dw_shadow DECIMAL(10, 2) DEFAULT NULL;",
+		"UPDATE test_table SET price_tidb2dw_shadow = CAST(price AS DECIMAL(10, 2));",
+		"ALTER TABLE test_table DROP COLUMN price;",
+		"ALTER TABLE test_table RENAME COLUMN price_tidb2dw_shadow TO price;",
+		"ALTER TABLE test_table ADD COLUMN birth TIMESTAMP;",
+	}
+
+	constraints := &redshiftsql.TableConstraints{PrimaryKey: "test_table_pkey", KeyColumns: map[string]string{"id": "primary key"}}
+	ddls, err := redshiftsql.GenDDLViaColumnsDiff(prevColumns, curTableDef, constraints)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expectedDDLs, ddls)
+	// The statements of rebuilding a column must be kept in order
+	i := slices.Index(ddls, expectedDDLs[2])
+	require.Equal(t, expectedDDLs[2:6], ddls[i:i+4])
+
+	require.False(t, redshiftsql.CanRunInTransaction("ALTER TABLE test_table ALTER COLUMN name TYPE VARCHAR(20);"))
+	require.True(t, redshiftsql.CanRunInTransaction("ALTER TABLE test_table RENAME COLUMN type TO kind;"))
+}
+
+func TestExecDDLMixedRewrite(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "int", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "name", Tp: "varchar", Precision: "10"},
+		{ID: "3", Name: "price", Tp: "decimal", Precision: "10", Scale: "2"},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "int", Nullable: "false", IsPK: "true"},
+			{ID: "2", Name: "name", Tp: "varchar", Precision: "20"},
+			{ID: "3", Name: "price", Tp: "decimal", Precision: "12", Scale: "2"},
+		},
+	}
+	ddls, err := redshiftsql.GenDDLViaColumnsDiff(prevColumns, curTableDef, nil)
+	require.NoError(t, err)
+
+	// ALTER COLUMN TYPE is executed first, then the rebuild is executed in one transaction
+	standalone, inTransaction := redshiftsql.SplitDDLsByTransaction(ddls)
+	require.Equal(t, []string{"ALTER TABLE test_table ALTER COLUMN name TYPE VARCHAR(20);"}, standalone)
+	require.Equal(t, []string{
+		"ALTER TABLE test_table ADD COLUMN price_tidb2dw_shadow DECIMAL(12, 2) DEFAULT NULL;",
+		"UPDATE test_table SET price_tidb2dw_shadow = CAST(price AS DECIMAL(12, 2));",
+		"ALTER TABLE test_table DROP COLUMN price;",
+		"ALTER TABLE test_table RENAME COLUMN price_tidb2dw_shadow TO price;",
+	}, inTransaction)
+
+	// the column is widened in Redshift, while the transaction of the rebuild is rolled back
+	dwColumns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "integer", Nullable: "false"},
+		{Name: "name", Tp: "character varying", Precision: "20", Nullable: "true"},
+		{Name: "price", Tp: "numeric", Precision: "10", Scale: "2", Nullable: "true"},
+	}
+	columns := redshiftsql.GetColumnsAfterPartialDDL(prevColumns, curTableDef.Columns, dwColumns)
+	require.Equal(t, []cloudstorage.TableCol{prevColumns[0], curTableDef.Columns[1], prevColumns[2]}, columns)
+	// the DDL generated again when it is retried only rebuilds the column
+	ddls, err = redshiftsql.GenDDLViaColumnsDiff(columns, curTableDef, nil)
+	require.NoError(t, err)
+	require.Equal(t, inTransaction, ddls)
+
+	// nothing is changed if the widening failed
+	dwColumns[1].Precision = "10"
+	require.Equal(t, prevColumns, redshiftsql.GetColumnsAfterPartialDDL(prevColumns, curTableDef.Columns, dwColumns))
+}
+
+func TestGetColumnModifyDDLsRebuildRefused(t *testing.T) {
+	keyColumns := map[string]string{"id": "primary key", "code": "DISTKEY"}
+	cases := []struct {
+		before cloudstorage.TableCol
+		after  cloudstorage.TableCol
+		column string
+	}{
+		{
+			before: cloudstorage.TableCol{ID: "1", Name: "id", Tp: "int", Precision: "11", Nullable: "false", IsPK: "true"},
+			after:  cloudstorage.TableCol{ID: "1", Name: "id", Tp: "bigint", Precision: "20", Nullable: "false", IsPK: "true", Default: "0"},
+			column: "id",
+		},
+		{
+			before: cloudstorage.TableCol{ID: "2", Name: "code", Tp: "int", Precision: "11"},
+			after:  cloudstorage.TableCol{ID: "2", Name: "code", Tp: "bigint", Precision: "20"},
+			column: "code",
+		},
+		{
+			// a NOT NULL column without default value can not be added
+			before: cloudstorage.TableCol{ID: "3", Name: "price", Tp: "int", Precision: "11", Nullable: "false"},
+			after:  cloudstorage.TableCol{ID: "3", Name: "price", Tp: "bigint", Precision: "20", Nullable: "false"},
+			column: "price",
+		},
+	}
+	for _, c := range cases {
+		_, err := redshiftsql.GetColumnModifyDDLs("test_table", &tidbsql.ColumnDiff{
+			Action: tidbsql.MODIFY_COLUMN,
+			Before: &c.before,
+			After:  &c.after,
+		}, keyColumns)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), c.column)
+	}
+}
+
+func TestGenDDLViaColumnsDiffSchema(t *testing.T) {
+	for _, tableDef := range []cloudstorage.TableDefinition{
+		{Schema: "test_schema", Type: timodel.ActionCreateSchema, Query: "CREATE DATABASE test_schema"},
+		{Schema: "test_schema", Type: timodel.ActionDropSchema, Query: "DROP DATABASE test_schema"},
+	} {
+		ddls, err := redshiftsql.GenDDLViaColumnsDiff(nil, tableDef, nil)
+		require.NoError(t, err)
+		require.Empty(t, ddls)
+	}
+}
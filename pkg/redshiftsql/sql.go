@@ -5,17 +5,19 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
 	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
-	"github.com/pingcap/tidb/dumpling/export"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"gitlab.com/tymonx/go-formatter/formatter"
 	"go.uber.org/zap"
-	"golang.org/x/exp/slices"
 )
 
 func GetServerSideTimestamp(db *sql.DB) (string, error) {
@@ -30,91 +32,218 @@ func GetServerSideTimestamp(db *sql.DB) (string, error) {
 func CreateSchema(db *sql.DB, schemaName string) error {
 	sql := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schemaName)
 	_, err := db.Exec(sql)
-	if err != nil {
-		return errors.Trace(err)
+	return errors.Trace(err)
+}
+
+// genAuthorizationClause returns the authorization clause of COPY. The IAM role is preferred,
+// so that no secret is written into the statement, which is recorded in the system tables.
+func genAuthorizationClause(iamRole string, credential *credentials.Value) string {
+	if iamRole != "" {
+		return fmt.Sprintf("IAM_ROLE '%s'", snowsql.EscapeString(iamRole))
 	}
-	sql = fmt.Sprintf("SET search_path TO %s", schemaName)
-	_, err = db.Exec(sql)
-	return err
+	credStr := fmt.Sprintf("aws_access_key_id=%s;aws_secret_access_key=%s", credential.AccessKeyID, credential.SecretAccessKey)
+	if credential.SessionToken != "" {
+		credStr += fmt.Sprintf(";token=%s", credential.SessionToken)
+	}
+	return fmt.Sprintf("CREDENTIALS '%s'", snowsql.EscapeString(credStr))
 }
 
-// redshift currently can not support ROWS_PRODUCED function
 // use csv file path for stageUrl, like s3://tidbbucket/snapshot/stock.csv
-func LoadSnapshotFromStage(db *sql.DB, targetTable, storageUrl, filePrefix string, credential *credentials.Value, onSnapshotLoadProgress func(loadedRows int64)) error {
+// the region is only needed when the bucket is in a different region from the cluster.
+// The target table is qualified with the schema, since COPY runs in a dedicated connection.
+func LoadSnapshotFromStage(db *sql.DB, schemaName, targetTable, storageUrl, filePrefix, region, iamRole string, credential *credentials.Value, onSnapshotLoadProgress func(loadedRows int64)) error {
+	regionStat := ""
+	if region != "" {
+		regionStat = fmt.Sprintf("\n\tREGION '%s'", snowsql.EscapeString(region))
+	}
 	sql, err := formatter.Format(`
 	COPY {targetTable}
 	FROM '{stageName}/{filePrefix}'
-	CREDENTIALS 'aws_access_key_id={accessId};aws_secret_access_key={accessKey}'
+	{authorization}{regionStat}
 	FORMAT AS CSV DELIMITER ',' QUOTE '"';
 	`, formatter.Named{
-		"targetTable": snowsql.EscapeString(targetTable),
-		"stageName":   snowsql.EscapeString(storageUrl),
-		"filePrefix":  snowsql.EscapeString(filePrefix), // TODO: Verify
-		"accessId":    credential.AccessKeyID,
-		"accessKey":   credential.SecretAccessKey,
+		"regionStat":    regionStat,
+		"targetTable":   fmt.Sprintf("%s.%s", schemaName, targetTable),
+		"stageName":     snowsql.EscapeString(storageUrl),
+		"filePrefix":    snowsql.EscapeString(filePrefix), // TODO: Verify
+		"authorization": genAuthorizationClause(iamRole, credential),
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Loading snapshot data from external table", zap.String("query", sql))
+
+	// COPY is executed in a dedicated connection, so that the progress and the errors can be found by the session.
 	ctx := context.Background()
-	_, err = db.ExecContext(ctx, sql)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer conn.Close()
+	var pid int64
+	if err = conn.QueryRowContext(ctx, "SELECT pg_backend_pid();").Scan(&pid); err != nil {
+		return errors.Trace(err)
+	}
 
-	return err
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	copyFinished := make(chan struct{})
+
+	go func() {
+		// This is a goroutine to monitor the COPY progress.
+		defer wg.Done()
+
+		if onSnapshotLoadProgress == nil {
+			return
+		}
+
+		checkInterval := 10 * time.Second
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-copyFinished:
+				return
+			case <-ticker.C:
+				loadedRows, err := getLoadedRows(db, pid)
+				if err != nil {
+					log.Warn("Failed to get progress", zap.Error(err))
+					continue
+				}
+				onSnapshotLoadProgress(loadedRows)
+			}
+		}
+	}()
+
+	log.Info("Loading snapshot data from external table", redact.Query("query", sql))
+	_, err = conn.ExecContext(ctx, sql)
+	close(copyFinished)
+
+	wg.Wait()
+
+	if err != nil {
+		loadErrors, queryErr := getLoadErrors(conn)
+		if queryErr != nil {
+			log.Warn("Failed to get the load errors", zap.Error(queryErr))
+		}
+		if len(loadErrors) > 0 {
+			return errors.Annotatef(err, "Failed to load snapshot, load errors: %s", strings.Join(loadErrors, "; "))
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// getLoadedRows returns the rows loaded by the running COPY of the session, which is the rows of the files
+// committed in STL_LOAD_COMMITS plus the rows of the files being loaded in STV_LOAD_STATE.
+func getLoadedRows(db *sql.DB, pid int64) (int64, error) {
+	var queryID int64
+	err := db.QueryRow("SELECT query FROM stv_inflight WHERE pid = $1 ORDER BY starttime DESC LIMIT 1;", pid).Scan(&queryID)
+	if err == sql.ErrNoRows {
+		// COPY has not started or has finished
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	var committedRows, loadingRows int64
+	err = db.QueryRow("SELECT COALESCE(SUM(lines_scanned), 0) FROM stl_load_commits WHERE query = $1;", queryID).Scan(&committedRows)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	err = db.QueryRow("SELECT COALESCE(SUM(lines), 0) FROM stv_load_state WHERE query = $1;", queryID).Scan(&loadingRows)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return committedRows + loadingRows, nil
+}
+
+// maxLoadErrors is the max number of rows read from STL_LOAD_ERRORS for a failed COPY.
+const maxLoadErrors = 10
+
+// getLoadErrors returns the details in STL_LOAD_ERRORS of the last COPY in the session.
+func getLoadErrors(conn *sql.Conn) ([]string, error) {
+	rows, err := conn.QueryContext(context.Background(), fmt.Sprintf(`
+	SELECT TRIM(filename), line_number, TRIM(colname), TRIM(err_reason)
+	FROM stl_load_errors
+	WHERE query = pg_last_copy_id()
+	ORDER BY starttime
+	LIMIT %d;`, maxLoadErrors))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+	loadErrors := make([]string, 0)
+	for rows.Next() {
+		var fileName, colName, reason string
+		var lineNumber int64
+		if err = rows.Scan(&fileName, &lineNumber, &colName, &reason); err != nil {
+			return nil, errors.Trace(err)
+		}
+		loadErrors = append(loadErrors, fmt.Sprintf("file %s, line %d, column %s: %s", fileName, lineNumber, colName, reason))
+	}
+	return loadErrors, errors.Trace(rows.Err())
 }
 
 func DropTable(sourceTable string, db *sql.DB) error {
 	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s", sourceTable)
-	log.Info("Dropping table in Redshift if exists", zap.String("query", sql))
+	log.Info("Dropping table in Redshift if exists", redact.Query("query", sql))
 	_, err := db.Exec(sql)
 	return err
 }
 
-func CreateTable(sourceDatabase string, sourceTable string, sourceTiDBConn, db *sql.DB) error {
+// CreateTable creates the table with the schema in TiDB, and returns the merge key of the table.
+func CreateTable(sourceDatabase string, sourceTable string, sourceTiDBConn, db *sql.DB) (tidbsql.MergeKey, error) {
 	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
 	if err != nil {
-		return errors.Trace(err)
+		return tidbsql.MergeKey{}, errors.Trace(err)
 	}
-	columnRows := make([]string, 0, len(tableColumns))
-	for _, column := range tableColumns {
-		row, err := GetRedshiftColumnString(column)
-		if err != nil {
-			return errors.Trace(err)
+
+	pkColumns, uniqueKeys, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return tidbsql.MergeKey{}, errors.Trace(err)
+	}
+	// the table which can not be merged is refused before it is created
+	mergeKey := tidbsql.MergeKey{Strategy: tidbsql.MergeOnPrimaryKey, Columns: pkColumns}
+	if len(pkColumns) == 0 {
+		if mergeKey, err = tidbsql.GetMergeKey(tableColumns, uniqueKeys); err != nil {
+			return tidbsql.MergeKey{}, errors.Annotatef(err, "Failed to replicate table %s.%s", sourceDatabase, sourceTable)
 		}
-		columnRows = append(columnRows, row)
 	}
 
-	indexQuery := fmt.Sprintf("SHOW INDEX FROM `%s`.`%s`", sourceDatabase, sourceTable) // FIXME: Escape
-	indexRows, err := sourceTiDBConn.QueryContext(context.Background(), indexQuery)
+	query, err := GenCreateTable(sourceTable, tableColumns, pkColumns)
 	if err != nil {
-		return errors.Trace(err)
+		return tidbsql.MergeKey{}, errors.Trace(err)
 	}
-	indexResults, err := export.GetSpecifiedColumnValuesAndClose(indexRows, "KEY_NAME", "COLUMN_NAME", "SEQ_IN_INDEX")
-	if err != nil {
-		return errors.Trace(err)
+	log.Info("Creating table in Redshift", redact.Query("query", query))
+	if _, err = db.Exec(query); err != nil {
+		return tidbsql.MergeKey{}, errors.Trace(err)
 	}
-
-	redshiftPKColumns := make([]string, 0)
-	// Sort by key_name, seq_in_index
-	slices.SortFunc(indexResults, func(i, j []string) bool {
-		if i[0] == j[0] {
-			return i[2] < j[2] // Sort by seq_in_index
-		}
-		return i[0] < j[0] // Sort by key_name
-	})
-	for _, oneRow := range indexResults {
-		keyName, columnName := oneRow[0], oneRow[1]
-		if keyName == "PRIMARY" {
-			redshiftPKColumns = append(redshiftPKColumns, columnName)
+	// Redshift can not name the constraints in CREATE TABLE
+	for _, ddl := range GenAddUniqueKeys(sourceTable, uniqueKeys) {
+		if _, err = db.Exec(ddl); err != nil {
+			return tidbsql.MergeKey{}, errors.Annotatef(err, "Failed to add unique key, ddl %s", ddl)
 		}
 	}
+	return mergeKey, nil
+}
 
-	// TODO: Support unique key
+// GenCreateTable returns the statement to create the table with the columns and the primary key columns.
+func GenCreateTable(tableName string, columns []cloudstorage.TableCol, pkColumns []string) (string, error) {
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
+		row, err := GetRedshiftColumnString(column)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
 
 	sqlRows := make([]string, 0, len(columnRows)+1)
 	sqlRows = append(sqlRows, columnRows...)
-	if len(redshiftPKColumns) > 0 {
-		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(redshiftPKColumns, ", ")))
+	if len(pkColumns) > 0 {
+		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(pkColumns, ", ")))
 	}
 	// Add idents
 	for i := 0; i < len(sqlRows); i++ {
@@ -122,14 +251,11 @@ func CreateTable(sourceDatabase string, sourceTable string, sourceTiDBConn, db *
 	}
 
 	sql := []string{}
-	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, sourceTable)) // TODO: Escape
+	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, tableName)) // TODO: Escape
 	sql = append(sql, strings.Join(sqlRows, ",\n"))
 	sql = append(sql, ")")
 
-	query := strings.Join(sql, "\n")
-	log.Info("Creating table in Redshift", zap.String("query", query))
-	_, err = db.Exec(query)
-	return err
+	return strings.Join(sql, "\n"), nil
 }
 
 func CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string) error {
@@ -147,7 +273,7 @@ func CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Creating external schema", zap.String("query", sql))
+	log.Info("Creating external schema", redact.Query("query", sql))
 	ctx := context.Background()
 	_, err = db.ExecContext(ctx, sql)
 
@@ -158,11 +284,11 @@ func CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string)
 func CreateExternalTable(db *sql.DB, columns []cloudstorage.TableCol, tableName, schemaName, manifestFile string) error {
 	columnRows := make([]string, 0, len(columns))
 	for _, column := range columns {
-		row, err := GetRedshiftTypeString(column)
+		tp, err := GetExternalDataType(column)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		columnRows = append(columnRows, row)
+		columnRows = append(columnRows, fmt.Sprintf("%s %s", column.Name, tp))
 	}
 	sqlRows := make([]string, 0, len(columnRows)+1)
 	sqlRows = append(sqlRows, columnRows...)
@@ -188,24 +314,20 @@ func CreateExternalTable(db *sql.DB, columns []cloudstorage.TableCol, tableName,
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Creating external table", zap.String("query", sql))
+	log.Info("Creating external table", redact.Query("query", sql))
 	_, err = db.Exec(sql)
 	return err
 }
 
-func DeleteQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, stageName string) error {
+func DeleteQuery(tx *sql.Tx, tableDef cloudstorage.TableDefinition, mergeKey tidbsql.MergeKey, stageName string) error {
 	selectStat := make([]string, 0, len(tableDef.Columns)+1)
 	selectStat = append(selectStat, `flag`)
 	for _, col := range tableDef.Columns {
 		selectStat = append(selectStat, col.Name)
 	}
-	pkColumn := make([]string, 0)
-	onStat := make([]string, 0)
-	for _, col := range tableDef.Columns {
-		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-			onStat = append(onStat, fmt.Sprintf(`%s.%s = S.%s`, tableDef.Table, col.Name, col.Name))
-		}
+	onStat := make([]string, 0, len(mergeKey.Columns))
+	for _, name := range mergeKey.Columns {
+		onStat = append(onStat, fmt.Sprintf(`%s.%s = S.%s`, tableDef.Table, name, name))
 	}
 	sql, err := formatter.Format(`
 	DELETE FROM {tableName} USING (
@@ -221,33 +343,29 @@ func DeleteQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, stageName st
 		"externalSchema": fmt.Sprintf("%s_schema", stageName),
 		"externalTable":  fmt.Sprintf("%s", stageName),
 		"selectStat":     strings.Join(selectStat, ",\n"),
-		"pkStat":         strings.Join(pkColumn, ", "),
+		"pkStat":         strings.Join(mergeKey.Columns, ", "),
 		"onStat":         strings.Join(onStat, " AND "),
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("delete external table into table", zap.String("query", sql))
-	_, err = db.Exec(sql)
+	log.Info("delete external table into table", redact.Query("query", sql))
+	_, err = tx.Exec(sql)
 	return err
 }
 
-func InsertQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, stageName string) error {
+func InsertQuery(tx *sql.Tx, tableDef cloudstorage.TableDefinition, mergeKey tidbsql.MergeKey, stageName string) error {
 	selectStat := make([]string, 0, len(tableDef.Columns)+1)
+	valueStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
 		selectStat = append(selectStat, col.Name)
+		valueStat = append(valueStat, fmt.Sprintf("%s AS %s", GetColumnValueExpr(col, col.Name), col.Name))
 	}
-	pkColumn := make([]string, 0)
-
-	for _, col := range tableDef.Columns {
-		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-		}
-	}
+	// the columns are listed since the order may differ from TiDB, e.g. a rebuilt column is moved to the last
 	sql, err := formatter.Format(`
-	INSERT INTO {tableName}  
+	INSERT INTO {tableName} ({columns})
 	SELECT
-		{selectStat}
+		{valueStat}
 	FROM (
 	SELECT
 		flag, 
@@ -262,19 +380,42 @@ func InsertQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, stageName st
 		"externalSchema": fmt.Sprintf("%s_schema", stageName),
 		"externalTable":  fmt.Sprintf("%s", stageName),
 		"selectStat":     strings.Join(selectStat, ",\n"),
-		"pkStat":         strings.Join(pkColumn, ", "),
+		"valueStat":      strings.Join(valueStat, ",\n"),
+		"pkStat":         strings.Join(mergeKey.Columns, ", "),
+		"columns":        strings.Join(selectStat, ", "),
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("insert external table into table", zap.String("query", sql))
-	_, err = db.Exec(sql)
+	log.Info("insert external table into table", redact.Query("query", sql))
+	_, err = tx.Exec(sql)
 	return err
 }
 
+// ExecInTransaction executes the statements in one transaction.
+func ExecInTransaction(db *sql.DB, stmts []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(stmt); err != nil {
+			_ = tx.Rollback()
+			return errors.Annotate(err, fmt.Sprint("failed to execute ", stmt))
+		}
+	}
+	return errors.Trace(tx.Commit())
+}
+
 func DeleteTable(db *sql.DB, tableName, schemaName string) error {
 	sql := fmt.Sprintf("DROP TABLE %s.%s", tableName, schemaName)
-	log.Info("delete table", zap.String("query", sql))
+	log.Info("delete table", redact.Query("query", sql))
+	_, err := db.Exec(sql)
+	return err
+}
+
+func DropExternalTableIfExists(db *sql.DB, schemaName, tableName string) error {
+	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", schemaName, tableName)
 	_, err := db.Exec(sql)
 	return err
 }
@@ -284,3 +425,127 @@ func DropExternalSchema(db *sql.DB, schemaName string) error {
 	_, err := db.Exec(sql)
 	return err
 }
+
+// ApplyLogTableName is the table which records the increment files loaded into Redshift.
+const ApplyLogTableName = "tidb2dw_apply_log"
+
+func CreateApplyLogTable(db *sql.DB) error {
+	sql := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		schema_name VARCHAR(255) NOT NULL,
+		table_name VARCHAR(255) NOT NULL,
+		file_path VARCHAR(1024) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		min_commit_ts BIGINT,
+		max_commit_ts BIGINT,
+		applied_at TIMESTAMP DEFAULT GETDATE()
+	)`, ApplyLogTableName)
+	log.Info("Creating apply log table", redact.Query("query", sql))
+	_, err := db.Exec(sql)
+	return err
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(db *sql.DB, schemaName, tableName string, file coreinterfaces.IncrementFile) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE schema_name = $1 AND table_name = $2 AND file_path = $3 AND checksum = $4`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum).Scan(&count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+func TrimApplyLog(db *sql.DB, schemaName, tableName string, commitTs uint64) error {
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE schema_name = $1 AND table_name = $2 AND max_commit_ts < $3`, ApplyLogTableName),
+		schemaName, tableName, int64(commitTs))
+	return errors.Trace(err)
+}
+
+// InsertApplyLog records the increment file in the apply log, it should be executed
+// in the same transaction of loading the file.
+func InsertApplyLog(tx *sql.Tx, schemaName, tableName string, file coreinterfaces.IncrementFile) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (schema_name, table_name, file_path, checksum, min_commit_ts, max_commit_ts) VALUES ($1, $2, $3, $4, $5, $6)`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum, int64(file.MinCommitTs), int64(file.MaxCommitTs))
+	return err
+}
+
+// GetTableConstraints returns the primary key and unique constraints of the table in the current schema,
+// and the columns in the constraints, the DISTKEY and the SORTKEY.
+func GetTableConstraints(db *sql.DB, tableName string) (*TableConstraints, error) {
+	rows, err := db.Query(`SELECT tc.constraint_name, tc.constraint_type, kcu.column_name
+FROM information_schema.table_constraints tc JOIN information_schema.key_column_usage kcu
+ON tc.constraint_schema = kcu.constraint_schema AND tc.constraint_name = kcu.constraint_name
+WHERE tc.table_schema = current_schema() AND tc.table_name = LOWER($1)`, tableName)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to get the constraints of table %s", tableName)
+	}
+	defer rows.Close()
+	constraints := &TableConstraints{UniqueKeys: make(map[string]bool), KeyColumns: make(map[string]string)}
+	for rows.Next() {
+		var name, tp, column string
+		if err = rows.Scan(&name, &tp, &column); err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch tp {
+		case "PRIMARY KEY":
+			constraints.PrimaryKey = name
+			constraints.KeyColumns[strings.ToLower(column)] = "primary key"
+		case "UNIQUE":
+			constraints.UniqueKeys[strings.ToLower(name)] = true
+			constraints.KeyColumns[strings.ToLower(column)] = fmt.Sprintf("unique key %s", name)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// pg_table_def only returns the tables in the search_path
+	keyRows, err := db.Query(`SELECT "column", distkey FROM pg_table_def
+WHERE schemaname = current_schema() AND tablename = LOWER($1) AND (distkey OR sortkey <> 0)`, tableName)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to get the distribution and sort keys of table %s", tableName)
+	}
+	defer keyRows.Close()
+	for keyRows.Next() {
+		var column string
+		var distKey bool
+		if err = keyRows.Scan(&column, &distKey); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if distKey {
+			constraints.KeyColumns[strings.ToLower(column)] = "DISTKEY"
+		} else {
+			constraints.KeyColumns[strings.ToLower(column)] = "SORTKEY"
+		}
+	}
+	return constraints, errors.Trace(keyRows.Err())
+}
+
+// GetUniqueKeys returns the unique constraints of the table in the current schema.
+func GetUniqueKeys(db *sql.DB, tableName string) ([]tidbsql.UniqueKey, error) {
+	rows, err := db.Query(`SELECT tc.constraint_name, kcu.column_name
+FROM information_schema.table_constraints tc JOIN information_schema.key_column_usage kcu
+ON tc.constraint_schema = kcu.constraint_schema AND tc.constraint_name = kcu.constraint_name
+WHERE tc.table_schema = current_schema() AND tc.table_name = LOWER($1) AND tc.constraint_type = 'UNIQUE'
+ORDER BY tc.constraint_name, kcu.ordinal_position`, tableName)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to get the unique keys of table %s", tableName)
+	}
+	defer rows.Close()
+	uniqueKeys := make([]tidbsql.UniqueKey, 0)
+	for rows.Next() {
+		var name, column string
+		if err = rows.Scan(&name, &column); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(uniqueKeys) > 0 && uniqueKeys[len(uniqueKeys)-1].Name == name {
+			uniqueKeys[len(uniqueKeys)-1].Columns = append(uniqueKeys[len(uniqueKeys)-1].Columns, column)
+			continue
+		}
+		uniqueKeys = append(uniqueKeys, tidbsql.UniqueKey{Name: name, Columns: []string{column}})
+	}
+	return uniqueKeys, errors.Trace(rows.Err())
+}
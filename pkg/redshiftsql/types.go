@@ -37,22 +37,79 @@ var TiDB2RedshiftTypeMap map[string]string = map[string]string{
 	"datetime":   "TIMESTAMP",
 	"timestamp":  "TIMESTAMP",
 	"time":       "TIME",
+	"json":       "SUPER",
+	"enum":       "VARCHAR",
+	"set":        "VARCHAR",
+	"bit":        "DECIMAL",
+	"year":       "SMALLINT",
+	"vector":     "SUPER",
+	// The spatial values are kept as binary
+	"geometry":           "VARBYTE",
+	"point":              "VARBYTE",
+	"linestring":         "VARBYTE",
+	"polygon":            "VARBYTE",
+	"multipoint":         "VARBYTE",
+	"multilinestring":    "VARBYTE",
+	"multipolygon":       "VARBYTE",
+	"geometrycollection": "VARBYTE",
 }
 
+// GetRedshiftTypeString returns the column name and the Redshift data type, e.g. "name VARCHAR(20)".
 func GetRedshiftTypeString(column cloudstorage.TableCol) (string, error) {
+	tp, err := GetRedshiftDataType(column)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", column.Name, tp), nil
+}
+
+// GetRedshiftDataType returns the Redshift data type of the column, e.g. "VARCHAR(20)".
+func GetRedshiftDataType(column cloudstorage.TableCol) (string, error) {
 	tp := strings.ToLower(column.Tp)
 	switch tp {
 	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2RedshiftTypeMap[tp]), nil
+		return TiDB2RedshiftTypeMap[tp], nil
+	case "json", "year", "vector":
+		return TiDB2RedshiftTypeMap[tp], nil
+	case "geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		return TiDB2RedshiftTypeMap[tp], nil
+	case "enum", "set":
+		// VARCHAR is 256 bytes by default, which is not enough for a set of many members
+		return fmt.Sprintf("%s(65535)", TiDB2RedshiftTypeMap[tp]), nil
+	case "bit":
+		// BIT(64) may exceed the range of BIGINT
+		return fmt.Sprintf("%s(20, 0)", TiDB2RedshiftTypeMap[tp]), nil
 	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2RedshiftTypeMap[tp]), nil
+		return TiDB2RedshiftTypeMap[tp], nil
 	case "varchar", "char", "binary", "varbinary":
-		return fmt.Sprintf("%s %s(%s)", column.Name, TiDB2RedshiftTypeMap[tp], column.Precision), nil
+		return fmt.Sprintf("%s(%s)", TiDB2RedshiftTypeMap[tp], column.Precision), nil
 	case "decimal", "numeric":
-		return fmt.Sprintf("%s %s(%s, %s)", column.Name, TiDB2RedshiftTypeMap[tp], column.Precision, column.Scale), nil
+		return fmt.Sprintf("%s(%s, %s)", TiDB2RedshiftTypeMap[tp], column.Precision, column.Scale), nil
 	case "datetime", "timestamp", "time":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2RedshiftTypeMap[tp]), nil
+		return TiDB2RedshiftTypeMap[tp], nil
 	default:
 		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
 	}
 }
+
+// GetExternalDataType returns the data type of the column in the external table of the increment files,
+// the SUPER values are read as the JSON text.
+func GetExternalDataType(column cloudstorage.TableCol) (string, error) {
+	tp, err := GetRedshiftDataType(column)
+	if err != nil {
+		return "", err
+	}
+	if tp == "SUPER" {
+		return "VARCHAR(65535)", nil
+	}
+	return tp, nil
+}
+
+// GetColumnValueExpr returns the expression which converts the field read from the external table
+// to the Redshift type of the column.
+func GetColumnValueExpr(column cloudstorage.TableCol, field string) string {
+	if TiDB2RedshiftTypeMap[strings.ToLower(column.Tp)] == "SUPER" {
+		return fmt.Sprintf("JSON_PARSE(%s)", field)
+	}
+	return field
+}
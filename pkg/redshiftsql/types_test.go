@@ -0,0 +1,46 @@
+package redshiftsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRedshiftDataType(t *testing.T) {
+	cases := []struct {
+		column           cloudstorage.TableCol
+		expected         string
+		expectedExternal string
+	}{
+		{cloudstorage.TableCol{Name: "c", Tp: "JSON"}, "SUPER", "VARCHAR(65535)"},
+		{cloudstorage.TableCol{Name: "c", Tp: "ENUM"}, "VARCHAR(65535)", "VARCHAR(65535)"},
+		{cloudstorage.TableCol{Name: "c", Tp: "SET"}, "VARCHAR(65535)", "VARCHAR(65535)"},
+		{cloudstorage.TableCol{Name: "c", Tp: "BIT", Precision: "1"}, "DECIMAL(20, 0)", "DECIMAL(20, 0)"},
+		{cloudstorage.TableCol{Name: "c", Tp: "YEAR"}, "SMALLINT", "SMALLINT"},
+		{cloudstorage.TableCol{Name: "c", Tp: "VECTOR"}, "SUPER", "VARCHAR(65535)"},
+		{cloudstorage.TableCol{Name: "c", Tp: "GEOMETRY"}, "VARBYTE", "VARBYTE"},
+		{cloudstorage.TableCol{Name: "c", Tp: "POLYGON"}, "VARBYTE", "VARBYTE"},
+		{cloudstorage.TableCol{Name: "c", Tp: "VARCHAR", Precision: "20"}, "VARCHAR(20)", "VARCHAR(20)"},
+	}
+	for _, c := range cases {
+		tp, err := redshiftsql.GetRedshiftDataType(c.column)
+		require.NoError(t, err, c.column.Tp)
+		require.Equal(t, c.expected, tp, c.column.Tp)
+		tp, err = redshiftsql.GetExternalDataType(c.column)
+		require.NoError(t, err, c.column.Tp)
+		require.Equal(t, c.expectedExternal, tp, c.column.Tp)
+	}
+
+	_, err := redshiftsql.GetRedshiftDataType(cloudstorage.TableCol{Name: "c", Tp: "UNKNOWN"})
+	require.Error(t, err)
+}
+
+func TestGetColumnValueExpr(t *testing.T) {
+	require.Equal(t, "JSON_PARSE(c)", redshiftsql.GetColumnValueExpr(cloudstorage.TableCol{Name: "c", Tp: "JSON"}, "c"))
+	require.Equal(t, "JSON_PARSE(c)", redshiftsql.GetColumnValueExpr(cloudstorage.TableCol{Name: "c", Tp: "VECTOR"}, "c"))
+	for _, tp := range []string{"ENUM", "SET", "BIT", "YEAR", "INT"} {
+		require.Equal(t, "c", redshiftsql.GetColumnValueExpr(cloudstorage.TableCol{Name: "c", Tp: tp}, "c"), tp)
+	}
+}
@@ -1,11 +1,15 @@
 package snowsql
 
 import (
+	"crypto/rsa"
 	"database/sql"
+	"encoding/pem"
+	"os"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/snowflakedb/gosnowflake"
+	"github.com/youmark/pkcs8"
 )
 
 type SnowflakeConfig struct {
@@ -15,6 +19,19 @@ type SnowflakeConfig struct {
 	Pass      string
 	Database  string
 	Schema    string
+	Role      string
+
+	// PrivateKeyPath is the PKCS#8 private key file for key-pair authentication,
+	// PrivateKeyPassphrase is needed if the key is encrypted.
+	PrivateKeyPath       string
+	PrivateKeyPassphrase string
+	// OAuthToken is the access token for OAuth authentication.
+	OAuthToken string
+
+	// StorageIntegration is the name of an existing storage integration used by the external stage.
+	StorageIntegration string
+	// AWSRoleARN is the AWS role assumed by the external stage to access the S3 bucket.
+	AWSRoleARN string
 }
 
 /// Implement the Config interface.
@@ -24,10 +41,24 @@ func (config *SnowflakeConfig) OpenDB() (*sql.DB, error) {
 	sfConfig := gosnowflake.Config{
 		Account:   config.AccountId,
 		User:      config.User,
-		Password:  config.Pass,
 		Database:  config.Database,
 		Schema:    config.Schema,
 		Warehouse: config.Warehouse,
+		Role:      config.Role,
+	}
+	switch {
+	case config.PrivateKeyPath != "":
+		privateKey, err := loadPrivateKey(config.PrivateKeyPath, config.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sfConfig.Authenticator = gosnowflake.AuthTypeJwt
+		sfConfig.PrivateKey = privateKey
+	case config.OAuthToken != "":
+		sfConfig.Authenticator = gosnowflake.AuthTypeOAuth
+		sfConfig.Token = config.OAuthToken
+	default:
+		sfConfig.Password = config.Pass
 	}
 	dsn, err := gosnowflake.DSN(&sfConfig)
 	if err != nil {
@@ -44,3 +75,32 @@ func (config *SnowflakeConfig) OpenDB() (*sql.DB, error) {
 	log.Info("Snowflake connection established")
 	return db, nil
 }
+
+// loadPrivateKey loads the RSA private key from the PEM encoded PKCS#8 file, which may be encrypted, e.g.
+// generated by `openssl pkcs8 -topk8 -v2 aes256 -inform PEM -in rsa_key.pem -out rsa_key.p8`.
+func loadPrivateKey(path, passphrase string) (*rsa.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to read private key file")
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.Errorf("Failed to decode private key file %s, it should be PEM encoded", path)
+	}
+	var password []byte
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errors.Errorf("The private key file %s is encrypted, passphrase is required", path)
+		}
+		password = []byte(passphrase)
+	case "PRIVATE KEY":
+	default:
+		return nil, errors.Errorf("Unsupported private key type %s, the key should be in PKCS#8 format", block.Type)
+	}
+	privateKey, err := pkcs8.ParsePKCS8PrivateKeyRSA(block.Bytes, password)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to parse private key")
+	}
+	return privateKey, nil
+}
@@ -4,12 +4,16 @@ import (
 	"database/sql"
 	"fmt"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 )
@@ -21,47 +25,80 @@ type SnowflakeConnector struct {
 	db *sql.DB
 
 	stageName string
+	// storageURI is the location of the files to load. The files in the local
+	// file system (file://) are uploaded to the internal stage before loading.
+	storageURI *url.URL
+	// stageCredential is how the external stage accesses the storage.
+	stageCredential *StageCredential
+	// stageAWSCredential is the AWS credential written into the stage, it is updated when the credential rotates.
+	stageAWSCredential *credentials.Value
 
 	columns []cloudstorage.TableCol
+	// mergeKey is how the increment of the table is merged, it is chosen when the schema of the table
+	// is initialized and changed by DDLs, nil if it is not chosen.
+	mergeKey *tidbsql.MergeKey
 }
 
-func NewSnowflakeConnector(db *sql.DB, stageName string, storageURI *url.URL, credentials *credentials.Value) (*SnowflakeConnector, error) {
+func NewSnowflakeConnector(db *sql.DB, stageName string, storageURI *url.URL, stageCredential *StageCredential) (*SnowflakeConnector, error) {
 	// create stage
-	var err error
-	if storageURI.Host == "" {
-		err = CreateInternalStage(db, stageName)
-	} else {
-		stageUrl := fmt.Sprintf("%s://%s%s", storageURI.Scheme, storageURI.Host, storageURI.Path)
-		err = CreateExternalStage(db, stageName, stageUrl, credentials)
-	}
+	stageAWSCredential, err := CreateStage(db, stageName, storageURI, stageCredential)
 	if err != nil {
 		return nil, errors.Annotate(err, "Failed to create stage")
 	}
+	if err = CreateApplyLogTable(db); err != nil {
+		return nil, errors.Annotate(err, "Failed to create apply log table")
+	}
 
 	return &SnowflakeConnector{
-		db:        db,
-		stageName: stageName,
-		columns:   nil,
+		db:                 db,
+		stageName:          stageName,
+		storageURI:         storageURI,
+		stageCredential:    stageCredential,
+		stageAWSCredential: stageAWSCredential,
+		columns:            nil,
 	}, nil
 }
 
-func (sc *SnowflakeConnector) InitSchema(columns []cloudstorage.TableCol) error {
+// refreshStageCredential updates the stage if the AWS credential written into it has rotated.
+func (sc *SnowflakeConnector) refreshStageCredential() error {
+	if sc.stageAWSCredential == nil {
+		return nil
+	}
+	credValue, err := RefreshStageCredential(sc.db, sc.stageName, sc.stageCredential.AWSCredentials, sc.stageAWSCredential)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sc.stageAWSCredential = credValue
+	return nil
+}
+
+func (sc *SnowflakeConnector) InitSchema(table string, columns []cloudstorage.TableCol) error {
 	if len(sc.columns) != 0 {
 		return nil
 	}
 	if len(columns) == 0 {
 		return errors.New("Columns in schema is empty")
 	}
+	if err := sc.resetMergeKey(table, columns); err != nil {
+		return errors.Trace(err)
+	}
 	sc.columns = columns
 	log.Info("table columns initialized", zap.Any("Columns", columns))
 	return nil
 }
 
 func (sc *SnowflakeConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
-	if len(sc.columns) == 0 {
-		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
+	if len(sc.columns) == 0 && tidbsql.RequiresColumns(tableDef.Type) {
+		return errors.New("Columns not initialized, the schema of the table before the DDL is unknown")
 	}
-	ddls, err := GenDDLViaColumnsDiff(sc.columns, tableDef)
+	var constraints *TableConstraints
+	if tidbsql.RequiresColumns(tableDef.Type) {
+		var err error
+		if constraints, err = GetTableConstraints(sc.db, tableDef.Table); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	ddls, err := GenDDLViaColumnsDiff(sc.columns, tableDef, constraints)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -73,74 +110,163 @@ func (sc *SnowflakeConnector) ExecDDL(tableDef cloudstorage.TableDefinition) err
 	for _, ddl := range ddls {
 		_, err := sc.db.Exec(ddl)
 		if err != nil {
-			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
+			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
 			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
 		}
 	}
 	// update columns
 	sc.columns = tableDef.Columns
-	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), redact.Query("rewritten", strings.Join(ddls, "\n")))
+	sc.resetMergeKeyAfterDDL(tableDef)
 	return nil
 }
 
 func (sc *SnowflakeConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
-	createTableQuery, err := GenCreateSchema(sourceDatabase, sourceTable, sourceTiDBConn)
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Creating table in Snowflake", zap.String("query", createTableQuery))
+	pkColumns, uniqueKeys, err := tidbsql.GetTiDBTableKeys(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the table which can not be merged is refused before it is created
+	mergeKey := tidbsql.MergeKey{Strategy: tidbsql.MergeOnPrimaryKey, Columns: pkColumns}
+	if len(pkColumns) == 0 {
+		if mergeKey, err = tidbsql.GetMergeKey(tableColumns, uniqueKeys); err != nil {
+			return errors.Annotatef(err, "Failed to replicate table %s.%s", sourceDatabase, sourceTable)
+		}
+	}
+	createTableQuery, err := GenCreateTable(sourceTable, tableColumns, pkColumns, uniqueKeys)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in Snowflake", redact.Query("query", createTableQuery))
 	_, err = sc.db.Exec(createTableQuery)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	// the columns are used to convert the fields of the snapshot files
+	sc.columns = tableColumns
+	tidbsql.LogMergeKey(sourceTable, sc.mergeKey, mergeKey)
+	sc.mergeKey = &mergeKey
 	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
 	return nil
 }
 
 func (sc *SnowflakeConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
-	if err := LoadSnapshotFromStage(sc.db, targetTable, sc.stageName, filePrefix, onSnapshotLoadProgress); err != nil {
+	if err := sc.refreshStageCredential(); err != nil {
+		return errors.Trace(err)
+	}
+	if sc.storageURI.Scheme == "file" {
+		// filePrefix is relative to the root of the local file system, upload the
+		// files to the same path of the internal stage, so that they match the pattern.
+		if err := PutFileToStage(sc.db, "/"+filePrefix+"*.csv", sc.stageName, path.Dir(filePrefix)); err != nil {
+			return errors.Annotate(err, "Failed to upload snapshot files to stage")
+		}
+	}
+	if err := LoadSnapshotFromStage(sc.db, targetTable, sc.columns, sc.stageName, filePrefix, onSnapshotLoadProgress); err != nil {
 		return errors.Trace(err)
 	}
 	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
 	return nil
 }
 
-func (sc *SnowflakeConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+func (sc *SnowflakeConnector) IsIncrementLoaded(tableDef cloudstorage.TableDefinition, file coreinterfaces.IncrementFile) (bool, error) {
+	applied, err := IsFileApplied(sc.db, tableDef.Schema, tableDef.Table, file)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (sc *SnowflakeConnector) TrimApplyLog(schema, table string, commitTs uint64) error {
+	return errors.Trace(TrimApplyLog(sc.db, schema, table, commitTs))
+}
+
+func (sc *SnowflakeConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, file coreinterfaces.IncrementFile) error {
+	if sc.mergeKey == nil {
+		if err := sc.resetMergeKey(tableDef.Table, tableDef.Columns); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	mergeKey := *sc.mergeKey
+	if err := sc.refreshStageCredential(); err != nil {
+		return errors.Trace(err)
+	}
+	filePath := file.Path
 	if uri.Scheme == "file" {
 		// if the file is local, we need to upload it to stage first
-		putQuery := fmt.Sprintf(`PUT file://%s/%s '@%s/%s';`, uri.Path, filePath, sc.stageName, filePath)
-		_, err := sc.db.Exec(putQuery)
-		if err != nil {
+		if err := PutFileToStage(sc.db, path.Join(uri.Path, filePath), sc.stageName, filePath); err != nil {
 			return errors.Trace(err)
 		}
-		log.Debug("put file to stage", zap.String("query", putQuery))
 	}
-
-	// merge staged file into table
-	mergeQuery := GenMergeInto(tableDef, filePath, sc.stageName)
-	_, err := sc.db.Exec(mergeQuery)
+	// merge staged file into table and record it in the apply log atomically
+	tx, err := sc.db.Begin()
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Debug("merge staged file into table", zap.String("query", mergeQuery))
-
+	mergeQuery := GenMergeInto(tableDef, mergeKey, filePath, sc.stageName)
+	if _, err = tx.Exec(mergeQuery); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	log.Debug("merge staged file into table", redact.Query("query", mergeQuery))
+	if err = InsertApplyLog(tx, tableDef.Schema, tableDef.Table, file); err != nil {
+		_ = tx.Rollback()
+		return errors.Trace(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Trace(err)
+	}
 	if uri.Scheme == "file" {
 		// if the file is local, we need to remove it from stage
-		removeQuery := fmt.Sprintf(`REMOVE '@%s/%s';`, sc.stageName, filePath)
-		_, err = sc.db.Exec(removeQuery)
-		if err != nil {
+		if err = RemoveFromStage(sc.db, sc.stageName, filePath); err != nil {
 			return errors.Trace(err)
 		}
-		log.Debug("remove file from stage", zap.String("query", removeQuery))
 	}
-
 	log.Info("Successfully merge file", zap.String("file", filePath))
 	return nil
 }
 
-func (sc *SnowflakeConnector) Clone(stageName string, storageURI *url.URL, credentials *credentials.Value) (coreinterfaces.Connector, error) {
-	return NewSnowflakeConnector(sc.db, stageName, storageURI, credentials)
+// resetMergeKey chooses how the increment of the table is merged, with the columns of the table and the
+// unique constraints in Snowflake.
+func (sc *SnowflakeConnector) resetMergeKey(table string, columns []cloudstorage.TableCol) error {
+	var uniqueKeys []tidbsql.UniqueKey
+	if len(tidbsql.GetPKColumns(columns)) == 0 {
+		var err error
+		if uniqueKeys, err = GetUniqueKeys(sc.db, table); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	mergeKey, err := tidbsql.GetMergeKey(columns, uniqueKeys)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to choose the merge key of table %s", table)
+	}
+	tidbsql.LogMergeKey(table, sc.mergeKey, mergeKey)
+	sc.mergeKey = &mergeKey
+	return nil
+}
+
+// resetMergeKeyAfterDDL chooses the merge key again after the DDL is executed, since the DDL may change
+// the primary key, the unique keys or the nullability of their columns. The DDL has been executed, so
+// the merge key is chosen again before merging if it fails.
+func (sc *SnowflakeConnector) resetMergeKeyAfterDDL(tableDef cloudstorage.TableDefinition) {
+	switch tableDef.Type {
+	case timodel.ActionDropTable, timodel.ActionDropSchema, timodel.ActionCreateSchema:
+		sc.mergeKey = nil
+		return
+	}
+	if err := sc.resetMergeKey(tableDef.Table, tableDef.Columns); err != nil {
+		log.Warn("Failed to choose the merge key after DDL", zap.String("table", tableDef.Table), zap.Error(err))
+		sc.mergeKey = nil
+	}
+}
+
+func (sc *SnowflakeConnector) Clone(stageName string, storageURI *url.URL, _ *credentials.Value) (coreinterfaces.Connector, error) {
+	// the stage credential is shared, so that the rotated AWS credential is used by all the connectors
+	return NewSnowflakeConnector(sc.db, stageName, storageURI, sc.stageCredential)
 }
 
 func (sc *SnowflakeConnector) Close() {
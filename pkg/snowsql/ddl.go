@@ -39,7 +39,17 @@ func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
 	return strings.Join(strs, ", "), nil
 }
 
-func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+// TableConstraints are the primary key and unique constraints of a table in Snowflake.
+type TableConstraints struct {
+	// PrimaryKey is the name of the primary key constraint, empty if the table has no primary key.
+	PrimaryKey string
+	// UniqueKeys are the lower case names of the unique constraints.
+	UniqueKeys map[string]bool
+}
+
+// GenDDLViaColumnsDiff returns the DDLs to apply the DDL of TiDB in Snowflake. constraints are the
+// constraints of the table in Snowflake before the DDL, which are used to drop the keys.
+func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, constraints *TableConstraints) ([]string, error) {
 	if curTableDef.Type == timodel.ActionTruncateTable {
 		return []string{fmt.Sprintf("TRUNCATE TABLE %s", curTableDef.Table)}, nil
 	}
@@ -47,24 +57,60 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		return []string{fmt.Sprintf("DROP TABLE %s", curTableDef.Table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateTable {
-		return nil, errors.New("Received create table ddl, which should not happen") // FIXME: drop table and create table
-	}
-	if curTableDef.Type == timodel.ActionRenameTables {
-		return nil, errors.New("Received rename table ddl, new change data can not be capture by TiCDC any more." +
-			"If you want to rename table, please start a new task to capture the new table") // FIXME: rename table to new table and rename back
+		indexChange, err := tidbsql.GetIndexChange(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// the table which can not be merged is refused before it is created
+		if _, err = tidbsql.GetMergeKey(curTableDef.Columns, indexChange.AddedUniqueKeys); err != nil {
+			return nil, errors.Annotatef(err, "Failed to replicate table %s.%s", curTableDef.Schema, curTableDef.Table)
+		}
+		// The table is empty when created in TiDB, so the table left in Snowflake is replaced
+		createTable, err := GenCreateTable(curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns), indexChange.AddedUniqueKeys)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{createTable}, nil
 	}
-	if curTableDef.Type == timodel.ActionDropSchema {
-		return []string{fmt.Sprintf("DROP SCHEMA %s", curTableDef.Schema)}, nil
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", oldTable.Name, curTableDef.Table)}, nil
 	}
-	if curTableDef.Type == timodel.ActionCreateSchema {
-		return nil, errors.New("Received create schema ddl, which should not happen") // FIXME: drop schema and create schema
+	// The tables are created in the Snowflake schema of the connector, so nothing is executed for the
+	// DDLs of databases. The tables of a dropped database are kept and should be dropped manually.
+	if curTableDef.Type == timodel.ActionCreateSchema || curTableDef.Type == timodel.ActionDropSchema {
+		return nil, nil
 	}
 
 	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	indexChange, err := tidbsql.GetIndexChange(curTableDef)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if constraints == nil {
+		constraints = &TableConstraints{}
+	}
+	pkChanged := tidbsql.IsPKChanged(columnDiff)
+
 	ddls := make([]string, 0, len(columnDiff))
+	// The keys are dropped before the columns are changed, and added after that
+	if pkChanged && constraints.PrimaryKey != "" {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY;", curTableDef.Table))
+	}
+	droppedKeys := make(map[string]bool)
+	for _, index := range indexChange.DroppedIndexes {
+		// The dropped index is not a constraint in Snowflake if it is not unique
+		if constraints.UniqueKeys[strings.ToLower(index)] {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", curTableDef.Table, index))
+			droppedKeys[strings.ToLower(index)] = true
+		}
+	}
 	for _, item := range columnDiff {
 		ddl := ""
 		switch item.Action {
@@ -78,12 +124,14 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		case tidbsql.DROP_COLUMN:
 			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", curTableDef.Table, item.Before.Name)
 		case tidbsql.MODIFY_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s MODIFY ", curTableDef.Table)
 			modifyStr, err := GetColumnModifyString(&item)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
-			ddl += modifyStr
+			// empty if only the primary key is changed
+			if modifyStr != "" {
+				ddl += fmt.Sprintf("ALTER TABLE %s MODIFY %s", curTableDef.Table, modifyStr)
+			}
 		case tidbsql.RENAME_COLUMN:
 			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", curTableDef.Table, item.Before.Name, item.After.Name)
 		default:
@@ -95,7 +143,16 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		}
 	}
 
-	// TODO: handle primary key
+	if pkColumns := tidbsql.GetPKColumns(curTableDef.Columns); pkChanged && len(pkColumns) > 0 {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", curTableDef.Table, strings.Join(pkColumns, ", ")))
+	}
+	for _, key := range indexChange.AddedUniqueKeys {
+		// The constraint has been added if the DDL is retried
+		if constraints.UniqueKeys[strings.ToLower(key.Name)] && !droppedKeys[strings.ToLower(key.Name)] {
+			continue
+		}
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s);", curTableDef.Table, key.Name, strings.Join(key.Columns, ", ")))
+	}
 	return ddls, nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"github.com/stretchr/testify/require"
 )
@@ -68,7 +69,89 @@ func TestGenDDLViaColumnsDiff(t *testing.T) {
 		"ALTER TABLE test_table ADD COLUMN gender VARCHAR(10);",
 	}
 
-	ddl, err := snowsql.GenDDLViaColumnsDiff(prevColumns, curTableDef)
+	ddl, err := snowsql.GenDDLViaColumnsDiff(prevColumns, curTableDef, nil)
 	require.NoError(t, err)
 	require.ElementsMatch(t, expectedDDLs, ddl)
 }
+
+func TestGenDDLViaColumnsDiffCreateTable(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE test_table (id INT PRIMARY KEY, name VARCHAR(10), UNIQUE KEY uk_name (name))",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+			{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "10"},
+		},
+	}
+	ddls, err := snowsql.GenDDLViaColumnsDiff(nil, tableDef, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"CREATE OR REPLACE TABLE test_table (\n    id INT NOT NULL,\n    name VARCHAR(10),\n    PRIMARY KEY (id),\n    CONSTRAINT uk_name UNIQUE (name)\n)",
+	}, ddls)
+
+	// The table without primary key or unique key of NOT NULL columns is refused
+	tableDef.Query = "CREATE TABLE test_table (id INT NOT NULL, name VARCHAR(10), UNIQUE KEY uk_name (name))"
+	tableDef.Columns[0].IsPK = ""
+	_, err = snowsql.GenDDLViaColumnsDiff(nil, tableDef, nil)
+	require.Error(t, err)
+	tableDef.Query = "CREATE TABLE test_table (id INT NOT NULL UNIQUE, name VARCHAR(10))"
+	ddls, err = snowsql.GenDDLViaColumnsDiff(nil, tableDef, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"CREATE OR REPLACE TABLE test_table (\n    id INT NOT NULL,\n    name VARCHAR(10),\n    CONSTRAINT id UNIQUE (id)\n)",
+	}, ddls)
+
+	ddls, err = snowsql.GenDDLViaColumnsDiff(nil, cloudstorage.TableDefinition{
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateSchema,
+		Query:  "CREATE DATABASE test_schema",
+	}, nil)
+	require.NoError(t, err)
+	require.Empty(t, ddls)
+
+	ddls, err = snowsql.GenDDLViaColumnsDiff(nil, cloudstorage.TableDefinition{
+		Schema: "test_schema",
+		Type:   timodel.ActionDropSchema,
+		Query:  "DROP DATABASE test_schema",
+	}, nil)
+	require.NoError(t, err)
+	require.Empty(t, ddls)
+}
+
+func TestGenDDLViaColumnsDiffKeys(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "code", Tp: "VARCHAR", Precision: "10", Nullable: "false"},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionAddPrimaryKey,
+		Query:  "ALTER TABLE test_table DROP PRIMARY KEY, ADD PRIMARY KEY (code), DROP INDEX uk_code, ADD UNIQUE KEY uk_id (id)",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false"},
+			{ID: "2", Name: "code", Tp: "VARCHAR", Precision: "10", Nullable: "false", IsPK: "true"},
+		},
+	}
+
+	ddls, err := snowsql.GenDDLViaColumnsDiff(prevColumns, curTableDef, &snowsql.TableConstraints{
+		PrimaryKey: "SYS_CONSTRAINT_1",
+		UniqueKeys: map[string]bool{"uk_code": true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"ALTER TABLE test_table DROP PRIMARY KEY;",
+		"ALTER TABLE test_table DROP CONSTRAINT uk_code;",
+		"ALTER TABLE test_table ADD PRIMARY KEY (code);",
+		"ALTER TABLE test_table ADD CONSTRAINT uk_id UNIQUE (id);",
+	}, ddls)
+
+	// The DDL is retried after the keys are changed, except the primary key
+	ddls, err = snowsql.GenDDLViaColumnsDiff(prevColumns, curTableDef, &snowsql.TableConstraints{
+		UniqueKeys: map[string]bool{"uk_id": true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE test_table ADD PRIMARY KEY (code);"}, ddls)
+}
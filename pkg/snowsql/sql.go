@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/redact"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
@@ -22,15 +24,22 @@ import (
 	"golang.org/x/exp/slices"
 )
 
-func CreateExternalStage(db *sql.DB, stageName, s3WorkspaceURL string, cred *credentials.Value) error {
+// CreateExternalStage creates a stage on S3 or GCS. For S3 compatible storage, the url should
+// be like s3compat://bucket/path and the endpoint should be the host of the storage.
+func CreateExternalStage(db *sql.DB, stageName, s3WorkspaceURL, endpoint string, cred *credentials.Value) error {
+	endpointStat := ""
+	if endpoint != "" {
+		endpointStat = fmt.Sprintf("\nENDPOINT = '%s'", EscapeString(endpoint))
+	}
 	sql, err := formatter.Format(`
 CREATE OR REPLACE STAGE {stageName}
-URL = '{url}'
+URL = '{url}'{endpointStat}
 CREDENTIALS = (AWS_KEY_ID = '{awsKeyId}' AWS_SECRET_KEY = '{awsSecretKey}' AWS_TOKEN = '{awsToken}')
 FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"');
 	`, formatter.Named{
 		"stageName":    EscapeString(stageName),
 		"url":          EscapeString(s3WorkspaceURL),
+		"endpointStat": endpointStat,
 		"awsKeyId":     EscapeString(cred.AccessKeyID),
 		"awsSecretKey": EscapeString(cred.SecretAccessKey),
 		"awsToken":     EscapeString(cred.SessionToken),
@@ -42,6 +51,25 @@ FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OP
 	return err
 }
 
+// CreateAzureExternalStage creates a stage on Azure Blob Storage, Snowflake only accepts a SAS token to access it.
+func CreateAzureExternalStage(db *sql.DB, stageName, azureWorkspaceURL, sasToken string) error {
+	sql, err := formatter.Format(`
+CREATE OR REPLACE STAGE {stageName}
+URL = '{url}'
+CREDENTIALS = (AZURE_SAS_TOKEN = '{sasToken}')
+FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"');
+	`, formatter.Named{
+		"stageName": EscapeString(stageName),
+		"url":       EscapeString(azureWorkspaceURL),
+		"sasToken":  EscapeString(sasToken),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(sql)
+	return err
+}
+
 func CreateInternalStage(db *sql.DB, stageName string) error {
 	sql, err := formatter.Format(`
 CREATE OR REPLACE STAGE {stageName}
@@ -69,6 +97,40 @@ DROP STAGE IF EXISTS {stageName};
 	return err
 }
 
+// PutFileToStage uploads the local files matching localPath (wildcards are allowed)
+// into the stagePath of the internal stage.
+func PutFileToStage(db *sql.DB, localPath, stageName, stagePath string) error {
+	sql, err := formatter.Format(`
+PUT 'file://{localPath}' '@{stageName}/{stagePath}';
+`, formatter.Named{
+		"localPath": EscapeString(localPath),
+		"stageName": EscapeString(stageName),
+		"stagePath": EscapeString(stagePath),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Debug("put file to stage", redact.Query("query", sql))
+	_, err = db.Exec(sql)
+	return err
+}
+
+// RemoveFromStage removes the files under the stagePath of the internal stage.
+func RemoveFromStage(db *sql.DB, stageName, stagePath string) error {
+	sql, err := formatter.Format(`
+REMOVE '@{stageName}/{stagePath}';
+`, formatter.Named{
+		"stageName": EscapeString(stageName),
+		"stagePath": EscapeString(stagePath),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Debug("remove file from stage", redact.Query("query", sql))
+	_, err = db.Exec(sql)
+	return err
+}
+
 func GetServerSideTimestamp(db *sql.DB) (string, error) {
 	var result string
 	err := db.QueryRow("SELECT CURRENT_TIMESTAMP").Scan(&result)
@@ -78,7 +140,9 @@ func GetServerSideTimestamp(db *sql.DB) (string, error) {
 	return result, nil
 }
 
-func LoadSnapshotFromStage(db *sql.DB, targetTable, stageName, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+// LoadSnapshotFromStage loads the snapshot files into the table. The columns are the columns of the table in TiDB,
+// which are used to convert the fields of the files if needed.
+func LoadSnapshotFromStage(db *sql.DB, targetTable string, columns []cloudstorage.TableCol, stageName, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
 	// The timestamp and reqId is used to monitor the progress of COPY INTO query.
 	ts, err := GetServerSideTimestamp(db)
 	if err != nil {
@@ -86,17 +150,29 @@ func LoadSnapshotFromStage(db *sql.DB, targetTable, stageName, filePrefix string
 	}
 	reqId := gosnowflake.NewUUID()
 
+	source := fmt.Sprintf("@%s", EscapeString(stageName))
+	if NeedsValueConversion(columns) {
+		names := make([]string, 0, len(columns))
+		fields := make([]string, 0, len(columns))
+		for i, col := range columns {
+			names = append(names, col.Name)
+			fields = append(fields, GetColumnValueExpr(col, fmt.Sprintf("$%d", i+1)))
+		}
+		source = fmt.Sprintf("(\n  SELECT %s\n  FROM %s\n)", strings.Join(fields, ", "), source)
+		targetTable = fmt.Sprintf("%s (%s)", targetTable, strings.Join(names, ", "))
+	}
+
 	sql, err := formatter.Format(`
 COPY INTO {targetTable}
 -- tidb2dw-reqid={reqId}
-FROM @{stageName}
+FROM {source}
 FILE_FORMAT = (TYPE = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"')
 PATTERN = '{filePrefix}.*'
 ON_ERROR = CONTINUE;
 `, formatter.Named{
 		"reqId":       EscapeString(reqId.String()),
 		"targetTable": EscapeString(targetTable),
-		"stageName":   EscapeString(stageName),
+		"source":      source,
 		"filePrefix":  EscapeString(regexp.QuoteMeta(filePrefix)), // TODO: Verify
 	})
 	if err != nil {
@@ -164,13 +240,10 @@ func GetDefaultValueString(val string) string {
 	return fmt.Sprint(val)
 }
 
-func GenCreateSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) (string, error) {
-	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
-	if err != nil {
-		return "", errors.Trace(err)
-	}
-	columnRows := make([]string, 0, len(tableColumns))
-	for _, column := range tableColumns {
+// GenCreateTable returns the statement to create or replace the table with the columns and the keys.
+func GenCreateTable(tableName string, columns []cloudstorage.TableCol, pkColumns []string, uniqueKeys []tidbsql.UniqueKey) (string, error) {
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
 		row, err := GetSnowflakeColumnString(column)
 		if err != nil {
 			return "", errors.Trace(err)
@@ -178,37 +251,14 @@ func GenCreateSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *
 		columnRows = append(columnRows, row)
 	}
 
-	indexQuery := fmt.Sprintf("SHOW INDEX FROM `%s`.`%s`", sourceDatabase, sourceTable) // FIXME: Escape
-	indexRows, err := sourceTiDBConn.QueryContext(context.Background(), indexQuery)
-	if err != nil {
-		return "", errors.Trace(err)
-	}
-	indexResults, err := export.GetSpecifiedColumnValuesAndClose(indexRows, "KEY_NAME", "COLUMN_NAME", "SEQ_IN_INDEX")
-	if err != nil {
-		return "", errors.Trace(err)
-	}
-
-	snowflakePKColumns := make([]string, 0)
-	// Sort by key_name, seq_in_index
-	slices.SortFunc(indexResults, func(i, j []string) bool {
-		if i[0] == j[0] {
-			return i[2] < j[2] // Sort by seq_in_index
-		}
-		return i[0] < j[0] // Sort by key_name
-	})
-	for _, oneRow := range indexResults {
-		keyName, columnName := oneRow[0], oneRow[1]
-		if keyName == "PRIMARY" {
-			snowflakePKColumns = append(snowflakePKColumns, columnName)
-		}
-	}
-
-	// TODO: Support unique key
-
-	sqlRows := make([]string, 0, len(columnRows)+1)
+	sqlRows := make([]string, 0, len(columnRows)+len(uniqueKeys)+1)
 	sqlRows = append(sqlRows, columnRows...)
-	if len(snowflakePKColumns) > 0 {
-		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(snowflakePKColumns, ", ")))
+	if len(pkColumns) > 0 {
+		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(pkColumns, ", ")))
+	}
+	// The constraints are named after the index in TiDB, so that they can be dropped by DROP INDEX
+	for _, key := range uniqueKeys {
+		sqlRows = append(sqlRows, fmt.Sprintf("CONSTRAINT %s UNIQUE (%s)", key.Name, strings.Join(key.Columns, ", ")))
 	}
 	// Add idents
 	for i := 0; i < len(sqlRows); i++ {
@@ -216,27 +266,23 @@ func GenCreateSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *
 	}
 
 	sql := []string{}
-	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s (`, sourceTable)) // TODO: Escape
+	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s (`, tableName)) // TODO: Escape
 	sql = append(sql, strings.Join(sqlRows, ",\n"))
 	sql = append(sql, ")")
 
 	return strings.Join(sql, "\n"), nil
 }
 
-func GenMergeInto(tableDef cloudstorage.TableDefinition, filePath string, stageName string) string {
+func GenMergeInto(tableDef cloudstorage.TableDefinition, mergeKey tidbsql.MergeKey, filePath string, stageName string) string {
 	selectStat := make([]string, 0, len(tableDef.Columns)+1)
 	selectStat = append(selectStat, `$1 AS "METADATA$FLAG"`)
 	for i, col := range tableDef.Columns {
-		selectStat = append(selectStat, fmt.Sprintf(`$%d AS %s`, i+5, col.Name))
+		selectStat = append(selectStat, fmt.Sprintf(`%s AS %s`, GetColumnValueExpr(col, fmt.Sprintf("$%d", i+5)), col.Name))
 	}
 
-	pkColumn := make([]string, 0)
-	onStat := make([]string, 0)
-	for _, col := range tableDef.Columns {
-		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-			onStat = append(onStat, fmt.Sprintf(`T.%s = S.%s`, col.Name, col.Name))
-		}
+	onStat := make([]string, 0, len(mergeKey.Columns))
+	for _, name := range mergeKey.Columns {
+		onStat = append(onStat, fmt.Sprintf(`T.%s = S.%s`, name, name))
 	}
 
 	updateStat := make([]string, 0, len(tableDef.Columns))
@@ -274,7 +320,7 @@ func GenMergeInto(tableDef cloudstorage.TableDefinition, filePath string, stageN
 		strings.Join(selectStat, ",\n"),
 		stageName,
 		filePath,
-		strings.Join(pkColumn, ", "),
+		strings.Join(mergeKey.Columns, ", "),
 		strings.Join(onStat, " AND "),
 		strings.Join(updateStat, ", "),
 		strings.Join(insertStat, ", "),
@@ -282,3 +328,104 @@ func GenMergeInto(tableDef cloudstorage.TableDefinition, filePath string, stageN
 
 	return mergeQuery
 }
+
+// ApplyLogTableName is the table which records the increment files loaded into Snowflake.
+const ApplyLogTableName = "TIDB2DW_APPLY_LOG"
+
+func CreateApplyLogTable(db *sql.DB) error {
+	sql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    SCHEMA_NAME VARCHAR NOT NULL,
+    TABLE_NAME VARCHAR NOT NULL,
+    FILE_PATH VARCHAR NOT NULL,
+    CHECKSUM VARCHAR NOT NULL,
+    MIN_COMMIT_TS NUMBER(20, 0),
+    MAX_COMMIT_TS NUMBER(20, 0),
+    APPLIED_AT TIMESTAMP_LTZ DEFAULT CURRENT_TIMESTAMP()
+);`, ApplyLogTableName)
+	_, err := db.Exec(sql)
+	return err
+}
+
+// IsFileApplied returns whether the increment file with the same checksum has been recorded in the apply log.
+func IsFileApplied(db *sql.DB, schemaName, tableName string, file coreinterfaces.IncrementFile) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE SCHEMA_NAME = ? AND TABLE_NAME = ? AND FILE_PATH = ? AND CHECKSUM = ?`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum).Scan(&count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return count > 0, nil
+}
+
+// TrimApplyLog deletes the records of the table in the apply log whose max commit ts is less than commitTs,
+// the files of them are covered by the persisted checkpoint of the consumer.
+func TrimApplyLog(db *sql.DB, schemaName, tableName string, commitTs uint64) error {
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE SCHEMA_NAME = ? AND TABLE_NAME = ? AND MAX_COMMIT_TS < ?`, ApplyLogTableName),
+		schemaName, tableName, fmt.Sprint(commitTs))
+	return errors.Trace(err)
+}
+
+// InsertApplyLog records the increment file in the apply log, it should be executed
+// in the same transaction of loading the file.
+func InsertApplyLog(tx *sql.Tx, schemaName, tableName string, file coreinterfaces.IncrementFile) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (SCHEMA_NAME, TABLE_NAME, FILE_PATH, CHECKSUM, MIN_COMMIT_TS, MAX_COMMIT_TS) VALUES (?, ?, ?, ?, ?, ?)`, ApplyLogTableName),
+		schemaName, tableName, file.Path, file.Checksum, fmt.Sprint(file.MinCommitTs), fmt.Sprint(file.MaxCommitTs))
+	return err
+}
+
+// GetTableConstraints returns the primary key and unique constraints of the table in the current schema.
+func GetTableConstraints(db *sql.DB, tableName string) (*TableConstraints, error) {
+	rows, err := db.Query(`SELECT CONSTRAINT_NAME, CONSTRAINT_TYPE FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS
+WHERE TABLE_SCHEMA = CURRENT_SCHEMA() AND TABLE_NAME = UPPER(?)`, tableName)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to get the constraints of table %s", tableName)
+	}
+	defer rows.Close()
+	constraints := &TableConstraints{UniqueKeys: make(map[string]bool)}
+	for rows.Next() {
+		var name, tp string
+		if err = rows.Scan(&name, &tp); err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch tp {
+		case "PRIMARY KEY":
+			constraints.PrimaryKey = name
+		case "UNIQUE":
+			constraints.UniqueKeys[strings.ToLower(name)] = true
+		}
+	}
+	return constraints, errors.Trace(rows.Err())
+}
+
+// GetUniqueKeys returns the unique constraints of the table, the column names are in upper case
+// if they are not quoted when the table is created.
+func GetUniqueKeys(db *sql.DB, tableName string) ([]tidbsql.UniqueKey, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW UNIQUE KEYS IN TABLE %s", tableName))
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to get the unique keys of table %s", tableName)
+	}
+	results, err := export.GetSpecifiedColumnValuesAndClose(rows, "CONSTRAINT_NAME", "COLUMN_NAME", "KEY_SEQUENCE")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Sort by constraint_name, key_sequence
+	slices.SortFunc(results, func(i, j []string) bool {
+		if i[0] == j[0] {
+			seqI, _ := strconv.Atoi(i[2])
+			seqJ, _ := strconv.Atoi(j[2])
+			return seqI < seqJ
+		}
+		return i[0] < j[0]
+	})
+	uniqueKeys := make([]tidbsql.UniqueKey, 0)
+	for _, oneRow := range results {
+		name, column := oneRow[0], oneRow[1]
+		if len(uniqueKeys) > 0 && uniqueKeys[len(uniqueKeys)-1].Name == name {
+			uniqueKeys[len(uniqueKeys)-1].Columns = append(uniqueKeys[len(uniqueKeys)-1].Columns, column)
+			continue
+		}
+		uniqueKeys = append(uniqueKeys, tidbsql.UniqueKey{Name: name, Columns: []string{column}})
+	}
+	return uniqueKeys, nil
+}
@@ -0,0 +1,164 @@
+package snowsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"gitlab.com/tymonx/go-formatter/formatter"
+	"go.uber.org/zap"
+)
+
+// credentialRefreshWindow is how long before the temporary credential expires it is refreshed,
+// so that the credential of the stage does not expire in the middle of a COPY or MERGE.
+const credentialRefreshWindow = 10 * time.Minute
+
+// StageCredential is how the external stage accesses the storage. A storage integration or an AWS role
+// is preferred, since no secret is written into the stage DDL and so the query history of Snowflake.
+type StageCredential struct {
+	// StorageIntegration is the name of an existing storage integration.
+	StorageIntegration string
+	// AWSRoleARN is the AWS role assumed by Snowflake to access the S3 bucket.
+	AWSRoleARN string
+	// AWSCredentials provides the AWS credential written into the stage, it is used if neither
+	// StorageIntegration nor AWSRoleARN is specified. The stage is updated when the credential rotates.
+	AWSCredentials *credentials.Credentials
+	// AzureCredential provides the account and the SAS token of the stage on Azure Blob Storage.
+	AzureCredential *storageutil.AzureCredential
+}
+
+// CreateStage creates the stage of the storage, and returns the AWS credential written into the stage if any.
+// The files in the local file system are uploaded into an internal stage.
+func CreateStage(db *sql.DB, stageName string, storageURI *url.URL, stageCred *StageCredential) (*credentials.Value, error) {
+	if storageURI.Host == "" {
+		return nil, errors.Trace(CreateInternalStage(db, stageName))
+	}
+
+	var stageUrl string
+	s3Options := storageutil.GetS3Options(storageURI)
+	if storageutil.IsAzureScheme(storageURI.Scheme) {
+		if stageCred.AzureCredential == nil {
+			return nil, errors.New("Azure credential is required to create a stage on Azure Blob Storage")
+		}
+		stageUrl = stageCred.AzureCredential.BlobURL(storageURI.Host, storageURI.Path)
+	} else {
+		scheme := storageURI.Scheme
+		if scheme == "s3" && s3Options.Endpoint != "" {
+			// Snowflake accesses S3 compatible storage by the s3compat scheme
+			scheme = "s3compat"
+		}
+		stageUrl = fmt.Sprintf("%s://%s%s", scheme, storageURI.Host, storageURI.Path)
+	}
+
+	switch {
+	case stageCred.StorageIntegration != "":
+		return nil, errors.Trace(CreateExternalStageWithIntegration(db, stageName, stageUrl, stageCred.StorageIntegration))
+	case stageCred.AWSRoleARN != "":
+		if storageURI.Scheme != "s3" {
+			return nil, errors.Errorf("AWS role can only be used to access S3, got storage %s", storageURI.String())
+		}
+		return nil, errors.Trace(CreateExternalStageWithRole(db, stageName, stageUrl, stageCred.AWSRoleARN))
+	case storageutil.IsAzureScheme(storageURI.Scheme):
+		if stageCred.AzureCredential.SASToken == "" {
+			return nil, errors.New("AZURE_STORAGE_SAS_TOKEN or a storage integration is required to create a stage on Azure Blob Storage")
+		}
+		return nil, errors.Trace(CreateAzureExternalStage(db, stageName, stageUrl, stageCred.AzureCredential.SASToken))
+	}
+
+	credValue, err := getAWSCredential(stageCred.AWSCredentials)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = CreateExternalStage(db, stageName, stageUrl, s3Options.EndpointHost(), credValue); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return credValue, nil
+}
+
+// RefreshStageCredential updates the AWS credential of the stage if it has rotated since last time,
+// and returns the current credential.
+func RefreshStageCredential(db *sql.DB, stageName string, creds *credentials.Credentials, last *credentials.Value) (*credentials.Value, error) {
+	credValue, err := getAWSCredential(creds)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if *credValue == *last {
+		return last, nil
+	}
+	sql, err := formatter.Format(`
+ALTER STAGE {stageName} SET
+CREDENTIALS = (AWS_KEY_ID = '{awsKeyId}' AWS_SECRET_KEY = '{awsSecretKey}' AWS_TOKEN = '{awsToken}');
+`, formatter.Named{
+		"stageName":    EscapeString(stageName),
+		"awsKeyId":     EscapeString(credValue.AccessKeyID),
+		"awsSecretKey": EscapeString(credValue.SecretAccessKey),
+		"awsToken":     EscapeString(credValue.SessionToken),
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err = db.Exec(sql); err != nil {
+		return nil, errors.Annotate(err, "Failed to update the credential of stage")
+	}
+	log.Info("The credential of stage is refreshed", zap.String("stage", stageName), zap.String("accessKeyID", credValue.AccessKeyID))
+	return credValue, nil
+}
+
+// getAWSCredential returns the current AWS credential, it is refreshed in advance if it will expire soon.
+func getAWSCredential(creds *credentials.Credentials) (*credentials.Value, error) {
+	if creds == nil {
+		return &credentials.Value{}, nil
+	}
+	// The providers which never expire, e.g. the environment variables, return an error.
+	if expiresAt, err := creds.ExpiresAt(); err == nil && time.Until(expiresAt) < credentialRefreshWindow {
+		creds.Expire()
+	}
+	credValue, err := creds.Get()
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to resolve AWS credential")
+	}
+	return &credValue, nil
+}
+
+// CreateExternalStageWithIntegration creates a stage which accesses the storage through an existing storage integration.
+func CreateExternalStageWithIntegration(db *sql.DB, stageName, workspaceURL, integration string) error {
+	sql, err := formatter.Format(`
+CREATE OR REPLACE STAGE {stageName}
+URL = '{url}'
+STORAGE_INTEGRATION = {integration}
+FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"');
+	`, formatter.Named{
+		"stageName":   EscapeString(stageName),
+		"url":         EscapeString(workspaceURL),
+		"integration": EscapeString(integration),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(sql)
+	return err
+}
+
+// CreateExternalStageWithRole creates a stage which accesses the S3 bucket by assuming the AWS role.
+func CreateExternalStageWithRole(db *sql.DB, stageName, s3WorkspaceURL, roleARN string) error {
+	sql, err := formatter.Format(`
+CREATE OR REPLACE STAGE {stageName}
+URL = '{url}'
+CREDENTIALS = (AWS_ROLE = '{roleARN}')
+FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"');
+	`, formatter.Named{
+		"stageName": EscapeString(stageName),
+		"url":       EscapeString(s3WorkspaceURL),
+		"roleARN":   EscapeString(roleARN),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(sql)
+	return err
+}
@@ -37,6 +37,21 @@ var TiDB2SnowflakeTypeMap map[string]string = map[string]string{
 	"datetime":   "DATETIME",
 	"timestamp":  "TIMESTAMP",
 	"time":       "TIME",
+	"json":       "VARIANT",
+	"enum":       "VARCHAR",
+	"set":        "VARCHAR",
+	"bit":        "NUMBER",
+	"year":       "SMALLINT",
+	"vector":     "ARRAY",
+	// The spatial values are kept as binary
+	"geometry":           "BINARY",
+	"point":              "BINARY",
+	"linestring":         "BINARY",
+	"polygon":            "BINARY",
+	"multipoint":         "BINARY",
+	"multilinestring":    "BINARY",
+	"multipolygon":       "BINARY",
+	"geometrycollection": "BINARY",
 }
 
 func GetSnowflakeTypeString(column cloudstorage.TableCol) (string, error) {
@@ -44,6 +59,13 @@ func GetSnowflakeTypeString(column cloudstorage.TableCol) (string, error) {
 	switch tp {
 	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob":
 		return fmt.Sprintf("%s %s", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
+	case "json", "enum", "set", "year", "vector":
+		return fmt.Sprintf("%s %s", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
+	case "geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		return fmt.Sprintf("%s %s", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
+	case "bit":
+		// BIT(64) may exceed the range of BIGINT
+		return fmt.Sprintf("%s %s(20, 0)", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
 	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
 		return fmt.Sprintf("%s %s", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
 	case "varchar", "char", "binary", "varbinary":
@@ -56,3 +78,25 @@ func GetSnowflakeTypeString(column cloudstorage.TableCol) (string, error) {
 		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
 	}
 }
+
+// GetColumnValueExpr returns the expression which converts the field of a staged CSV file, e.g. $5,
+// to the Snowflake type of the column.
+func GetColumnValueExpr(column cloudstorage.TableCol, field string) string {
+	switch strings.ToLower(column.Tp) {
+	case "json", "vector":
+		// The value is the JSON text, which is loaded as a string into VARIANT without parsing
+		return fmt.Sprintf("PARSE_JSON(%s)", field)
+	default:
+		return field
+	}
+}
+
+// NeedsValueConversion returns whether any of the columns can not be loaded from the CSV field as it is.
+func NeedsValueConversion(columns []cloudstorage.TableCol) bool {
+	for _, column := range columns {
+		if GetColumnValueExpr(column, "$1") != "$1" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package snowsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSnowflakeTypeString(t *testing.T) {
+	cases := []struct {
+		column   cloudstorage.TableCol
+		expected string
+	}{
+		{cloudstorage.TableCol{Name: "c", Tp: "JSON"}, "c VARIANT"},
+		{cloudstorage.TableCol{Name: "c", Tp: "ENUM"}, "c VARCHAR"},
+		{cloudstorage.TableCol{Name: "c", Tp: "SET"}, "c VARCHAR"},
+		{cloudstorage.TableCol{Name: "c", Tp: "BIT", Precision: "1"}, "c NUMBER(20, 0)"},
+		{cloudstorage.TableCol{Name: "c", Tp: "YEAR"}, "c SMALLINT"},
+		{cloudstorage.TableCol{Name: "c", Tp: "VECTOR"}, "c ARRAY"},
+		{cloudstorage.TableCol{Name: "c", Tp: "GEOMETRY"}, "c BINARY"},
+		{cloudstorage.TableCol{Name: "c", Tp: "POINT"}, "c BINARY"},
+		{cloudstorage.TableCol{Name: "c", Tp: "VARCHAR", Precision: "20"}, "c VARCHAR(20)"},
+	}
+	for _, c := range cases {
+		tp, err := snowsql.GetSnowflakeTypeString(c.column)
+		require.NoError(t, err, c.column.Tp)
+		require.Equal(t, c.expected, tp, c.column.Tp)
+	}
+
+	_, err := snowsql.GetSnowflakeTypeString(cloudstorage.TableCol{Name: "c", Tp: "UNKNOWN"})
+	require.Error(t, err)
+}
+
+func TestGetColumnValueExpr(t *testing.T) {
+	require.Equal(t, "PARSE_JSON($5)", snowsql.GetColumnValueExpr(cloudstorage.TableCol{Name: "c", Tp: "JSON"}, "$5"))
+	require.Equal(t, "PARSE_JSON($5)", snowsql.GetColumnValueExpr(cloudstorage.TableCol{Name: "c", Tp: "VECTOR"}, "$5"))
+	// ENUM, SET, BIT and YEAR are written as the text of their values
+	for _, tp := range []string{"ENUM", "SET", "BIT", "YEAR", "INT"} {
+		require.Equal(t, "$5", snowsql.GetColumnValueExpr(cloudstorage.TableCol{Name: "c", Tp: tp}, "$5"), tp)
+	}
+
+	require.False(t, snowsql.NeedsValueConversion([]cloudstorage.TableCol{{Name: "a", Tp: "INT"}, {Name: "b", Tp: "ENUM"}}))
+	require.True(t, snowsql.NeedsValueConversion([]cloudstorage.TableCol{{Name: "a", Tp: "INT"}, {Name: "b", Tp: "JSON"}}))
+}
@@ -0,0 +1,54 @@
+package storageutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// The environment variables of the Azure Blob Storage credential. AZURE_STORAGE_ACCOUNT and
+// AZURE_STORAGE_KEY are also read by the storage library of dumpling and TiCDC.
+const (
+	azureAccountEnv  = "AZURE_STORAGE_ACCOUNT"
+	azureKeyEnv      = "AZURE_STORAGE_KEY"
+	azureSASTokenEnv = "AZURE_STORAGE_SAS_TOKEN"
+)
+
+// AzureCredential is the credential to access Azure Blob Storage.
+type AzureCredential struct {
+	AccountName string
+	// AccountKey is used to read and write the storage by tidb2dw, dumpling and TiCDC.
+	AccountKey string
+	// SASToken is used by the data warehouse to read the storage, e.g. a Snowflake external stage.
+	SASToken string
+}
+
+// IsAzureScheme returns whether the scheme of the storage uri is Azure Blob Storage.
+func IsAzureScheme(scheme string) bool {
+	return scheme == "azure" || scheme == "azblob"
+}
+
+// GetAzureCredentialFromEnv resolves the Azure Blob Storage credential from the environment.
+func GetAzureCredentialFromEnv() (*AzureCredential, error) {
+	cred := &AzureCredential{
+		AccountName: os.Getenv(azureAccountEnv),
+		AccountKey:  os.Getenv(azureKeyEnv),
+		// The token may be copied from the portal with the leading '?'
+		SASToken: strings.TrimPrefix(os.Getenv(azureSASTokenEnv), "?"),
+	}
+	if cred.AccountName == "" {
+		return nil, errors.Errorf("Failed to resolve Azure credential, %s is not set", azureAccountEnv)
+	}
+	if cred.AccountKey == "" && cred.SASToken == "" {
+		return nil, errors.Errorf("Failed to resolve Azure credential, either %s or %s should be set", azureKeyEnv, azureSASTokenEnv)
+	}
+	return cred, nil
+}
+
+// BlobURL returns the url of the path in the container, e.g. azure://<account>.blob.core.windows.net/<container>/<path>,
+// which is the form of url accepted by Snowflake.
+func (c *AzureCredential) BlobURL(container, path string) string {
+	return fmt.Sprintf("azure://%s.blob.core.windows.net/%s/%s", c.AccountName, container, strings.TrimPrefix(path, "/"))
+}
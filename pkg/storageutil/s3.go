@@ -0,0 +1,68 @@
+package storageutil
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The query parameters of the storage uri for S3 compatible storage, they are the same as the ones
+// accepted by dumpling and TiCDC, so the storage uri can be passed to them as it is.
+const (
+	s3EndpointParam       = "endpoint"
+	s3RegionParam         = "region"
+	s3ForcePathStyleParam = "force-path-style"
+)
+
+// S3Options is the options to access S3 compatible storage, e.g. MinIO or Ceph.
+type S3Options struct {
+	// Endpoint is the endpoint of the storage, e.g. http://127.0.0.1:9000. Empty means AWS S3.
+	Endpoint string
+	// Region is the region of the bucket. Empty means resolving it from AWS S3.
+	Region string
+	// ForcePathStyle accesses the bucket by http(s)://<endpoint>/<bucket> instead of http(s)://<bucket>.<endpoint>.
+	ForcePathStyle bool
+}
+
+// GetS3Options returns the S3 options in the query of the storage uri.
+func GetS3Options(uri *url.URL) S3Options {
+	values := uri.Query()
+	forcePathStyle, _ := strconv.ParseBool(values.Get(s3ForcePathStyleParam))
+	return S3Options{
+		Endpoint:       values.Get(s3EndpointParam),
+		Region:         values.Get(s3RegionParam),
+		ForcePathStyle: forcePathStyle,
+	}
+}
+
+// SetS3Options adds the non-empty S3 options into the query of the storage uri,
+// the options already in the uri are overridden.
+func SetS3Options(uri *url.URL, opts S3Options) {
+	values := uri.Query()
+	if opts.Endpoint != "" {
+		values.Set(s3EndpointParam, opts.Endpoint)
+	}
+	if opts.Region != "" {
+		values.Set(s3RegionParam, opts.Region)
+	}
+	if opts.ForcePathStyle {
+		values.Set(s3ForcePathStyleParam, "true")
+	}
+	uri.RawQuery = values.Encode()
+}
+
+// EndpointHost returns the host (and port) of the endpoint without the scheme, e.g. 127.0.0.1:9000.
+func (o S3Options) EndpointHost() string {
+	if u, err := url.Parse(o.Endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSuffix(o.Endpoint, "/")
+}
+
+// EndpointURL returns the endpoint with the scheme, https is used if the scheme is not specified.
+func (o S3Options) EndpointURL() string {
+	if strings.Contains(o.Endpoint, "://") {
+		return strings.TrimSuffix(o.Endpoint, "/")
+	}
+	return "https://" + strings.TrimSuffix(o.Endpoint, "/")
+}
@@ -1,11 +1,20 @@
 package tidbsql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"strings"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	timodel "github.com/pingcap/tidb/parser/model"
+	_ "github.com/pingcap/tidb/types/parser_driver" // the driver is required to parse the value expressions
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
 )
 
 type columnAction int8
@@ -108,12 +117,61 @@ func GetColumnDiff(prev []cloudstorage.TableCol, curr []cloudstorage.TableCol) (
 	return columnDiff, nil
 }
 
+// queryer is implemented by both *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 func GetTiDBTableColumn(db *sql.DB, sourceDatabase, sourceTable string) ([]cloudstorage.TableCol, error) {
+	return getTiDBTableColumn(db, sourceDatabase, sourceTable)
+}
+
+// GetTiDBTableColumnAt returns the columns of the table at the snapshot ts, e.g. the schema before a DDL.
+// It returns empty if the table does not exist at that time.
+func GetTiDBTableColumnAt(db *sql.DB, sourceDatabase, sourceTable string, ts uint64) ([]cloudstorage.TableCol, error) {
+	ctx := context.Background()
+	// tidb_snapshot is a session variable, so a dedicated connection is used and it is reset before returned to the pool
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "SET @@tidb_snapshot = ?", fmt.Sprint(ts)); err != nil {
+		return nil, errors.Annotatef(err, "Failed to read the schema of TiDB at %d", ts)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SET @@tidb_snapshot = ''"); err != nil {
+			log.Warn("Failed to reset tidb_snapshot", zap.Error(err))
+			// do not return the connection to the pool
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	columns, err := getTiDBTableColumn(conn, sourceDatabase, sourceTable)
+	if err != nil || len(columns) == 0 {
+		return columns, err
+	}
+	// The primary key is also read at the snapshot ts, since it may be changed by the DDL
+	pkColumns, _, err := getTiDBTableKeys(conn, sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range columns {
+		for _, pkColumn := range pkColumns {
+			if strings.EqualFold(columns[i].Name, pkColumn) {
+				columns[i].IsPK = "true"
+			}
+		}
+	}
+	return columns, nil
+}
+
+func getTiDBTableColumn(db queryer, sourceDatabase, sourceTable string) ([]cloudstorage.TableCol, error) {
 	columnQuery := fmt.Sprintf(`SELECT COLUMN_NAME, COLUMN_DEFAULT, IS_NULLABLE, DATA_TYPE, 
 CHARACTER_MAXIMUM_LENGTH, NUMERIC_PRECISION, NUMERIC_SCALE, DATETIME_PRECISION
 FROM information_schema.columns
-WHERE table_schema = "%s" AND table_name = "%s"`, sourceDatabase, sourceTable) // FIXME: Escape
-	rows, err := db.Query(columnQuery)
+WHERE table_schema = "%s" AND table_name = "%s"
+ORDER BY ORDINAL_POSITION`, sourceDatabase, sourceTable) // FIXME: Escape
+	rows, err := db.QueryContext(context.Background(), columnQuery)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -176,3 +234,130 @@ WHERE table_schema = "%s" AND table_name = "%s"`, sourceDatabase, sourceTable) /
 	}
 	return tableColumns, nil
 }
+
+// IsRenameTable returns whether the DDL renames the table.
+func IsRenameTable(tp timodel.ActionType) bool {
+	return tp == timodel.ActionRenameTable || tp == timodel.ActionRenameTables
+}
+
+// RequiresColumns returns whether the columns before the DDL are required to apply it in data warehouse.
+// The table created by the DDL and the database level DDLs have no columns before.
+func RequiresColumns(tp timodel.ActionType) bool {
+	switch tp {
+	case timodel.ActionCreateTable, timodel.ActionCreateSchema, timodel.ActionDropSchema:
+		return false
+	}
+	return true
+}
+
+// GetPKColumns returns the names of the primary key columns in the table definition.
+func GetPKColumns(columns []cloudstorage.TableCol) []string {
+	pkColumns := make([]string, 0)
+	for _, col := range columns {
+		if col.IsPK == "true" {
+			pkColumns = append(pkColumns, col.Name)
+		}
+	}
+	return pkColumns
+}
+
+// GetRenamedTableFrom returns the table before renamed by the DDL. The table definition of the
+// rename table DDL is written under the new table name, so the old one is found in the query.
+func GetRenamedTableFrom(tableDef cloudstorage.TableDefinition) (TableFQN, error) {
+	stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", "")
+	if err != nil {
+		return TableFQN{}, errors.Annotatef(err, "Failed to parse rename table ddl %s", tableDef.Query)
+	}
+	var tableToTables []*ast.TableToTable
+	switch stmt := stmt.(type) {
+	case *ast.RenameTableStmt:
+		tableToTables = stmt.TableToTables
+	case *ast.AlterTableStmt:
+		// ALTER TABLE t RENAME TO t1
+		for _, spec := range stmt.Specs {
+			if spec.Tp == ast.AlterTableRenameTable {
+				tableToTables = append(tableToTables, &ast.TableToTable{OldTable: stmt.Table, NewTable: spec.NewTable})
+			}
+		}
+	}
+	for _, t := range tableToTables {
+		newSchema := t.NewTable.Schema.O
+		if newSchema == "" {
+			newSchema = tableDef.Schema
+		}
+		if newSchema != tableDef.Schema || t.NewTable.Name.O != tableDef.Table {
+			continue
+		}
+		oldSchema := t.OldTable.Schema.O
+		if oldSchema == "" {
+			// The table is not qualified in the query, it is in the current database of the session
+			oldSchema = tableDef.Schema
+		}
+		return TableFQN{Schema: oldSchema, Name: t.OldTable.Name.O}, nil
+	}
+	return TableFQN{}, errors.Errorf("Failed to find the renamed table %s.%s in ddl %s", tableDef.Schema, tableDef.Table, tableDef.Query)
+}
+
+// GetColumnsBeforeDDL returns the columns before the DDL, which are used to initialize the schema when
+// a DDL is received before any DML of the table. prevTiDBColumns is the schema of TiDB before the DDL,
+// which has no column id. The columns not changed by the DDL are taken from the table definition so that
+// they are identical, the renamed columns are found in the query, and the columns modified by the DDL
+// are taken from prevTiDBColumns. The primary key is always taken from prevTiDBColumns, since the DDL
+// may change it.
+func GetColumnsBeforeDDL(prevTiDBColumns []cloudstorage.TableCol, tableDef cloudstorage.TableDefinition) []cloudstorage.TableCol {
+	// lower case column name before the DDL -> column name after the DDL
+	renamed := make(map[string]string)
+	modified := make(map[string]bool)
+	if stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", ""); err == nil {
+		if alterStmt, ok := stmt.(*ast.AlterTableStmt); ok {
+			for _, spec := range alterStmt.Specs {
+				switch spec.Tp {
+				case ast.AlterTableRenameColumn:
+					renamed[spec.OldColumnName.Name.L] = spec.NewColumnName.Name.O
+				case ast.AlterTableChangeColumn:
+					renamed[spec.OldColumnName.Name.L] = spec.NewColumns[0].Name.Name.O
+				case ast.AlterTableModifyColumn:
+					modified[spec.NewColumns[0].Name.Name.L] = true
+				}
+			}
+		}
+	}
+	currColumns := make(map[string]*cloudstorage.TableCol, len(tableDef.Columns))
+	for i, col := range tableDef.Columns {
+		currColumns[strings.ToLower(col.Name)] = &tableDef.Columns[i]
+	}
+
+	columns := make([]cloudstorage.TableCol, 0, len(prevTiDBColumns))
+	for _, prevCol := range prevTiDBColumns {
+		name := strings.ToLower(prevCol.Name)
+		if newName, ok := renamed[name]; ok {
+			if currCol, ok := currColumns[strings.ToLower(newName)]; ok {
+				col := *currCol
+				col.Name, col.IsPK = prevCol.Name, prevCol.IsPK
+				columns = append(columns, col)
+				continue
+			}
+		}
+		currCol, ok := currColumns[name]
+		if ok && !modified[name] {
+			col := *currCol
+			col.IsPK = prevCol.IsPK
+			columns = append(columns, col)
+			continue
+		}
+		col := prevCol
+		// The format of the table definition written by TiCDC
+		col.Tp = strings.ToUpper(col.Tp)
+		if col.Nullable == "true" {
+			col.Nullable = ""
+		}
+		if ok {
+			col.ID = currCol.ID
+		} else {
+			// The column is dropped by the DDL, any id not used by the other columns is fine
+			col.ID = fmt.Sprintf("dropped-%s", prevCol.Name)
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
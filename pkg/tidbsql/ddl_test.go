@@ -86,3 +86,88 @@ func TestGetColumnDiff(t *testing.T) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, expected, columnDiff)
 }
+
+func TestGetRenamedTableFrom(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected tidbsql.TableFQN
+	}{
+		{"RENAME TABLE `test`.`t1` TO `test`.`t2`", tidbsql.TableFQN{Schema: "test", Name: "t1"}},
+		{"RENAME TABLE t1 TO t2", tidbsql.TableFQN{Schema: "test", Name: "t1"}},
+		{"RENAME TABLE `other`.`t1` TO `test`.`t2`", tidbsql.TableFQN{Schema: "other", Name: "t1"}},
+		{"RENAME TABLE `test`.`t3` TO `test`.`t4`, `test`.`t1` TO `test`.`t2`", tidbsql.TableFQN{Schema: "test", Name: "t1"}},
+		{"ALTER TABLE `test`.`t1` RENAME TO `test`.`t2`", tidbsql.TableFQN{Schema: "test", Name: "t1"}},
+	}
+	for _, c := range cases {
+		table, err := tidbsql.GetRenamedTableFrom(cloudstorage.TableDefinition{Schema: "test", Table: "t2", Query: c.query})
+		require.NoError(t, err, c.query)
+		require.Equal(t, c.expected, table, c.query)
+	}
+
+	_, err := tidbsql.GetRenamedTableFrom(cloudstorage.TableDefinition{Schema: "test", Table: "t2", Query: "RENAME TABLE t3 TO t4"})
+	require.Error(t, err)
+}
+
+func TestGetColumnsBeforeDDL(t *testing.T) {
+	prevTiDBColumns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "int", Nullable: "false", IsPK: "true"},
+		{Name: "name", Tp: "varchar", Precision: "10", Nullable: "true"},
+		{Name: "age", Tp: "int", Nullable: "true"},
+		{Name: "price", Tp: "decimal", Precision: "10", Scale: "2", Nullable: "true"},
+	}
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "test",
+		Table:  "t1",
+		Query:  "ALTER TABLE `test`.`t1` RENAME COLUMN `name` TO `nick`, MODIFY COLUMN `price` DECIMAL(20, 2), DROP COLUMN `age`",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+			{ID: "2", Name: "nick", Tp: "VARCHAR", Precision: "10"},
+			{ID: "4", Name: "price", Tp: "DECIMAL", Precision: "20", Scale: "2"},
+		},
+	}
+	expected := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "10"},
+		{ID: "dropped-age", Name: "age", Tp: "INT"},
+		{ID: "4", Name: "price", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+	}
+	require.Equal(t, expected, tidbsql.GetColumnsBeforeDDL(prevTiDBColumns, tableDef))
+
+	columnDiff, err := tidbsql.GetColumnDiff(expected, tableDef.Columns)
+	require.NoError(t, err)
+	actions := make(map[string]int)
+	for _, diff := range columnDiff {
+		if diff.Before != nil {
+			actions[diff.Before.Name] = int(diff.Action)
+		}
+	}
+	require.Equal(t, map[string]int{
+		"id":    int(tidbsql.UNCHANGE),
+		"name":  int(tidbsql.RENAME_COLUMN),
+		"age":   int(tidbsql.DROP_COLUMN),
+		"price": int(tidbsql.MODIFY_COLUMN),
+	}, actions)
+
+	// The primary key before the DDL is taken from the schema of TiDB before the DDL
+	prevTiDBColumns = []cloudstorage.TableCol{
+		{Name: "id", Tp: "int", Nullable: "false", IsPK: "true"},
+		{Name: "code", Tp: "int", Nullable: "false"},
+	}
+	tableDef = cloudstorage.TableDefinition{
+		Schema: "test",
+		Table:  "t1",
+		Query:  "ALTER TABLE `test`.`t1` DROP PRIMARY KEY, ADD PRIMARY KEY (`code`)",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", Nullable: "false"},
+			{ID: "2", Name: "code", Tp: "INT", Nullable: "false", IsPK: "true"},
+		},
+	}
+	prevColumns := tidbsql.GetColumnsBeforeDDL(prevTiDBColumns, tableDef)
+	require.Equal(t, []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "code", Tp: "INT", Nullable: "false"},
+	}, prevColumns)
+	columnDiff, err = tidbsql.GetColumnDiff(prevColumns, tableDef.Columns)
+	require.NoError(t, err)
+	require.True(t, tidbsql.IsPKChanged(columnDiff))
+}
@@ -0,0 +1,241 @@
+package tidbsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/dumpling/export"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// UniqueKey is a unique index of a TiDB table.
+type UniqueKey struct {
+	Name    string
+	Columns []string
+}
+
+// IndexChange is the unique keys added and the indexes dropped by a DDL. The table definition
+// written by TiCDC has no index, so the changes are found in the query.
+type IndexChange struct {
+	AddedUniqueKeys []UniqueKey
+	// DroppedIndexes may be not unique, since it is unknown in a DROP INDEX statement.
+	DroppedIndexes []string
+}
+
+// GetIndexChange returns the unique keys added and the indexes dropped by the DDL.
+func GetIndexChange(tableDef cloudstorage.TableDefinition) (IndexChange, error) {
+	var change IndexChange
+	if len(tableDef.Query) == 0 {
+		return change, nil
+	}
+	stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", "")
+	if err != nil {
+		return change, errors.Annotatef(err, "Failed to parse ddl %s", tableDef.Query)
+	}
+	switch stmt := stmt.(type) {
+	case *ast.CreateTableStmt:
+		for _, col := range stmt.Cols {
+			change.addColumnUniqueKey(col)
+		}
+		for _, constraint := range stmt.Constraints {
+			change.addConstraint(constraint)
+		}
+	case *ast.CreateIndexStmt:
+		if stmt.KeyType == ast.IndexKeyTypeUnique {
+			change.addUniqueKey(stmt.IndexName, stmt.IndexPartSpecifications)
+		}
+	case *ast.DropIndexStmt:
+		change.DroppedIndexes = append(change.DroppedIndexes, stmt.IndexName)
+	case *ast.AlterTableStmt:
+		for _, spec := range stmt.Specs {
+			switch spec.Tp {
+			case ast.AlterTableAddColumns:
+				for _, col := range spec.NewColumns {
+					change.addColumnUniqueKey(col)
+				}
+			case ast.AlterTableAddConstraint:
+				change.addConstraint(spec.Constraint)
+			case ast.AlterTableDropIndex:
+				change.DroppedIndexes = append(change.DroppedIndexes, spec.Name)
+			case ast.AlterTableRenameIndex:
+				log.Warn("Renaming index is not replicated, the constraint in data warehouse keeps the old name",
+					zap.String("from", spec.FromKey.O), zap.String("to", spec.ToKey.O))
+			}
+		}
+	}
+	return change, nil
+}
+
+func (c *IndexChange) addConstraint(constraint *ast.Constraint) {
+	switch constraint.Tp {
+	case ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+		c.addUniqueKey(constraint.Name, constraint.Keys)
+	}
+}
+
+// addColumnUniqueKey adds the unique key of the column defined as `c INT UNIQUE`, which is named after the column.
+func (c *IndexChange) addColumnUniqueKey(col *ast.ColumnDef) {
+	for _, option := range col.Options {
+		if option.Tp == ast.ColumnOptionUniqKey {
+			c.AddedUniqueKeys = append(c.AddedUniqueKeys, UniqueKey{Name: col.Name.Name.O, Columns: []string{col.Name.Name.O}})
+		}
+	}
+}
+
+func (c *IndexChange) addUniqueKey(name string, parts []*ast.IndexPartSpecification) {
+	columns := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Column == nil {
+			log.Warn("Unique key on expression is not replicated", zap.String("name", name))
+			return
+		}
+		columns = append(columns, part.Column.Name.O)
+	}
+	if name == "" {
+		// TiDB names the index after the first column
+		name = columns[0]
+	}
+	c.AddedUniqueKeys = append(c.AddedUniqueKeys, UniqueKey{Name: name, Columns: columns})
+}
+
+// IsPKChanged returns whether the primary key is changed between the columns of the diff.
+func IsPKChanged(columnDiff []ColumnDiff) bool {
+	for _, diff := range columnDiff {
+		before := diff.Before != nil && diff.Before.IsPK == "true"
+		after := diff.After != nil && diff.After.IsPK == "true"
+		if before != after {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTiDBTableKeys returns the primary key columns and the unique keys of the table.
+func GetTiDBTableKeys(db *sql.DB, sourceDatabase, sourceTable string) ([]string, []UniqueKey, error) {
+	return getTiDBTableKeys(db, sourceDatabase, sourceTable)
+}
+
+func getTiDBTableKeys(db queryer, sourceDatabase, sourceTable string) ([]string, []UniqueKey, error) {
+	indexQuery := fmt.Sprintf("SHOW INDEX FROM `%s`.`%s`", sourceDatabase, sourceTable) // FIXME: Escape
+	indexRows, err := db.QueryContext(context.Background(), indexQuery)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	indexResults, err := export.GetSpecifiedColumnValuesAndClose(indexRows, "KEY_NAME", "COLUMN_NAME", "SEQ_IN_INDEX", "NON_UNIQUE")
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	// Sort by key_name, seq_in_index
+	slices.SortFunc(indexResults, func(i, j []string) bool {
+		if i[0] == j[0] {
+			seqI, _ := strconv.Atoi(i[2])
+			seqJ, _ := strconv.Atoi(j[2])
+			return seqI < seqJ
+		}
+		return i[0] < j[0]
+	})
+
+	pkColumns := make([]string, 0)
+	uniqueKeys := make([]UniqueKey, 0)
+	expressionKeys := make(map[string]bool)
+	for _, oneRow := range indexResults {
+		keyName, columnName, nonUnique := oneRow[0], oneRow[1], oneRow[3]
+		switch {
+		case keyName == "PRIMARY":
+			pkColumns = append(pkColumns, columnName)
+		case nonUnique != "0":
+		case columnName == "":
+			// the column name of an expression index is NULL
+			expressionKeys[keyName] = true
+		case len(uniqueKeys) > 0 && uniqueKeys[len(uniqueKeys)-1].Name == keyName:
+			uniqueKeys[len(uniqueKeys)-1].Columns = append(uniqueKeys[len(uniqueKeys)-1].Columns, columnName)
+		default:
+			uniqueKeys = append(uniqueKeys, UniqueKey{Name: keyName, Columns: []string{columnName}})
+		}
+	}
+	columnKeys := make([]UniqueKey, 0, len(uniqueKeys))
+	for _, key := range uniqueKeys {
+		if !expressionKeys[key.Name] {
+			columnKeys = append(columnKeys, key)
+		}
+	}
+	return pkColumns, columnKeys, nil
+}
+
+// MergeStrategy is how the rows of an increment file are matched with the rows in the data warehouse.
+type MergeStrategy int
+
+const (
+	// MergeOnPrimaryKey matches the rows by the primary key.
+	MergeOnPrimaryKey MergeStrategy = iota
+	// MergeOnUniqueKey matches the rows by a unique key of NOT NULL columns, which TiCDC uses as the
+	// handle of a table without primary key.
+	MergeOnUniqueKey
+)
+
+func (s MergeStrategy) String() string {
+	switch s {
+	case MergeOnPrimaryKey:
+		return "primary key"
+	case MergeOnUniqueKey:
+		return "unique key"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// MergeKey is the strategy and the columns to match the rows when merging the increment of a table.
+type MergeKey struct {
+	Strategy MergeStrategy
+	Columns  []string
+}
+
+// Equal returns whether the merge keys are the same.
+func (k MergeKey) Equal(other MergeKey) bool {
+	return k.Strategy == other.Strategy && slices.Equal(k.Columns, other.Columns)
+}
+
+// LogMergeKey logs the merge key chosen for the table if it is changed from prev, which is nil if no merge
+// key was chosen.
+func LogMergeKey(table string, prev *MergeKey, mergeKey MergeKey) {
+	if prev != nil && prev.Equal(mergeKey) {
+		return
+	}
+	log.Info("Merge strategy of table is chosen", zap.String("table", table),
+		zap.Stringer("strategy", mergeKey.Strategy), zap.Strings("columns", mergeKey.Columns))
+}
+
+// GetMergeKey chooses the merge key of the table with the columns of its schema version. The primary key is
+// preferred, then the first unique key of NOT NULL columns. A table without any of them is refused, since
+// the rows can not be matched: identical rows would be kept as one, and the row before an update would be
+// kept, as TiCDC does not write the old value of an updated row.
+func GetMergeKey(columns []cloudstorage.TableCol, uniqueKeys []UniqueKey) (MergeKey, error) {
+	if pkColumns := GetPKColumns(columns); len(pkColumns) > 0 {
+		return MergeKey{Strategy: MergeOnPrimaryKey, Columns: pkColumns}, nil
+	}
+	for _, key := range uniqueKeys {
+		keyColumns := make([]string, 0, len(key.Columns))
+		for _, name := range key.Columns {
+			// The names in the catalog of the data warehouse may be in another case
+			idx := slices.IndexFunc(columns, func(col cloudstorage.TableCol) bool { return strings.EqualFold(col.Name, name) })
+			if idx < 0 || columns[idx].Nullable != "false" {
+				break
+			}
+			keyColumns = append(keyColumns, columns[idx].Name)
+		}
+		if len(keyColumns) == len(key.Columns) {
+			return MergeKey{Strategy: MergeOnUniqueKey, Columns: keyColumns}, nil
+		}
+	}
+	return MergeKey{}, errors.New("the table has no primary key or unique key of NOT NULL columns, " +
+		"please add a primary key to the table in TiDB to replicate it")
+}
@@ -0,0 +1,106 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetIndexChange(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected tidbsql.IndexChange
+	}{
+		{
+			query: "CREATE TABLE t (id INT PRIMARY KEY, a INT UNIQUE, b INT, c INT, UNIQUE KEY uk_bc (b, c), KEY idx_c (c))",
+			expected: tidbsql.IndexChange{AddedUniqueKeys: []tidbsql.UniqueKey{
+				{Name: "a", Columns: []string{"a"}},
+				{Name: "uk_bc", Columns: []string{"b", "c"}},
+			}},
+		},
+		{
+			query:    "CREATE UNIQUE INDEX uk_a ON t (a)",
+			expected: tidbsql.IndexChange{AddedUniqueKeys: []tidbsql.UniqueKey{{Name: "uk_a", Columns: []string{"a"}}}},
+		},
+		{
+			query:    "CREATE INDEX idx_a ON t (a)",
+			expected: tidbsql.IndexChange{},
+		},
+		{
+			query:    "DROP INDEX uk_a ON t",
+			expected: tidbsql.IndexChange{DroppedIndexes: []string{"uk_a"}},
+		},
+		{
+			query: "ALTER TABLE t DROP INDEX uk_a, ADD UNIQUE (b, c), ADD COLUMN d INT UNIQUE",
+			expected: tidbsql.IndexChange{
+				AddedUniqueKeys: []tidbsql.UniqueKey{
+					{Name: "b", Columns: []string{"b", "c"}},
+					{Name: "d", Columns: []string{"d"}},
+				},
+				DroppedIndexes: []string{"uk_a"},
+			},
+		},
+		{
+			query:    "ALTER TABLE t ADD UNIQUE KEY uk_expr ((a + 1))",
+			expected: tidbsql.IndexChange{},
+		},
+	}
+	for _, c := range cases {
+		change, err := tidbsql.GetIndexChange(cloudstorage.TableDefinition{Query: c.query})
+		require.NoError(t, err, c.query)
+		require.Equal(t, c.expected, change, c.query)
+	}
+}
+
+func TestIsPKChanged(t *testing.T) {
+	prev := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", IsPK: "true"},
+		{ID: "2", Name: "code", Tp: "VARCHAR", Precision: "10"},
+	}
+	curr := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", IsPK: "true"},
+		{ID: "2", Name: "code", Tp: "VARCHAR", Precision: "20"},
+	}
+	diff, err := tidbsql.GetColumnDiff(prev, curr)
+	require.NoError(t, err)
+	require.False(t, tidbsql.IsPKChanged(diff))
+
+	curr[1].IsPK = "true"
+	diff, err = tidbsql.GetColumnDiff(prev, curr)
+	require.NoError(t, err)
+	require.True(t, tidbsql.IsPKChanged(diff))
+
+	// Dropping a primary key column changes the primary key
+	diff, err = tidbsql.GetColumnDiff(prev, curr[1:])
+	require.NoError(t, err)
+	require.True(t, tidbsql.IsPKChanged(diff))
+}
+
+func TestGetMergeKey(t *testing.T) {
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "code", Tp: "VARCHAR", Precision: "10", Nullable: "false"},
+		{ID: "3", Name: "email", Tp: "VARCHAR", Precision: "64"},
+	}
+	uniqueKeys := []tidbsql.UniqueKey{
+		{Name: "uk_email", Columns: []string{"EMAIL"}},
+		{Name: "uk_code", Columns: []string{"CODE"}},
+	}
+
+	mergeKey, err := tidbsql.GetMergeKey(columns, uniqueKeys)
+	require.NoError(t, err)
+	require.Equal(t, tidbsql.MergeKey{Strategy: tidbsql.MergeOnPrimaryKey, Columns: []string{"id"}}, mergeKey)
+
+	// The unique key of nullable columns is skipped
+	columns[0].IsPK = ""
+	mergeKey, err = tidbsql.GetMergeKey(columns, uniqueKeys)
+	require.NoError(t, err)
+	require.Equal(t, tidbsql.MergeKey{Strategy: tidbsql.MergeOnUniqueKey, Columns: []string{"code"}}, mergeKey)
+	require.False(t, mergeKey.Equal(tidbsql.MergeKey{Strategy: tidbsql.MergeOnPrimaryKey, Columns: []string{"code"}}))
+
+	// The table without primary key or unique key of NOT NULL columns is refused
+	_, err = tidbsql.GetMergeKey(columns, uniqueKeys[:1])
+	require.Error(t, err)
+}
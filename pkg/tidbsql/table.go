@@ -0,0 +1,52 @@
+package tidbsql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb/util/table-filter"
+)
+
+// TableFQN is the full qualified name of a TiDB table.
+type TableFQN struct {
+	Schema string
+	Name   string
+}
+
+func (t TableFQN) String() string {
+	return fmt.Sprintf("%s.%s", t.Schema, t.Name)
+}
+
+// GetTablesByFilter returns all the user tables in TiDB that match the table filter rules,
+// e.g. ["shop.*", "!shop.tmp_*"].
+// Refer to: https://docs.pingcap.com/tidb/stable/table-filter
+func GetTablesByFilter(db *sql.DB, rules []string) ([]TableFQN, error) {
+	tableFilter, err := filter.Parse(rules)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to parse table filter rules")
+	}
+	rows, err := db.Query(`SELECT TABLE_SCHEMA, TABLE_NAME
+FROM information_schema.tables
+WHERE TABLE_TYPE = 'BASE TABLE'
+AND LOWER(TABLE_SCHEMA) NOT IN ('mysql', 'information_schema', 'performance_schema', 'metrics_schema', 'sys')
+ORDER BY TABLE_SCHEMA, TABLE_NAME`)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+	tables := make([]TableFQN, 0)
+	for rows.Next() {
+		var table TableFQN
+		if err = rows.Scan(&table.Schema, &table.Name); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if tableFilter.MatchTable(table.Schema, table.Name) {
+			tables = append(tables, table)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return tables, nil
+}
@@ -0,0 +1,294 @@
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/csvutil"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// checkpointFileName is the name of the checkpoint file in the increment workspace.
+// It has no `.csv` or `.json` suffix, so the consumer will not handle it as a dml file.
+const checkpointFileName = "tidb2dw.checkpoint"
+
+// dmlCheckpoint records the replication progress of one DmlPathKey.
+type dmlCheckpoint struct {
+	Schema       string `json:"schema"`
+	Table        string `json:"table"`
+	TableVersion uint64 `json:"table-version"`
+	PartitionNum int64  `json:"partition-num"`
+	Date         string `json:"date"`
+	// FileIndex is the index of the last applied dml file.
+	FileIndex uint64 `json:"file-index"`
+	// CommitTs is the max commit ts of all the applied dml events.
+	CommitTs uint64 `json:"commit-ts"`
+}
+
+func (cp *dmlCheckpoint) key() cloudstorage.DmlPathKey {
+	return cloudstorage.DmlPathKey{
+		SchemaPathKey: cloudstorage.SchemaPathKey{
+			Schema:       cp.Schema,
+			Table:        cp.Table,
+			TableVersion: cp.TableVersion,
+		},
+		PartitionNum: cp.PartitionNum,
+		Date:         cp.Date,
+	}
+}
+
+type checkpointFile struct {
+	DMLs []*dmlCheckpoint `json:"dmls"`
+	// TableVersions maintains a map of <`schema`.`table`, the last applied table version>
+	TableVersions map[string]uint64 `json:"table-versions"`
+}
+
+// checkpointStore persists the replication progress of the consumer in the external storage,
+// so that the consumer can resume from the checkpoint after restart.
+type checkpointStore struct {
+	externalStorage storage.ExternalStorage
+	mu              sync.Mutex
+	dmls            map[cloudstorage.DmlPathKey]*dmlCheckpoint
+	tableVersions   map[string]uint64
+	// dirty means the progress in memory has not been persisted.
+	dirty bool
+}
+
+// newCheckpointStore loads the checkpoint from the external storage if exists.
+func newCheckpointStore(ctx context.Context, externalStorage storage.ExternalStorage) (*checkpointStore, error) {
+	s := &checkpointStore{
+		externalStorage: externalStorage,
+		dmls:            make(map[cloudstorage.DmlPathKey]*dmlCheckpoint),
+		tableVersions:   make(map[string]uint64),
+	}
+	exist, err := externalStorage.FileExists(ctx, checkpointFileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exist {
+		log.Info("checkpoint not found, start from scratch")
+		return s, nil
+	}
+	content, err := externalStorage.ReadFile(ctx, checkpointFileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var file checkpointFile
+	if err = json.Unmarshal(content, &file); err != nil {
+		return nil, errors.Annotate(err, "failed to decode checkpoint")
+	}
+	for _, cp := range file.DMLs {
+		s.dmls[cp.key()] = cp
+	}
+	for k, v := range file.TableVersions {
+		s.tableVersions[k] = v
+	}
+	log.Info("checkpoint loaded", zap.Int("dmlKeys", len(s.dmls)), zap.Any("tableVersions", s.tableVersions))
+	return s, nil
+}
+
+// fileIndexes returns a map of <dmlPathKey, last applied file index>.
+func (s *checkpointStore) fileIndexes() map[cloudstorage.DmlPathKey]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[cloudstorage.DmlPathKey]uint64, len(s.dmls))
+	for k, cp := range s.dmls {
+		res[k] = cp.FileIndex
+	}
+	return res
+}
+
+// fileIndex returns the index of the last applied dml file of the key.
+func (s *checkpointStore) fileIndex(key cloudstorage.DmlPathKey) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.dmls[key]
+	if !ok {
+		return 0, false
+	}
+	return cp.FileIndex, true
+}
+
+// isFileApplied returns whether the dml file has been applied to the data warehouse.
+func (s *checkpointStore) isFileApplied(key cloudstorage.DmlPathKey, fileIdx uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.dmls[key]
+	return ok && fileIdx <= cp.FileIndex
+}
+
+// isTableVersionApplied returns whether the DDL of the table version has been applied to the data warehouse.
+func (s *checkpointStore) isTableVersionApplied(key cloudstorage.SchemaPathKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version, ok := s.tableVersions[key.GetKey()]
+	return ok && key.TableVersion <= version
+}
+
+// updateDML records the dml file has been applied, it is persisted by the next flush.
+// The applied files are recorded in the apply log of the data warehouse, so they will not
+// be merged again if the consumer restarts before the flush.
+func (s *checkpointStore) updateDML(key cloudstorage.DmlPathKey, fileIdx, commitTs uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.dmls[key]
+	if !ok {
+		cp = &dmlCheckpoint{
+			Schema:       key.Schema,
+			Table:        key.Table,
+			TableVersion: key.TableVersion,
+			PartitionNum: key.PartitionNum,
+			Date:         key.Date,
+		}
+		s.dmls[key] = cp
+	}
+	cp.FileIndex = fileIdx
+	if commitTs > cp.CommitTs {
+		cp.CommitTs = commitTs
+	}
+	s.dirty = true
+}
+
+// updateTableVersion records the DDL of the table version has been applied and persists the checkpoint
+// immediately, since the DDLs are not recorded in the apply log of the data warehouse.
+func (s *checkpointStore) updateTableVersion(ctx context.Context, key cloudstorage.SchemaPathKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tableVersions[key.GetKey()] = key.TableVersion
+	return s.flushLocked(ctx)
+}
+
+// pruneOutdatedDMLs removes the dml checkpoints of the table versions before the key.
+// It should only be called when the dml files are deleted after applied, otherwise the
+// dml files kept in the storage would be applied again after restart.
+func (s *checkpointStore) pruneOutdatedDMLs(key cloudstorage.SchemaPathKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.dmls {
+		if k.Schema == key.Schema && k.Table == key.Table && k.TableVersion < key.TableVersion {
+			delete(s.dmls, k)
+			s.dirty = true
+		}
+	}
+}
+
+// removeTable removes all the checkpoints of the table which is dropped or renamed to another table.
+// Same as pruneOutdatedDMLs, it should only be called when the dml files are deleted after applied.
+func (s *checkpointStore) removeTable(schema, table string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.dmls {
+		if k.Schema == schema && k.Table == table {
+			delete(s.dmls, k)
+		}
+	}
+	key := cloudstorage.SchemaPathKey{Schema: schema, Table: table}
+	delete(s.tableVersions, key.GetKey())
+	s.dirty = true
+}
+
+// tableCommitTs returns a map of <schema.table, the min commit ts of the dml checkpoints of the table>.
+// The files of the table whose max commit ts is less than it have all been applied.
+func (s *checkpointStore) tableCommitTs() map[tidbsql.TableFQN]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[tidbsql.TableFQN]uint64)
+	for _, cp := range s.dmls {
+		table := tidbsql.TableFQN{Schema: cp.Schema, Name: cp.Table}
+		if commitTs, ok := res[table]; !ok || cp.CommitTs < commitTs {
+			res[table] = cp.CommitTs
+		}
+	}
+	return res
+}
+
+// flush persists the checkpoint if it is changed since the last flush.
+func (s *checkpointStore) flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.flushLocked(ctx)
+}
+
+func (s *checkpointStore) flushLocked(ctx context.Context) error {
+	file := checkpointFile{
+		DMLs:          make([]*dmlCheckpoint, 0, len(s.dmls)),
+		TableVersions: s.tableVersions,
+	}
+	for _, cp := range s.dmls {
+		file.DMLs = append(file.DMLs, cp)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.externalStorage.WriteFile(ctx, checkpointFileName, data); err != nil {
+		return errors.Annotate(err, "failed to persist checkpoint")
+	}
+	s.dirty = false
+	return nil
+}
+
+// getCommitTsRange returns the min and max commit ts of the dml events in the content of a CSV file.
+// The commit ts is the 4th field of each row, e.g. `I,table,schema,443459412185677825,...`.
+// The fields are quoted by quote, or escaped by backslash if it is empty, see the CSV config of the changefeed.
+func getCommitTsRange(content []byte, quote string) (minCommitTs, maxCommitTs uint64, err error) {
+	onRecord := func(fields []*string) error {
+		if len(fields) < 4 || fields[3] == nil {
+			return errors.Errorf("commit ts not found in the CSV record of %d fields", len(fields))
+		}
+		commitTs, err := strconv.ParseUint(*fields[3], 10, 64)
+		if err != nil {
+			return errors.Annotate(err, "invalid commit ts")
+		}
+		if minCommitTs == 0 || commitTs < minCommitTs {
+			minCommitTs = commitTs
+		}
+		if commitTs > maxCommitTs {
+			maxCommitTs = commitTs
+		}
+		return nil
+	}
+	switch quote {
+	case "":
+		err = csvutil.ReadRecords(bytes.NewReader(content), onRecord)
+	case `"`:
+		reader := csv.NewReader(bytes.NewReader(content))
+		// the number of fields changes with the schema of the table
+		reader.FieldsPerRecord = -1
+		for {
+			record, readErr := reader.Read()
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				return 0, 0, errors.Trace(readErr)
+			}
+			fields := make([]*string, 0, len(record))
+			for i := range record {
+				fields = append(fields, &record[i])
+			}
+			if err = onRecord(fields); err != nil {
+				break
+			}
+		}
+	default:
+		return 0, 0, errors.Errorf("unsupported CSV quote %s", quote)
+	}
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	return minCommitTs, maxCommitTs, nil
+}
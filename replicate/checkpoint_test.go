@@ -0,0 +1,83 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCommitTsRange(t *testing.T) {
+	// the fields escaped by backslash
+	content := []byte("I,t1,test,443459412185677825,1,abc\r\n" +
+		"U,t1,test,443459412185677830,2,multi-line\\nvalue\\, 443459412185677840\r\n" +
+		"D,t1,test,443459412185677827,3,\\N\r\n")
+	minCommitTs, maxCommitTs, err := getCommitTsRange(content, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(443459412185677825), minCommitTs)
+	require.Equal(t, uint64(443459412185677830), maxCommitTs)
+
+	// the fields quoted by `"`, a quoted field may contain the delimiter and line breaks
+	content = []byte(`"I","t1","test","443459412185677825","1","abc"` + "\r\n" +
+		`"U","t1","test","443459412185677830","2","multi-line` + "\n" + `a,b,c,443459412185677840,""quoted""` + "\"\r\n" +
+		`"D","t1","test","443459412185677827","3",` + "\r\n")
+	minCommitTs, maxCommitTs, err = getCommitTsRange(content, `"`)
+	require.NoError(t, err)
+	require.Equal(t, uint64(443459412185677825), minCommitTs)
+	require.Equal(t, uint64(443459412185677830), maxCommitTs)
+
+	minCommitTs, maxCommitTs, err = getCommitTsRange([]byte(""), `"`)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), minCommitTs)
+	require.Equal(t, uint64(0), maxCommitTs)
+
+	_, _, err = getCommitTsRange([]byte(`"I","t1","test","abc"`+"\n"), `"`)
+	require.Error(t, err)
+	_, _, err = getCommitTsRange([]byte(`"I","t1","test","4434594121856778`), `"`)
+	require.Error(t, err)
+}
+
+func TestCheckpointStorePrune(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	s, err := newCheckpointStore(ctx, extStorage)
+	require.NoError(t, err)
+
+	t1v1 := cloudstorage.SchemaPathKey{Schema: "test", Table: "t1", TableVersion: 1}
+	t1v2 := cloudstorage.SchemaPathKey{Schema: "test", Table: "t1", TableVersion: 2}
+	t2v1 := cloudstorage.SchemaPathKey{Schema: "test", Table: "t2", TableVersion: 1}
+	// the table with the same name in another schema
+	o1v1 := cloudstorage.SchemaPathKey{Schema: "other", Table: "t1", TableVersion: 1}
+	s.updateDML(cloudstorage.DmlPathKey{SchemaPathKey: t1v1}, 3, 100)
+	s.updateDML(cloudstorage.DmlPathKey{SchemaPathKey: o1v1}, 2, 90)
+	s.updateDML(cloudstorage.DmlPathKey{SchemaPathKey: t2v1}, 1, 101)
+	// the dml progress is only persisted by flush
+	exist, err := extStorage.FileExists(ctx, checkpointFileName)
+	require.NoError(t, err)
+	require.False(t, exist)
+	require.NoError(t, s.flush(ctx))
+
+	require.NoError(t, s.updateTableVersion(ctx, t2v1))
+	require.NoError(t, s.updateTableVersion(ctx, t1v2))
+	s.updateDML(cloudstorage.DmlPathKey{SchemaPathKey: t1v2}, 1, 102)
+	s.pruneOutdatedDMLs(t1v2)
+	s.removeTable("test", "t2")
+	require.NoError(t, s.flush(ctx))
+
+	loaded, err := newCheckpointStore(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{
+		{SchemaPathKey: t1v2}: 1,
+		{SchemaPathKey: o1v1}: 2,
+	}, loaded.fileIndexes())
+	require.Equal(t, map[tidbsql.TableFQN]uint64{
+		{Schema: "test", Name: "t1"}:  102,
+		{Schema: "other", Name: "t1"}: 90,
+	}, loaded.tableCommitTs())
+	require.True(t, loaded.isTableVersionApplied(t1v2))
+	require.False(t, loaded.isTableVersionApplied(t2v1))
+}
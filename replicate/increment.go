@@ -2,6 +2,9 @@ package replicate
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -13,9 +16,11 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/cdc/model"
 	sinkutil "github.com/pingcap/tiflow/cdc/sink/util"
 	"github.com/pingcap/tiflow/pkg/cmd/util"
@@ -29,6 +34,26 @@ import (
 
 const fakePartitionNumForSchemaFile = -1
 
+// applyLogTrimInterval is the interval to delete the records in the apply log of data warehouse
+// which are covered by the persisted checkpoint.
+const applyLogTrimInterval = time.Hour
+
+const (
+	// maxTableFailures is the number of consecutive failed rounds of a table before the consumer exits
+	// with the error, e.g. a DDL which can not be executed in data warehouse needs manual intervention.
+	maxTableFailures = 10
+	// maxTableBackoffRounds is the max number of rounds a failed table is skipped before it is retried.
+	maxTableBackoffRounds = 16
+)
+
+// TableRenamedFunc is called before the rename table DDL is executed in data warehouse, e.g. to make
+// sure the changes of the renamed table are captured. It may be called again if the DDL is retried.
+type TableRenamedFunc func(ctx context.Context, oldTable, newTable tidbsql.TableFQN, renameTs uint64) error
+
+// TableCreatedFunc is called before the create table DDL is executed in data warehouse, e.g. to record
+// that the table needs no snapshot. It may be called again if the DDL is retried.
+type TableCreatedFunc func(ctx context.Context, table tidbsql.TableFQN, createTs uint64) error
+
 // fileIndexRange defines a range of files. eg. CDC000002.csv ~ CDC000005.csv
 type fileIndexRange struct {
 	start uint64
@@ -39,6 +64,8 @@ type consumer struct {
 	replicationCfg  *config.ReplicaConfig
 	externalStorage storage.ExternalStorage
 	fileExtension   string
+	// csvQuote is the quote character of the CSV files, empty if the fields are escaped by backslash.
+	csvQuote string
 	// tableDMLIdxMap maintains a map of <dmlPathKey, max file index>
 	tableDMLIdxMap map[cloudstorage.DmlPathKey]uint64
 	// tableDefMap maintains a map of <`schema`.`table`, tableDef slice sorted by TableVersion>
@@ -49,15 +76,94 @@ type consumer struct {
 	sampleConnector coreinterfaces.Connector
 	// dwConnectorMap maintains a map of <TableID, dwConnector>, each table has a dwConnector
 	dwConnectorMap map[model.TableID]coreinterfaces.Connector
-	awsCredential  *credentials.Value // aws credential, resolved from current env
-	sinkURI        *url.URL
+	// initializedTables records the tables whose schema of dwConnector has been initialized
+	initializedTables map[model.TableID]bool
+	connectorMu       sync.Mutex
+	// tidbConfig is used to read the schema before a DDL which is received before any DML
+	tidbConfig    *tidbsql.TiDBConfig
+	tidbConn      *sql.DB
+	tidbConnMu    sync.Mutex
+	awsCredential *credentials.Value // aws credential, resolved from current env
+	sinkURI       *url.URL
+	// checkpoint persists the replication progress, it is loaded when the consumer starts.
+	checkpoint *checkpointStore
+	// keepFiles indicates whether to keep the dml files after they are applied.
+	keepFiles bool
+	// concurrency is the max number of tables handled concurrently.
+	concurrency int
+	// onTableRenamed is called when a table is renamed, it may be nil.
+	onTableRenamed TableRenamedFunc
+	onTableCreated TableCreatedFunc
+	// lastApplyLogTrim is the time of the last trim of the apply log.
+	lastApplyLogTrim time.Time
+	// trimmedCommitTs maintains a map of <schema.table, the commit ts the apply log of the table is trimmed to>
+	trimmedCommitTs map[tidbsql.TableFQN]uint64
+	// tableRetries tracks the consecutive failures of the tables
+	tableRetries *tableRetries
+}
+
+// tableRetries tracks the consecutive failures of the tables. A failed table is retried with
+// exponential backoff in rounds, so that it does not keep failing in every round.
+type tableRetries struct {
+	mu sync.Mutex
+	// failures maintains a map of <table, the number of consecutive failed rounds>
+	failures map[string]int
+	// skipRounds maintains a map of <table, the number of rounds to skip before the next retry>
+	skipRounds map[string]int
+}
+
+func newTableRetries() *tableRetries {
+	return &tableRetries{
+		failures:   make(map[string]int),
+		skipRounds: make(map[string]int),
+	}
 }
 
-func newConsumer(ctx context.Context, dwConnector coreinterfaces.Connector, sinkUri *url.URL, configFile, timezone string, credential *credentials.Value) (*consumer, error) {
+// skip returns whether the table should be skipped in this round.
+func (r *tableRetries) skip(table string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.skipRounds[table] == 0 {
+		return false
+	}
+	r.skipRounds[table]--
+	return true
+}
+
+// fail records a failed round of the table, and returns the number of consecutive failed rounds.
+// The table is retried in the next round after the first failure, then the rounds to skip are
+// doubled after each failure.
+func (r *tableRetries) fail(table string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[table]++
+	skipRounds := 0
+	for i := 1; i < r.failures[table] && skipRounds < maxTableBackoffRounds; i++ {
+		skipRounds = skipRounds*2 + 1
+	}
+	if skipRounds > maxTableBackoffRounds {
+		skipRounds = maxTableBackoffRounds
+	}
+	r.skipRounds[table] = skipRounds
+	return r.failures[table]
+}
+
+// succeed resets the failures of the table.
+func (r *tableRetries) succeed(table string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, table)
+	delete(r.skipRounds, table)
+}
+
+func newConsumer(ctx context.Context, dwConnector coreinterfaces.Connector, tidbConfig *tidbsql.TiDBConfig, sinkUri *url.URL, configFile, timezone, csvQuote string, credential *credentials.Value, keepFiles bool, concurrency int, onTableRenamed TableRenamedFunc, onTableCreated TableCreatedFunc) (*consumer, error) {
 	_, err := putil.GetTimezone(timezone)
 	if err != nil {
 		return nil, errors.Annotate(err, "can not load timezone")
 	}
+	if concurrency <= 0 {
+		return nil, errors.Errorf("invalid increment concurrency %d", concurrency)
+	}
 	serverCfg := config.GetGlobalServerConfig().Clone()
 	serverCfg.TZ = timezone
 	config.StoreGlobalServerConfig(serverCfg)
@@ -97,20 +203,37 @@ func newConsumer(ctx context.Context, dwConnector coreinterfaces.Connector, sink
 		return nil, err
 	}
 
+	// resume from the checkpoint, the applied dml files will not be returned by getNewFiles.
+	checkpoint, err := newCheckpointStore(ctx, storage)
+	if err != nil {
+		log.Error("failed to load checkpoint", zap.Error(err))
+		return nil, err
+	}
+
 	return &consumer{
 		replicationCfg:  replicaConfig,
 		externalStorage: storage,
 		fileExtension:   extension,
+		csvQuote:        csvQuote,
 		errCh:           make(chan error, 1),
-		tableDMLIdxMap:  make(map[cloudstorage.DmlPathKey]uint64),
+		tableDMLIdxMap:  checkpoint.fileIndexes(),
 		tableDefMap:     make(map[string]map[uint64]*cloudstorage.TableDefinition),
 		tableIDGenerator: &fakeTableIDGenerator{
 			tableIDs: make(map[string]int64),
 		},
-		sampleConnector: dwConnector,
-		dwConnectorMap:  make(map[model.TableID]coreinterfaces.Connector),
-		awsCredential:   credential,
-		sinkURI:         sinkUri,
+		sampleConnector:   dwConnector,
+		dwConnectorMap:    make(map[model.TableID]coreinterfaces.Connector),
+		initializedTables: make(map[model.TableID]bool),
+		tidbConfig:        tidbConfig,
+		awsCredential:     credential,
+		sinkURI:           sinkUri,
+		checkpoint:        checkpoint,
+		keepFiles:         keepFiles,
+		concurrency:       concurrency,
+		onTableRenamed:    onTableRenamed,
+		onTableCreated:    onTableCreated,
+		trimmedCommitTs:   make(map[tidbsql.TableFQN]uint64),
+		tableRetries:      newTableRetries(),
 	}, nil
 }
 
@@ -189,25 +312,45 @@ func (c *consumer) getNewFiles(
 
 func (c *consumer) syncExecDDLEvents(
 	ctx context.Context,
+	connector coreinterfaces.Connector,
 	tableDef cloudstorage.TableDefinition,
 	tableID int64,
 	key cloudstorage.DmlPathKey,
 ) error {
 	if len(tableDef.Query) == 0 {
 		// schema.json file without query is used to initialize the schema.
-		if err := c.dwConnectorMap[tableID].InitSchema(tableDef.Columns); err != nil {
+		if err := c.initSchema(connector, tableID, tableDef.Table, tableDef.Columns); err != nil {
 			return errors.Trace(err)
 		}
 	} else {
-		// TODO: make this block is atomic
-		if err := c.dwConnectorMap[tableID].ExecDDL(tableDef); err != nil {
-			// FIXME: if there is a DDL before all the DMLs, will return error here.
-			return errors.Annotate(err,
-				fmt.Sprintf("Please check the DDL query, "+
-					"if necessary, please manually execute the DDL query in data warehouse, "+
-					"update the `query` of the %s%s/%s/meta/schema_%d_{hash}.json to empty, "+
-					"and restart the program",
-					c.externalStorage.URI(), tableDef.Schema, tableDef.Table, tableDef.TableVersion))
+		if c.checkpoint.isTableVersionApplied(key.SchemaPathKey) {
+			// The DDL has been applied before restart, but the query in the table definition file
+			// has not been cleared yet. Only initialize the schema to avoid executing the DDL twice.
+			log.Info("DDL has been applied, skip it", zap.String("table", key.GetKey()), zap.Uint64("tableVersion", tableDef.TableVersion))
+			if err := c.initSchema(connector, tableID, tableDef.Table, tableDef.Columns); err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			if err := c.initSchemaBeforeDDL(connector, tableID, tableDef); err != nil {
+				return errors.Trace(err)
+			}
+			if tableDef.Type == timodel.ActionCreateTable && c.onTableCreated != nil {
+				table := tidbsql.TableFQN{Schema: tableDef.Schema, Name: tableDef.Table}
+				if err := c.onTableCreated(ctx, table, tableDef.TableVersion); err != nil {
+					return errors.Annotatef(err, "Failed to handle the created table %s", table)
+				}
+			}
+			if err := connector.ExecDDL(tableDef); err != nil {
+				return errors.Annotate(err,
+					fmt.Sprintf("Please check the DDL query, "+
+						"if necessary, please manually execute the DDL query in data warehouse, "+
+						"and update the `query` of the %s%s/%s/meta/schema_%d_{hash}.json to empty, "+
+						"then the DDL will be skipped when the table is retried",
+						c.externalStorage.URI(), tableDef.Schema, tableDef.Table, tableDef.TableVersion))
+			}
+			if err := c.checkpoint.updateTableVersion(ctx, key.SchemaPathKey); err != nil {
+				return errors.Trace(err)
+			}
 		}
 
 		// The following logic is used to handle pause and resume.
@@ -227,6 +370,7 @@ func (c *consumer) syncExecDDLEvents(
 			}
 		}
 		// clear the query in the current table definition file.
+		ddlTableDef := tableDef
 		tableDef.Query = ""
 		data, err := tableDef.MarshalWithQuery()
 		if err != nil {
@@ -240,37 +384,92 @@ func (c *consumer) syncExecDDLEvents(
 		if err = c.externalStorage.WriteFile(ctx, filePath, data); err != nil {
 			return errors.Trace(err)
 		}
+		// The checkpoint is pruned after the query is cleared, so that the DDL will not be executed
+		// again after restart, even if it is not recorded in the checkpoint.
+		if !c.keepFiles {
+			c.pruneCheckpoint(ddlTableDef)
+		}
 	}
 	return nil
 }
 
+// pruneCheckpoint removes the checkpoints which are useless after the DDL is applied,
+// since the dml files of them have been applied and deleted.
+func (c *consumer) pruneCheckpoint(tableDef cloudstorage.TableDefinition) {
+	if tableDef.Type == timodel.ActionDropTable {
+		c.checkpoint.removeTable(tableDef.Schema, tableDef.Table)
+		return
+	}
+	c.checkpoint.pruneOutdatedDMLs(cloudstorage.SchemaPathKey{
+		Schema:       tableDef.Schema,
+		Table:        tableDef.Table,
+		TableVersion: tableDef.TableVersion,
+	})
+	if tidbsql.IsRenameTable(tableDef.Type) {
+		if oldTable, err := tidbsql.GetRenamedTableFrom(tableDef); err == nil {
+			c.checkpoint.removeTable(oldTable.Schema, oldTable.Name)
+		}
+	}
+}
+
 func (c *consumer) syncExecDMLEvents(
 	ctx context.Context,
+	connector coreinterfaces.Connector,
 	tableDef cloudstorage.TableDefinition,
 	tableID int64,
 	key cloudstorage.DmlPathKey,
 	fileIdx uint64,
 ) error {
 	filePath := key.GenerateDMLFilePath(fileIdx, c.fileExtension, config.DefaultFileIndexWidth)
+	if c.checkpoint.isFileApplied(key, fileIdx) {
+		log.Debug("file has been applied, skip it", zap.String("path", filePath))
+		return nil
+	}
 	exist, err := c.externalStorage.FileExists(ctx, filePath)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	// We will remove the file after flush complete, so if the program restarts,
-	// the file range will start from 1 again, but the file may not exist.
-	// So we just ignore the non-exist file.
+	// The file may be removed after it is applied and there is no checkpoint for it,
+	// e.g. the checkpoint file is removed manually. So we just ignore the non-exist file.
 	if !exist {
 		log.Warn("file not exists", zap.String("path", filePath))
 		return nil
 	}
-
-	{ // TODO: make this block is atomic
-		// merge file into data warehouse
-		if err := c.dwConnectorMap[tableID].LoadIncrement(tableDef, c.sinkURI, filePath); err != nil {
-			return errors.Trace(err)
+	content, err := c.externalStorage.ReadFile(ctx, filePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	checksum := sha256.Sum256(content)
+	var minCommitTs, maxCommitTs uint64
+	// the commit ts range is only recorded for the CSV files, which are written by the changefeed created by tidb2dw
+	if putil.GetOrZero(c.replicationCfg.Sink.Protocol) == config.ProtocolCsv.String() {
+		if minCommitTs, maxCommitTs, err = getCommitTsRange(content, c.csvQuote); err != nil {
+			return errors.Annotatef(err, "failed to parse commit ts of file %s", filePath)
 		}
+	}
+	file := coreinterfaces.IncrementFile{
+		Path:        filePath,
+		Checksum:    hex.EncodeToString(checksum[:]),
+		MinCommitTs: minCommitTs,
+		MaxCommitTs: maxCommitTs,
+	}
+
+	// The file is recorded in the apply log of data warehouse in the same transaction of merging,
+	// so the file may have been merged even if it is not recorded in the checkpoint.
+	loaded, err := connector.IsIncrementLoaded(tableDef, file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if loaded {
+		log.Info("file has been merged into data warehouse, skip it", zap.String("path", filePath))
+	} else if err = connector.LoadIncrement(tableDef, c.sinkURI, file); err != nil {
+		return errors.Trace(err)
+	}
+
+	// record the checkpoint after merge complete in order to avoid duplicate merge when program restarts
+	c.checkpoint.updateDML(key, fileIdx, maxCommitTs)
 
-		// delete file after merge complete in order to avoid duplicate merge when program restarts
+	if !c.keepFiles {
 		if err = c.externalStorage.DeleteFile(ctx, filePath); err != nil {
 			return errors.Trace(err)
 		}
@@ -406,45 +605,265 @@ func (c *consumer) handleNewFiles(
 	})
 	log.Info("new files found since last round", zap.Any("keys", keys))
 
-	// TODO: support handling dml events of different tables concurrently.
-	// Note: dml events of the same table should be handled sequentially.
-	//       so we can not just pipeline this loop.
+	// Group the keys by table. The DDLs and dml events of the same table should be handled
+	// sequentially, while different tables can be handled concurrently.
+	tables := make([]string, 0)
+	tableKeys := make(map[string][]cloudstorage.DmlPathKey)
+	// The renamed table is handled in the group of the table before renamed,
+	// so that the files of the old table are applied before the rename DDL.
+	renamedTables := make(map[string]string)
 	for _, key := range keys {
+		table := key.GetKey()
+		if group, ok := renamedTables[table]; ok {
+			table = group
+		} else if oldTable, ok := c.getRenamedTableFrom(key); ok {
+			oldKey := quotes.QuoteSchema(oldTable.Schema, oldTable.Name)
+			if group, ok := renamedTables[oldKey]; ok {
+				oldKey = group
+			}
+			if _, ok := tableKeys[oldKey]; ok {
+				renamedTables[table] = oldKey
+				table = oldKey
+			}
+		}
+		if _, ok := tableKeys[table]; !ok {
+			tables = append(tables, table)
+		}
+		tableKeys[table] = append(tableKeys[table], key)
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		failedKeys []cloudstorage.DmlPathKey
+		// fatalErr is the error of a table which failed for maxTableFailures consecutive rounds
+		fatalErr error
+		sem      = make(chan struct{}, c.concurrency)
+	)
+	for _, table := range tables {
+		keys := tableKeys[table]
+		if c.tableRetries.skip(table) {
+			log.Info("skip the failed table in this round", zap.String("table", table))
+			mu.Lock()
+			failedKeys = append(failedKeys, keys...)
+			mu.Unlock()
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(table string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			idx, err := c.handleTableFiles(ctx, keys, dmlFileMap)
+			if err == nil {
+				c.tableRetries.succeed(table)
+				return
+			}
+			// Isolate the error of one table, so that the other tables will not be stalled.
+			failures := c.tableRetries.fail(table)
+			log.Error("failed to handle new files of table, will retry later",
+				zap.String("table", table), zap.Any("key", keys[idx]), zap.Int("failures", failures), zap.Error(err))
+			mu.Lock()
+			defer mu.Unlock()
+			failedKeys = append(failedKeys, keys[idx:]...)
+			if failures >= maxTableFailures && fatalErr == nil {
+				fatalErr = errors.Annotatef(err, "failed to handle new files of table %s for %d consecutive rounds", table, failures)
+			}
+		}(table)
+	}
+	wg.Wait()
+
+	c.resetFailedKeys(failedKeys)
+	return fatalErr
+}
+
+// handleTableFiles handles the new files of one table sequentially. If an error occurs,
+// it returns the index of the key which failed, and the remaining keys will not be handled.
+func (c *consumer) handleTableFiles(
+	ctx context.Context,
+	keys []cloudstorage.DmlPathKey,
+	dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange,
+) (int, error) {
+	for idx, key := range keys {
 		tableDef := c.mustGetTableDef(key.SchemaPathKey)
-		tableID := c.tableIDGenerator.generateFakeTableID(key.Schema, key.Table, key.PartitionNum)
-		if _, ok := c.dwConnectorMap[tableID]; !ok {
-			// create a new connector for the table.
-			connector, err := c.sampleConnector.Clone(
-				fmt.Sprintf("increment_stage_%s", tableDef.Table),
-				c.sinkURI,
-				c.awsCredential,
-			)
-			if err != nil {
-				return errors.Trace(err)
+		if oldTable, ok := c.getRenamedTableFrom(key); ok {
+			if err := c.followRenamedTable(ctx, oldTable, tableDef); err != nil {
+				return idx, errors.Trace(err)
 			}
-			c.dwConnectorMap[tableID] = connector
+		}
+		tableID := c.tableIDGenerator.generateFakeTableID(key.Schema, key.Table, key.PartitionNum)
+		connector, err := c.prepareConnector(tableID, tableDef)
+		if err != nil {
+			return idx, errors.Trace(err)
 		}
 
 		// if the key is a fake dml path key which is mainly used for
 		// sorting schema.json file before the dml files, which means it is a schema.json file.
 		if key.PartitionNum == fakePartitionNumForSchemaFile && len(key.Date) == 0 {
-			if err := c.syncExecDDLEvents(ctx, tableDef, tableID, key); err != nil {
-				return errors.Trace(err)
+			if err := c.syncExecDDLEvents(ctx, connector, tableDef, tableID, key); err != nil {
+				return idx, errors.Trace(err)
 			}
+			c.markSchemaInitialized(tableID)
 			continue
 		}
 
 		fileRange := dmlFileMap[key]
 		for i := fileRange.start; i <= fileRange.end; i++ {
-			if err := c.syncExecDMLEvents(ctx, tableDef, tableID, key, i); err != nil {
-				return errors.Trace(err)
+			if err := c.syncExecDMLEvents(ctx, connector, tableDef, tableID, key, i); err != nil {
+				return idx, errors.Trace(err)
 			}
 		}
 	}
+	return 0, nil
+}
 
+// initSchema initializes the schema of the connector of the table.
+func (c *consumer) initSchema(connector coreinterfaces.Connector, tableID int64, table string, columns []cloudstorage.TableCol) error {
+	if len(columns) == 0 {
+		// The table definition of a database level DDL, e.g. create schema, has no columns
+		return nil
+	}
+	if err := connector.InitSchema(table, columns); err != nil {
+		return errors.Trace(err)
+	}
+	c.markSchemaInitialized(tableID)
 	return nil
 }
 
+func (c *consumer) markSchemaInitialized(tableID int64) {
+	c.connectorMu.Lock()
+	defer c.connectorMu.Unlock()
+	c.initializedTables[tableID] = true
+}
+
+func (c *consumer) isSchemaInitialized(tableID int64) bool {
+	c.connectorMu.Lock()
+	defer c.connectorMu.Unlock()
+	return c.initializedTables[tableID]
+}
+
+// initSchemaBeforeDDL initializes the schema of the connector with the schema of TiDB before the DDL, if the DDL
+// is received before any DML of the table, e.g. the DDL is executed right after the changefeed is created.
+// Otherwise the connector can not know which columns are changed by the DDL.
+func (c *consumer) initSchemaBeforeDDL(connector coreinterfaces.Connector, tableID int64, tableDef cloudstorage.TableDefinition) error {
+	if c.isSchemaInitialized(tableID) || c.tidbConfig == nil || !tidbsql.RequiresColumns(tableDef.Type) {
+		return nil
+	}
+	table := tidbsql.TableFQN{Schema: tableDef.Schema, Name: tableDef.Table}
+	if tidbsql.IsRenameTable(tableDef.Type) {
+		oldTable, err := tidbsql.GetRenamedTableFrom(tableDef)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		table = oldTable
+	}
+	db, err := c.getTiDBConn()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	prevTiDBColumns, err := tidbsql.GetTiDBTableColumnAt(db, table.Schema, table.Name, tableDef.TableVersion-1)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to get the schema of table %s before DDL", table)
+	}
+	if len(prevTiDBColumns) == 0 {
+		// The table does not exist before the DDL
+		return nil
+	}
+	columns := tidbsql.GetColumnsBeforeDDL(prevTiDBColumns, tableDef)
+	log.Info("DDL is received before any DML, initialize the schema with the schema of TiDB before the DDL",
+		zap.String("table", table.String()), zap.Uint64("tableVersion", tableDef.TableVersion))
+	return errors.Trace(c.initSchema(connector, tableID, table.Name, columns))
+}
+
+// getTiDBConn returns the connection to TiDB, which is opened when it is used for the first time.
+func (c *consumer) getTiDBConn() (*sql.DB, error) {
+	c.tidbConnMu.Lock()
+	defer c.tidbConnMu.Unlock()
+	if c.tidbConn == nil {
+		db, err := c.tidbConfig.OpenDB()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		c.tidbConn = db
+	}
+	return c.tidbConn, nil
+}
+
+// getRenamedTableFrom returns the table before renamed if the key is the schema file of a rename table DDL
+// which has not been applied.
+func (c *consumer) getRenamedTableFrom(key cloudstorage.DmlPathKey) (tidbsql.TableFQN, bool) {
+	if key.PartitionNum != fakePartitionNumForSchemaFile || len(key.Date) != 0 {
+		return tidbsql.TableFQN{}, false
+	}
+	tableDef := c.mustGetTableDef(key.SchemaPathKey)
+	if !tidbsql.IsRenameTable(tableDef.Type) || len(tableDef.Query) == 0 || c.checkpoint.isTableVersionApplied(key.SchemaPathKey) {
+		return tidbsql.TableFQN{}, false
+	}
+	oldTable, err := tidbsql.GetRenamedTableFrom(tableDef)
+	if err != nil {
+		// The DDL will fail with the error when it is executed
+		log.Warn("Failed to get the table before renamed", zap.String("query", tableDef.Query), zap.Error(err))
+		return tidbsql.TableFQN{}, false
+	}
+	return oldTable, true
+}
+
+// followRenamedTable calls onTableRenamed, and moves the connector of the old table to the renamed table,
+// since the connector keeps the columns of the table which are required to execute the following DDLs.
+func (c *consumer) followRenamedTable(ctx context.Context, oldTable tidbsql.TableFQN, tableDef cloudstorage.TableDefinition) error {
+	newTable := tidbsql.TableFQN{Schema: tableDef.Schema, Name: tableDef.Table}
+	if c.onTableRenamed != nil {
+		if err := c.onTableRenamed(ctx, oldTable, newTable, tableDef.TableVersion); err != nil {
+			return errors.Annotatef(err, "Failed to follow the table %s renamed to %s", oldTable, newTable)
+		}
+	}
+	c.tableIDGenerator.renameTable(oldTable.Schema, oldTable.Name, newTable.Schema, newTable.Name)
+	log.Info("Table is renamed", zap.Stringer("from", oldTable), zap.Stringer("to", newTable))
+	return nil
+}
+
+// prepareConnector returns the connector of the table, a new connector is created if not exists.
+func (c *consumer) prepareConnector(tableID int64, tableDef cloudstorage.TableDefinition) (coreinterfaces.Connector, error) {
+	c.connectorMu.Lock()
+	defer c.connectorMu.Unlock()
+	if connector, ok := c.dwConnectorMap[tableID]; ok {
+		return connector, nil
+	}
+	connector, err := c.sampleConnector.Clone(
+		fmt.Sprintf("increment_stage_%s", tableDef.Table),
+		c.sinkURI,
+		c.awsCredential,
+	)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	c.dwConnectorMap[tableID] = connector
+	return connector, nil
+}
+
+// resetFailedKeys rolls back the progress of the failed keys to the checkpoint,
+// so that they will be found by getNewFiles and handled again in the next round.
+func (c *consumer) resetFailedKeys(keys []cloudstorage.DmlPathKey) {
+	for _, key := range keys {
+		if key.PartitionNum == fakePartitionNumForSchemaFile && len(key.Date) == 0 {
+			if c.checkpoint.isTableVersionApplied(key.SchemaPathKey) {
+				continue
+			}
+			// remove the table definition, so that the schema file will be parsed again.
+			delete(c.tableDefMap[key.GetKey()], key.TableVersion)
+			delete(c.tableDMLIdxMap, key)
+			continue
+		}
+		if fileIdx, ok := c.checkpoint.fileIndex(key); ok {
+			c.tableDMLIdxMap[key] = fileIdx
+		} else {
+			delete(c.tableDMLIdxMap, key)
+		}
+	}
+}
+
 func (c *consumer) run(ctx context.Context, flushInterval time.Duration) error {
 	ticker := time.NewTicker(flushInterval)
 	for {
@@ -460,9 +879,39 @@ func (c *consumer) run(ctx context.Context, flushInterval time.Duration) error {
 			return errors.Trace(err)
 		}
 
-		if err = c.handleNewFiles(ctx, dmlFileMap); err != nil {
+		err = c.handleNewFiles(ctx, dmlFileMap)
+		// The checkpoint is persisted once per round, including the progress made before the error.
+		if flushErr := c.checkpoint.flush(ctx); flushErr != nil {
+			if err == nil {
+				err = flushErr
+			} else {
+				log.Warn("failed to persist checkpoint", zap.Error(flushErr))
+			}
+		}
+		if err != nil {
 			return errors.Trace(err)
 		}
+		if time.Since(c.lastApplyLogTrim) >= applyLogTrimInterval {
+			c.trimApplyLog()
+		}
+	}
+}
+
+// trimApplyLog deletes the records in the apply log of data warehouse which are covered by the
+// persisted checkpoint, so that the apply log does not grow forever. It is called between rounds
+// after the checkpoint is flushed, and a failure is only logged since it will be retried.
+func (c *consumer) trimApplyLog() {
+	c.lastApplyLogTrim = time.Now()
+	for table, commitTs := range c.checkpoint.tableCommitTs() {
+		if commitTs <= c.trimmedCommitTs[table] {
+			continue
+		}
+		if err := c.sampleConnector.TrimApplyLog(table.Schema, table.Name, commitTs); err != nil {
+			log.Warn("failed to trim apply log", zap.Stringer("table", table), zap.Uint64("commitTs", commitTs), zap.Error(err))
+			continue
+		}
+		c.trimmedCommitTs[table] = commitTs
+		log.Info("apply log trimmed", zap.Stringer("table", table), zap.Uint64("commitTs", commitTs))
 	}
 }
 
@@ -488,7 +937,25 @@ func (g *fakeTableIDGenerator) generateFakeTableID(schema, table string, partiti
 	return g.currentTableID
 }
 
-func StartReplicateIncrement(dwConnector coreinterfaces.Connector, sinkUri *url.URL, flushInterval time.Duration, configFile, timezone string, credential *credentials.Value) error {
+// renameTable moves the table ids of the old table and its partitions to the new table.
+func (g *fakeTableIDGenerator) renameTable(oldSchema, oldTable, newSchema, newTable string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	oldKey := quotes.QuoteSchema(oldSchema, oldTable)
+	newKey := quotes.QuoteSchema(newSchema, newTable)
+	renamed := make(map[string]int64)
+	for key, tableID := range g.tableIDs {
+		if key == oldKey || strings.HasPrefix(key, oldKey+".") {
+			renamed[newKey+strings.TrimPrefix(key, oldKey)] = tableID
+			delete(g.tableIDs, key)
+		}
+	}
+	for key, tableID := range renamed {
+		g.tableIDs[key] = tableID
+	}
+}
+
+func StartReplicateIncrement(dwConnector coreinterfaces.Connector, tidbConfig *tidbsql.TiDBConfig, sinkUri *url.URL, flushInterval time.Duration, configFile, timezone, csvQuote string, credential *credentials.Value, keepFiles bool, concurrency int, onTableRenamed TableRenamedFunc, onTableCreated TableCreatedFunc) error {
 	var consumer *consumer
 	var err error
 
@@ -503,19 +970,18 @@ func StartReplicateIncrement(dwConnector coreinterfaces.Connector, sinkUri *url.
 			for _, db := range consumer.dwConnectorMap {
 				db.Close()
 			}
+			if consumer.tidbConn != nil {
+				consumer.tidbConn.Close()
+			}
 		}
 		return 0
 	}
 	defer deferFunc()
 
-	consumer, err = newConsumer(ctx, dwConnector, sinkUri, configFile, timezone, credential)
+	consumer, err = newConsumer(ctx, dwConnector, tidbConfig, sinkUri, configFile, timezone, csvQuote, credential, keepFiles, concurrency, onTableRenamed, onTableCreated)
 	if err != nil {
 		return errors.Annotate(err, "failed to create storage consumer")
 	}
-	if consumer.sinkURI.Scheme == "gcs" {
-		log.Error("Skip replicating increment. GCS does not supprt data warehouse connector now...")
-		return nil
-	}
 	if err = consumer.run(ctx, flushInterval); err != nil {
 		return errors.Annotate(err, "error occurred while running consumer")
 	}
@@ -0,0 +1,26 @@
+package replicate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableRetries(t *testing.T) {
+	r := newTableRetries()
+	require.False(t, r.skip("t1"))
+
+	// the rounds skipped after each failure are 0, 1, 3, 7, 15, 16, 16, ...
+	for failures, skipRounds := range []int{0, 1, 3, 7, 15, 16, 16} {
+		require.Equal(t, failures+1, r.fail("t1"))
+		for i := 0; i < skipRounds; i++ {
+			require.True(t, r.skip("t1"))
+			require.False(t, r.skip("t2"))
+		}
+		require.False(t, r.skip("t1"))
+	}
+
+	r.succeed("t1")
+	require.Equal(t, 1, r.fail("t1"))
+	require.False(t, r.skip("t1"))
+}
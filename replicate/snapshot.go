@@ -15,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/storageutil"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
@@ -67,6 +68,13 @@ func NewSnapshotReplicateSession(
 	{
 		switch sess.StorageWorkspaceUri.Scheme {
 		case "s3":
+			s3Options := storageutil.GetS3Options(&sess.StorageWorkspaceUri)
+			if s3Options.Region != "" || s3Options.Endpoint != "" {
+				// The region can not be resolved from a S3 compatible storage, and it is usually not needed.
+				sess.ResolvedS3Region = s3Options.Region
+				log.Info("Using specified storage region and endpoint", zap.String("region", s3Options.Region), zap.String("endpoint", s3Options.Endpoint))
+				break
+			}
 			awsSession, err := session.NewSessionWithOptions(session.Options{
 				SharedConfigState: session.SharedConfigEnable,
 			})
@@ -85,8 +93,11 @@ func NewSnapshotReplicateSession(
 			sess.ResolvedS3Region = s3Region
 			log.Info("Resolved storage region", zap.String("region", s3Region))
 		case "gcs":
+		case "azure", "azblob":
+		case "file":
+			// dumpling writes the files into the local file system directly
 		default:
-			return nil, errors.Errorf("storage must be like s3://... or gcs://...")
+			return nil, errors.Errorf("storage must be like s3://..., gcs://..., azure://... or file:///...")
 		}
 	}
 	{
@@ -123,13 +134,8 @@ func (sess *SnapshotReplicateSession) Run() error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	switch sess.StorageWorkspaceUri.Scheme {
-	case "s3":
-		if err = sess.DataWarehousePool.CopyTableSchema(sess.SourceDatabase, sess.SourceTable, sess.TiDBPool); err != nil {
-			return errors.Trace(err)
-		}
-	case "gcs":
-		log.Info("Skip table schema copy. GCS does not supprt data warehouse connector now...")
+	if err = sess.DataWarehousePool.CopyTableSchema(sess.SourceDatabase, sess.SourceTable, sess.TiDBPool); err != nil {
+		return errors.Trace(err)
 	}
 
 	wg := sync.WaitGroup{}
@@ -172,11 +178,6 @@ func (sess *SnapshotReplicateSession) Run() error {
 	status := dumper.GetStatus()
 	log.Info("Successfully dumped table from TiDB, starting to load into data warehouse", zap.Any("status", status))
 
-	if sess.StorageWorkspaceUri.Scheme == "gcs" {
-		log.Info("Skip loading. GCS does not supprt data warehouse connector now...")
-		return nil
-	}
-
 	startTime := time.Now()
 	if err = sess.loadSnapshotDataIntoDataWarehouse(); err != nil {
 		return errors.Annotate(err, "Failed to load snapshot data into data warehouse")
@@ -242,13 +243,23 @@ func (sess *SnapshotReplicateSession) buildDumperConfig() (*export.Config, error
 
 	switch sess.StorageWorkspaceUri.Scheme {
 	case "s3":
+		s3Options := storageutil.GetS3Options(&sess.StorageWorkspaceUri)
 		conf.S3.Region = sess.ResolvedS3Region
+		conf.S3.Endpoint = s3Options.Endpoint
+		conf.S3.ForcePathStyle = s3Options.ForcePathStyle
 	case "gcs":
 		credFile, found := syscall.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
 		if !found {
 			log.Error("Failed to resolve AWS credential")
 		}
 		conf.GCS.CredentialsFile = credFile
+	case "azure", "azblob":
+		azureCred, err := storageutil.GetAzureCredentialFromEnv()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		conf.Azblob.AccountName = azureCred.AccountName
+		conf.Azblob.AccountKey = azureCred.AccountKey
 	}
 
 	filesize, err := export.ParseFileSize("5GiB")